@@ -0,0 +1,233 @@
+// Command client runs one command against a server from the shell: it
+// connects to -device, reads the EID, lists the profiles or sends an APDU,
+// prints the result as text or, with -json, as JSON, and disconnects. The
+// exit code tells scripts how a run failed.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// Exit codes. flag exits with exitUsage on a bad flag as well.
+const (
+	exitOK = 0
+	// exitFailed is a command that ran and failed, on the server or the card.
+	exitFailed = 1
+	exitUsage  = 2
+	// exitConnect is a run that got no session: the server did not answer,
+	// or refused the device, the token or the client.
+	exitConnect = 3
+)
+
+func main() {
+	server := flag.String("server", "127.0.0.1:8080", "Server address")
+	device := flag.String("device", "", "Device path on the server")
+	proto := flag.String("proto", "qmi", "Driver protocol of -device")
+	slot := flag.Uint("slot", 1, "SIM slot of -device, 0 for drivers without slots")
+	authToken := flag.String("authToken", "", "Token for servers started with -authToken")
+	timeout := flag.Duration("timeout", localnet.DefaultTimeout, "Time each call may take, connect included")
+	asJSON := flag.Bool("json", false, "Print results and errors as JSON")
+	flag.Usage = usage
+	flag.Parse()
+
+	// only warnings and errors of the client library, on stderr
+	slog.SetLogLoggerLevel(slog.LevelWarn)
+
+	out := output{json: *asJSON}
+	cmd, err := parseCommand(flag.Args())
+	if err == nil && *device == "" {
+		err = errors.New("-device is required")
+	}
+	if err == nil && *slot > math.MaxUint16 {
+		err = fmt.Errorf("-slot out of range: %d", *slot)
+	}
+	if err != nil {
+		out.fail(err)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	ch, err := localnet.NewUDPConf(*server, *device, *proto, uint16(*slot), 0, localnet.NetConf{
+		AuthToken: *authToken,
+		Timeout:   *timeout,
+	})
+	if err != nil {
+		out.fail(err)
+		os.Exit(exitUsage)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, ch.(*localnet.NetContext), cmd, out)
+	stop()
+	os.Exit(code)
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage: %s [flags] command\n\nCommands:\n", os.Args[0])
+	fmt.Fprintln(w, "  eid             Read the EID of the eUICC")
+	fmt.Fprintln(w, "  profiles        List the installed profiles")
+	fmt.Fprintln(w, "  transmit <hex>  Send an APDU on the basic channel and print the response")
+	fmt.Fprintf(w, "\nExit codes: %d success, %d command failed, %d usage, %d no session\n\nFlags:\n", exitOK, exitFailed, exitUsage, exitConnect)
+	flag.PrintDefaults()
+}
+
+// command is a parsed subcommand; apdu is set for transmit.
+type command struct {
+	name string
+	apdu []byte
+}
+
+func parseCommand(args []string) (command, error) {
+	if len(args) == 0 {
+		return command{}, errors.New("missing command")
+	}
+	cmd := command{name: args[0]}
+	switch cmd.name {
+	case "eid", "profiles":
+		if len(args) != 1 {
+			return command{}, fmt.Errorf("%s takes no arguments", cmd.name)
+		}
+	case "transmit":
+		if len(args) != 2 {
+			return command{}, errors.New("transmit takes one APDU in hex")
+		}
+		apdu, err := hex.DecodeString(args[1])
+		if err != nil {
+			return command{}, fmt.Errorf("invalid APDU %q: %w", args[1], err)
+		}
+		cmd.apdu = apdu
+	default:
+		return command{}, fmt.Errorf("unknown command %q", cmd.name)
+	}
+	return cmd, nil
+}
+
+// run connects, runs cmd and disconnects, returning the exit code.
+func run(ctx context.Context, nc *localnet.NetContext, cmd command, out output) int {
+	if err := nc.ConnectContext(ctx); err != nil {
+		out.fail(fmt.Errorf("connect: %w", err))
+		return exitConnect
+	}
+	defer func() {
+		// the session is released even after an interrupt
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := nc.DisconnectContext(ctx); err != nil {
+			slog.Warn("disconnect failed", "error", err)
+		}
+	}()
+
+	var err error
+	switch cmd.name {
+	case "eid":
+		err = runEID(ctx, nc, out)
+	case "profiles":
+		err = runProfiles(ctx, nc, out)
+	case "transmit":
+		err = runTransmit(ctx, nc, cmd.apdu, out)
+	}
+	if err != nil {
+		out.fail(fmt.Errorf("%s: %w", cmd.name, err))
+		return exitFailed
+	}
+	return exitOK
+}
+
+func runEID(ctx context.Context, nc *localnet.NetContext, out output) error {
+	eid, err := nc.GetEIDContext(ctx)
+	if err != nil {
+		return err
+	}
+	return out.print(struct {
+		EID string `json:"eid"`
+	}{eid}, func(w io.Writer) {
+		fmt.Fprintln(w, eid)
+	})
+}
+
+// runProfiles prints the profiles as localnet.Profile; in JSON the state is
+// 0 for disabled and 1 for enabled.
+func runProfiles(ctx context.Context, nc *localnet.NetContext, out output) error {
+	profiles, err := nc.GetProfilesInfoContext(ctx)
+	if err != nil {
+		return err
+	}
+	return out.print(profiles, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ICCID\tSTATE\tNICKNAME\tPROVIDER\tNAME")
+		for _, p := range profiles {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.ICCID, p.State, p.Nickname, p.ServiceProviderName, p.Name)
+		}
+		tw.Flush()
+	})
+}
+
+// runTransmit prints the whole response in hex, status word included; in
+// JSON data and status word are apart. A status word other than 9000 is a
+// result, not a failure.
+func runTransmit(ctx context.Context, nc *localnet.NetContext, apdu []byte, out output) error {
+	response, err := nc.TransmitContext(ctx, apdu)
+	if err != nil {
+		return err
+	}
+	data, sw, err := localnet.SplitStatusWord(response)
+	if err != nil {
+		return err
+	}
+	return out.print(struct {
+		Data string `json:"data"`
+		SW   string `json:"sw"`
+	}{fmt.Sprintf("%X", data), fmt.Sprintf("%04X", sw)}, func(w io.Writer) {
+		fmt.Fprintf(w, "%X\n", response)
+	})
+}
+
+// output prints results to stdout and errors to stderr, as text or JSON.
+type output struct {
+	json bool
+}
+
+// print writes v as JSON, or the text form written by text.
+func (o output) print(v any, text func(w io.Writer)) error {
+	if !o.json {
+		text(os.Stdout)
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// fail reports err; in JSON with the server's error code when it has one.
+func (o output) fail(err error) {
+	if !o.json {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return
+	}
+	report := struct {
+		Error string `json:"error"`
+		Code  string `json:"code,omitempty"`
+	}{Error: err.Error()}
+	if code := localnet.ErrorCode(err); code != localnet.ErrCodeNone {
+		report.Code = code.String()
+	}
+	enc := json.NewEncoder(os.Stderr)
+	enc.SetEscapeHTML(false)
+	enc.Encode(report)
+}
@@ -0,0 +1,98 @@
+// Package apdu builds command APDUs, as in ISO 7816-4: the CLA INS P1 P2
+// header followed by Lc, the data and Le, in short or extended length form
+// as the sizes require.
+package apdu
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+const (
+	// MaxShortData and MaxShortLe are the largest data and Le a short
+	// APDU carries; anything larger switches the command to extended
+	// lengths.
+	MaxShortData = 255
+	MaxShortLe   = 256
+	// MaxExtendedData and MaxExtendedLe are the limits of extended
+	// lengths.
+	MaxExtendedData = 65535
+	MaxExtendedLe   = 65536
+)
+
+var (
+	ErrDataTooLong = errors.New("apdu: data too long")
+	ErrInvalidLe   = errors.New("apdu: invalid Le")
+)
+
+// Command is a command APDU. Lc follows from the length of Data. Le is the
+// number of response bytes expected, 0 for none; 256, or 65536 with
+// extended lengths, asks for as many as the card has.
+type Command struct {
+	CLA  byte
+	INS  byte
+	P1   byte
+	P2   byte
+	Data []byte
+	Le   int
+}
+
+// OnChannel returns the command with the class byte addressing channel:
+// channels 0 to 3 in its low bits, 4 to 19 in the further interindustry
+// form. The proprietary and command chaining bits of CLA are kept; secure
+// messaging is not supported.
+func (c Command) OnChannel(channel byte) (Command, error) {
+	if channel > localnet.MaxLogicalChannel {
+		return c, fmt.Errorf("%w: %d, expected 0 to %d", localnet.ErrInvalidChannel, channel, localnet.MaxLogicalChannel)
+	}
+	cla := c.CLA & 0x90
+	if channel < 4 {
+		c.CLA = cla | channel
+	} else {
+		c.CLA = cla | 0x40 | (channel - 4)
+	}
+	return c, nil
+}
+
+// Extended reports whether the command needs extended lengths, because its
+// data or Le exceed what a short APDU carries.
+func (c Command) Extended() bool {
+	return len(c.Data) > MaxShortData || c.Le > MaxShortLe
+}
+
+// Bytes encodes the command. Lc and Le are both short or both extended, as
+// ISO 7816-4 requires: one field too large for the short form makes the
+// whole command extended.
+func (c Command) Bytes() ([]byte, error) {
+	if len(c.Data) > MaxExtendedData {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrDataTooLong, len(c.Data), MaxExtendedData)
+	}
+	if c.Le < 0 || c.Le > MaxExtendedLe {
+		return nil, fmt.Errorf("%w: %d, expected 0 to %d", ErrInvalidLe, c.Le, MaxExtendedLe)
+	}
+
+	command := []byte{c.CLA, c.INS, c.P1, c.P2}
+	extended := c.Extended()
+	if len(c.Data) > 0 {
+		if extended {
+			command = append(command, 0x00, byte(len(c.Data)>>8), byte(len(c.Data)))
+		} else {
+			command = append(command, byte(len(c.Data)))
+		}
+		command = append(command, c.Data...)
+	}
+	if c.Le > 0 {
+		// the largest Le is encoded as zero, 256 as 00 and 65536 as 0000
+		switch {
+		case !extended:
+			command = append(command, byte(c.Le))
+		case len(c.Data) > 0:
+			command = append(command, byte(c.Le>>8), byte(c.Le))
+		default:
+			command = append(command, 0x00, byte(c.Le>>8), byte(c.Le))
+		}
+	}
+	return command, nil
+}
@@ -0,0 +1,120 @@
+package apdu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+func TestCommandBytes(t *testing.T) {
+	header := []byte{0x80, 0xE2, 0x91, 0x00}
+	data := []byte{0xBF, 0x3E, 0x03, 0x5C, 0x01, 0x5A}
+	tests := []struct {
+		name string
+		cmd  Command
+		want []byte
+	}{
+		{"case 1", Command{CLA: 0x80, INS: 0xE2, P1: 0x91}, header},
+		{"case 2 short", Command{CLA: 0x80, INS: 0xE2, P1: 0x91, Le: 0x10}, append(header, 0x10)},
+		{"case 2 short, 256 as 00", Command{CLA: 0x80, INS: 0xE2, P1: 0x91, Le: 256}, append(header, 0x00)},
+		{"case 2 extended", Command{CLA: 0x80, INS: 0xE2, P1: 0x91, Le: 257}, append(header, 0x00, 0x01, 0x01)},
+		{"case 2 extended, 65536 as 0000", Command{CLA: 0x80, INS: 0xE2, P1: 0x91, Le: 65536}, append(header, 0x00, 0x00, 0x00)},
+		{"case 3 short", Command{CLA: 0x80, INS: 0xE2, P1: 0x91, Data: data}, append(append(header, 0x06), data...)},
+		{"case 4 short", Command{CLA: 0x80, INS: 0xE2, P1: 0x91, Data: data, Le: 256}, append(append(append(header, 0x06), data...), 0x00)},
+		// an Le too large for the short form makes Lc extended as well
+		{"case 4 extended by Le", Command{CLA: 0x80, INS: 0xE2, P1: 0x91, Data: data, Le: 300}, append(append(append(header, 0x00, 0x00, 0x06), data...), 0x01, 0x2C)},
+	}
+	for _, tt := range tests {
+		got, err := tt.cmd.Bytes()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %X, want %X", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCommandBytesSwitchesToExtended(t *testing.T) {
+	for _, size := range []int{1, MaxShortData - 1, MaxShortData, MaxShortData + 1, 256, 1000, MaxExtendedData} {
+		data := bytes.Repeat([]byte{0x5A}, size)
+		cmd := Command{CLA: 0x80, INS: 0xE2, P1: 0x11, Data: data}
+		encoded, err := cmd.Bytes()
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+
+		// Lc must give the length of the data that follows it
+		var lc, offset int
+		if size <= MaxShortData {
+			if cmd.Extended() {
+				t.Errorf("%d bytes: extended, want short", size)
+			}
+			lc, offset = int(encoded[4]), 5
+		} else {
+			if !cmd.Extended() {
+				t.Errorf("%d bytes: short, want extended", size)
+			}
+			if encoded[4] != 0x00 {
+				t.Errorf("%d bytes: extended Lc opens with %02X, want 00", size, encoded[4])
+			}
+			lc, offset = int(binary.BigEndian.Uint16(encoded[5:7])), 7
+		}
+		if lc != size {
+			t.Errorf("%d bytes: Lc %d", size, lc)
+		}
+		if !bytes.Equal(encoded[offset:], data) {
+			t.Errorf("%d bytes: %d bytes follow Lc", size, len(encoded)-offset)
+		}
+	}
+}
+
+func TestCommandBytesLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  Command
+		err  error
+	}{
+		{"data over extended limit", Command{Data: make([]byte, MaxExtendedData+1)}, ErrDataTooLong},
+		{"negative Le", Command{Le: -1}, ErrInvalidLe},
+		{"Le over extended limit", Command{Le: MaxExtendedLe + 1}, ErrInvalidLe},
+	}
+	for _, tt := range tests {
+		if _, err := tt.cmd.Bytes(); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestCommandOnChannel(t *testing.T) {
+	tests := []struct {
+		cla     byte
+		channel byte
+		want    byte
+	}{
+		{0x80, 0, 0x80},
+		{0x80, 3, 0x83},
+		{0x00, 4, 0x40},
+		{0x80, 19, 0xCF},
+		// the chaining bit is kept, the old channel bits are not
+		{0x93, 1, 0x91},
+	}
+	for _, tt := range tests {
+		cmd, err := Command{CLA: tt.cla}.OnChannel(tt.channel)
+		if err != nil {
+			t.Errorf("CLA %02X on channel %d: %v", tt.cla, tt.channel, err)
+			continue
+		}
+		if cmd.CLA != tt.want {
+			t.Errorf("CLA %02X on channel %d: got %02X, want %02X", tt.cla, tt.channel, cmd.CLA, tt.want)
+		}
+	}
+
+	if _, err := (Command{}).OnChannel(localnet.MaxLogicalChannel + 1); !errors.Is(err, localnet.ErrInvalidChannel) {
+		t.Fatalf("channel %d: got %v, want ErrInvalidChannel", localnet.MaxLogicalChannel+1, err)
+	}
+}
@@ -0,0 +1,25 @@
+package driver
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+)
+
+// ErrDeviceGone is returned, or wrapped, by drivers whose device went away
+// for good, unplugged or removed from the reader.
+var ErrDeviceGone = errors.New("driver: device gone")
+
+// IsDeviceGone reports whether err means the device is gone for good, so
+// that retrying on the same channel is pointless, as opposed to a transient
+// failure such as a timeout or a card error. Besides ErrDeviceGone it
+// recognizes what a vanished character device yields: a missing device
+// file, ENODEV, ENXIO and the EIO a USB serial port returns once unplugged.
+// Drivers only get this right when they wrap the underlying error.
+func IsDeviceGone(err error) bool {
+	return errors.Is(err, ErrDeviceGone) ||
+		errors.Is(err, fs.ErrNotExist) ||
+		errors.Is(err, syscall.ENODEV) ||
+		errors.Is(err, syscall.ENXIO) ||
+		errors.Is(err, syscall.EIO)
+}
@@ -0,0 +1,14 @@
+package driver
+
+import "errors"
+
+// ErrInterrupted is returned by a Transmit that Interrupt cut short.
+var ErrInterrupted = errors.New("driver: apdu interrupted")
+
+// Interrupter is implemented by channels that can abandon an APDU in
+// flight. Interrupt makes a blocked Transmit return ErrInterrupted soon and
+// does nothing when no APDU is pending; unlike the other methods it may be
+// called from any goroutine. The server uses it for CmdAbort.
+type Interrupter interface {
+	Interrupt()
+}
@@ -0,0 +1,54 @@
+package localnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrAborted is returned by a call that Abort cut short.
+var ErrAborted = errors.New("command aborted")
+
+// abortTimeout bounds Abort, which has no context of its own.
+const abortTimeout = 5 * time.Second
+
+// Abort asks the server to abort the command this context is waiting for,
+// such as a transmit stuck on an unresponsive modem; that call then fails
+// with ErrAborted. It is meant to be called from another goroutine while
+// the call blocks, but not alongside Connect or Disconnect. Abort has no
+// effect when no command is running.
+//
+// It sends CmdAbort over a connection of its own, so it does not wait for
+// the blocked call, and finds the session by its token: sessions of
+// servers speaking ProtocolVersionLegacy cannot be aborted. Servers
+// predating CmdAbort fail it with ErrNotSupported.
+func (c *NetContext) Abort() error {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	return c.AbortContext(ctx)
+}
+
+func (c *NetContext) AbortContext(ctx context.Context) error {
+	token := c.sessionToken
+	if token == "" {
+		return fmt.Errorf("abort needs a session token: %w", ErrNotSupported)
+	}
+	if c.network == "pipe" {
+		// a second connection would start another server
+		return fmt.Errorf("abort over a pipe: %w", ErrNotSupported)
+	}
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("error establishing connection with %s %w", c.rAddr, err)
+	}
+	defer conn.Close()
+
+	aux := &NetContext{network: c.network, serverAddr: c.serverAddr, rAddr: c.rAddr, conn: conn, bufferSize: c.bufferSize, serverBufferSize: c.serverBufferSize, conf: c.conf, sessionToken: token}
+	_, err = exchange(ctx, aux, NewPacketCmd(CmdAbort))
+	if errors.Is(err, ErrCodeUnknownCommand) {
+		return fmt.Errorf("abort: %w", ErrNotSupported)
+	}
+	return err
+}
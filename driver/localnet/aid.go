@@ -0,0 +1,64 @@
+package localnet
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// MinAIDLength and MaxAIDLength bound an application identifier, a
+	// 5-byte registered provider ID followed by up to 11 bytes (ISO 7816-5).
+	MinAIDLength = 5
+	MaxAIDLength = 16
+)
+
+// Well-known eUICC applications (SGP.22).
+var (
+	// ISDRAID is the AID of the ISD-R, the application an LPA talks to.
+	ISDRAID = []byte{0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x89, 0x00, 0x00, 0x01, 0x00}
+	// ECASDAID is the AID of the ECASD, which holds the eUICC certificates.
+	ECASDAID = []byte{0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x89, 0x00, 0x00, 0x02, 0x00}
+)
+
+var ErrInvalidAID = errors.New("invalid aid")
+
+// CheckAID rejects an AID outside MinAIDLength to MaxAIDLength bytes.
+func CheckAID(aid []byte) error {
+	if len(aid) < MinAIDLength || len(aid) > MaxAIDLength {
+		return fmt.Errorf("%w: %d bytes, need %d to %d", ErrInvalidAID, len(aid), MinAIDLength, MaxAIDLength)
+	}
+	return nil
+}
+
+// ParseAID decodes an AID written in hex, such as
+// "A0000005591010FFFFFFFF8900000100", and checks its length. Spaces and
+// colons between bytes are ignored.
+func ParseAID(aid string) ([]byte, error) {
+	cleaned := strings.NewReplacer(" ", "", ":", "").Replace(aid)
+	decoded, err := hex.DecodeString(cleaned)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q is not hex", ErrInvalidAID, aid)
+	}
+	if err = CheckAID(decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// OpenLogicalChannelHex opens a logical channel on the application whose
+// AID is given in hex, failing before anything is sent when it is not a
+// valid AID.
+func (c *NetContext) OpenLogicalChannelHex(aid string) (byte, error) {
+	return c.OpenLogicalChannelHexContext(context.Background(), aid)
+}
+
+func (c *NetContext) OpenLogicalChannelHexContext(ctx context.Context, aid string) (byte, error) {
+	decoded, err := ParseAID(aid)
+	if err != nil {
+		return InvalidChannel, err
+	}
+	return c.OpenLogicalChannelContext(ctx, decoded)
+}
@@ -0,0 +1,30 @@
+package localnet
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// MinAPDUSize is the CLA INS P1 P2 header every command APDU starts with.
+	MinAPDUSize = 4
+	// DefaultMaxAPDUSize leaves room for extended length APDUs.
+	DefaultMaxAPDUSize = 65535
+)
+
+var (
+	ErrAPDUTooShort = errors.New("apdu too short")
+	ErrAPDUTooLarge = errors.New("apdu too large")
+)
+
+// CheckAPDUSize rejects an APDU shorter than its header or longer than max
+// bytes before it is sent to the card.
+func CheckAPDUSize(apdu []byte, max int) error {
+	if len(apdu) < MinAPDUSize {
+		return fmt.Errorf("%w: %d bytes, need at least %d", ErrAPDUTooShort, len(apdu), MinAPDUSize)
+	}
+	if len(apdu) > max {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrAPDUTooLarge, len(apdu), max)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package localnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MaxBatchSize caps the APDUs of one CmdTransmitBatch.
+const MaxBatchSize = 256
+
+// BatchError reports the APDU that stopped a batch. The responses returned
+// alongside it belong to the APDUs before Index. Code classifies Msg.
+type BatchError struct {
+	Index int
+	Msg   string
+	Code  ErrCode
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch: apdu %d failed: %s", e.Index, e.Msg)
+}
+
+// Is lets errors.Is match the ErrCode of the failure, and ErrDeviceGone when
+// the device went away mid-batch.
+func (e *BatchError) Is(target error) bool {
+	if target == ErrDeviceGone {
+		return e.Code == ErrCodeDeviceGone
+	}
+	return target == e.Code
+}
+
+// TransmitBatch sends apdus in one round trip. The server runs them in order
+// and stops at the first failure, reported as a *BatchError.
+func (c *NetContext) TransmitBatch(apdus [][]byte) ([][]byte, error) {
+	return c.TransmitBatchContext(context.Background(), apdus)
+}
+
+func (c *NetContext) TransmitBatchContext(ctx context.Context, apdus [][]byte) ([][]byte, error) {
+	if len(apdus) == 0 {
+		return nil, nil
+	}
+	if len(apdus) > MaxBatchSize {
+		return nil, fmt.Errorf("batch: %d apdus exceed the limit of %d", len(apdus), MaxBatchSize)
+	}
+	for i, apdu := range apdus {
+		if err := CheckAPDUSize(apdu, c.maxAPDUSize()); err != nil {
+			return nil, fmt.Errorf("batch: apdu %d %w", i, err)
+		}
+	}
+
+	pcRcv, err := remoteCallPacket(ctx, c, NewPacketBatch(apdus))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, ok := pcRcv.(IPacketBatchResp)
+	if !ok {
+		return nil, errors.New("batch: unexpected response")
+	}
+	c.traceBatch(apdus, resp)
+	if resp.GetFailedIndex() >= 0 {
+		err = &BatchError{Index: int(resp.GetFailedIndex()), Msg: resp.GetFailedErr(), Code: codeForMessage(resp.GetFailedErr())}
+		if errors.Is(err, ErrDeviceGone) {
+			c.lastActivity.Store(0)
+		}
+		return resp.GetResponses(), err
+	}
+	return resp.GetResponses(), nil
+}
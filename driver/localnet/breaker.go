@@ -0,0 +1,108 @@
+package localnet
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// DefaultBreakerCooldown is how long an open breaker fails calls when
+// NetConf.BreakerCooldown is not set.
+const DefaultBreakerCooldown = 30 * time.Second
+
+// ErrCircuitOpen is returned without contacting the server while the
+// breaker is open after repeated failures.
+var ErrCircuitOpen = errors.New("circuit open, server failing")
+
+// serverError is an error the server answered with. The server is
+// reachable, so it does not count as a failure for the breaker, unless the
+// card timed out.
+type serverError struct {
+	msg  string
+	code ErrCode
+}
+
+func (e *serverError) Error() string {
+	return "error on server " + e.msg
+}
+
+// Is lets errors.Is match the ErrCode of the error, ErrAborted for a
+// command cut short by Abort, ErrDeviceGone for a session closed because its
+// device went away, ErrMaxSessionDuration for one ended for its age and
+// ErrTooManyChannels for an open over the session's channel limit.
+func (e *serverError) Is(target error) bool {
+	if code, ok := target.(ErrCode); ok {
+		return e.code == code
+	}
+	switch target {
+	case ErrAborted:
+		return e.code == ErrCodeAborted
+	case ErrDeviceGone:
+		return e.code == ErrCodeDeviceGone
+	case ErrMaxSessionDuration:
+		return e.msg == ErrMaxSessionDuration.Error()
+	case ErrTooManyChannels:
+		return strings.Contains(e.msg, ErrTooManyChannels.Error())
+	}
+	return false
+}
+
+// breaker fails calls fast once NetConf.BreakerThreshold calls in a row got
+// no answer in time. After the cooldown one call goes through as a probe:
+// success closes the breaker, failure opens it for another cooldown. Its
+// state is guarded by NetContext.mu.
+type breaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports ErrCircuitOpen while the breaker is open.
+func (b *breaker) allow(conf NetConf) error {
+	if conf.BreakerThreshold <= 0 || b.failures < conf.BreakerThreshold {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record counts the outcome of a call that allow let through.
+func (b *breaker) record(conf NetConf, serverAddr string, err error) {
+	if conf.BreakerThreshold <= 0 {
+		return
+	}
+	if !breakerFailure(err) {
+		if b.failures >= conf.BreakerThreshold {
+			slog.Info("circuit closed, server answering again", "server", serverAddr)
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures < conf.BreakerThreshold {
+		return
+	}
+	cooldown := conf.BreakerCooldown
+	if cooldown == 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+	b.openUntil = time.Now().Add(cooldown)
+	slog.Warn("circuit open, failing calls fast", "server", serverAddr, "failures", b.failures, "cooldown", cooldown)
+}
+
+// breakerFailure reports whether err means the server or its card is not
+// answering. Calls the caller cancelled say nothing about the server.
+func breakerFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var se *serverError
+	if errors.As(err, &se) {
+		return se.code == ErrCodeTimeout
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package localnet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// Every transmit encodes and decodes a packet on both ends, so the buffers
+// and gzip state that takes are pooled rather than allocated per call.
+// Pooled objects are Reset when taken, and no slice into a pooled buffer
+// outlives the Encode or Decode call that took it.
+
+// maxPooledBuffer keeps the buffer of an occasional large packet, a
+// fragmented profile download say, from staying pinned in the pool.
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// gzipWriters holds a pool per compression level, since a gzip.Writer keeps
+// the level it was created with across Reset.
+var gzipWriters [gzip.BestCompression + 1]sync.Pool
+
+var gzipReaders sync.Pool
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+func getGzipWriter(w io.Writer, level int) (*gzip.Writer, error) {
+	if level >= 0 && level < len(gzipWriters) {
+		if gw, ok := gzipWriters[level].Get().(*gzip.Writer); ok {
+			gw.Reset(w)
+			return gw, nil
+		}
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func putGzipWriter(gw *gzip.Writer, level int) {
+	if level >= 0 && level < len(gzipWriters) {
+		gzipWriters[level].Put(gw)
+	}
+}
+
+func getGzipReader(r io.Reader) (*gzip.Reader, error) {
+	if gr, ok := gzipReaders.Get().(*gzip.Reader); ok {
+		if err := gr.Reset(r); err != nil {
+			gzipReaders.Put(gr)
+			return nil, err
+		}
+		return gr, nil
+	}
+	return gzip.NewReader(r)
+}
+
+func putGzipReader(gr *gzip.Reader) {
+	gzipReaders.Put(gr)
+}
@@ -0,0 +1,123 @@
+package localnet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"hash/crc32"
+	"io"
+	"sync"
+	"testing"
+)
+
+// BenchmarkPooledEncode compares Encode and Decode, which take their
+// buffers and gzip state from the pools, with the same work allocating them
+// on every call, as before the pools.
+func BenchmarkPooledEncode(b *testing.B) {
+	packet := NewPacketBatch(storeDataBlocks(8, structuredBlock))
+	wire := Wire{Version: WireV1, Codec: BinaryCodec{}, Compression: FormatGzip}
+	encoded, err := EncodeWire(packet, wire)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("encode/pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := EncodeWire(packet, wire); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encode/unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			raw := new(bytes.Buffer)
+			if err := marshalTo(raw, wire.Codec, packet); err != nil {
+				b.Fatal(err)
+			}
+			zipped := new(bytes.Buffer)
+			gw, err := gzip.NewWriterLevel(zipped, DefaultCompressionLevel)
+			if err != nil {
+				b.Fatal(err)
+			}
+			gw.Write(raw.Bytes())
+			gw.Close()
+			seal(wire.Codec.ID()|FormatGzip, zipped.Bytes())
+		}
+	})
+
+	b.Run("decode/pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := Decode(encoded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("decode/unpooled", func(b *testing.B) {
+		// the format byte and checksum around the compressed payload
+		payload := encoded[1 : len(encoded)-crc32.Size]
+		b.ReportAllocs()
+		for b.Loop() {
+			gr, err := gzip.NewReader(bytes.NewReader(payload))
+			if err != nil {
+				b.Fatal(err)
+			}
+			raw, err := io.ReadAll(gr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := wire.Codec.Unmarshal(bytes.NewReader(raw)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestPooledBuffersDoNotLeak(t *testing.T) {
+	// a large packet leaves its bytes in the pooled buffers, which the small
+	// one encoded after it must not pick up
+	large := NewPacketBatch(storeDataBlocks(bppSegments, structuredBlock))
+	small := NewPacketBody(CmdResponse, []byte{0x90, 0x00})
+	wires := []Wire{
+		{Version: WireV1, Codec: BinaryCodec{}, Compression: FormatGzip},
+		{Version: WireV1, Codec: GobCodec{}, Compression: FormatZstd},
+		LegacyWire,
+	}
+
+	var wg sync.WaitGroup
+	for _, wire := range wires {
+		want, err := wire.Codec.Marshal(small)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 50 {
+					for _, packet := range []IPacketCmd{large, small} {
+						encoded, err := EncodeWire(packet, wire)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						decoded, err := Decode(encoded)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if packet != small {
+							continue
+						}
+						if got, _ := wire.Codec.Marshal(decoded); !bytes.Equal(got, want) {
+							t.Errorf("small packet decoded as %X, want %X", got, want)
+							return
+						}
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
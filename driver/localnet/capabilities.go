@@ -0,0 +1,102 @@
+package localnet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Features a server reports in its capabilities.
+const (
+	FeatureFragmentation    = "fragmentation"
+	FeatureBinaryCodec      = "binaryCodec"
+	FeatureBatch            = "batch"
+	FeatureStatus           = "status"
+	FeatureSlots            = "slots"
+	FeatureReset            = "reset"
+	FeatureEvents           = "events"
+	FeatureEID              = "eid"
+	FeatureTransmitOn       = "transmitOn"
+	FeatureAbort            = "abort"
+	FeatureSelect           = "select"
+	FeatureAdminKick        = "adminKick"
+	FeatureStoreData        = "storeData"
+	FeatureHealth           = "health"
+	FeatureProfiles         = "profiles"
+	FeatureSwitchSlot       = "switchSlot"
+	FeatureKeepChannelAlive = "keepChannelAlive"
+	FeatureRepeatLast       = "repeatLast"
+	FeatureConnectAID       = "connectAID"
+	FeatureListProtocols    = "listProtocols"
+	FeatureWideSlots        = "wideSlots"
+	FeatureAutoGetResponse  = "autoGetResponse"
+	FeatureConcurrent       = "concurrentSessions"
+	FeatureZstd             = "zstd"
+	FeatureProfileState     = "profileState"
+	FeatureSelfTest         = "selfTest"
+	FeatureConnectChallenge = "connectChallenge"
+)
+
+// ErrNotSupported is returned without sending anything when the server does
+// not offer the feature a call needs.
+var ErrNotSupported = errors.New("not supported by server")
+
+// ServerCapabilities is the CmdCapabilities response, carried as JSON in a
+// PacketBody like ServerStatus.
+type ServerCapabilities struct {
+	ProtocolVersion uint16   `json:"protocolVersion"`
+	Features        []string `json:"features"`
+}
+
+// Capabilities asks the server which features it offers. Like Status it
+// needs no session. Servers predating CmdCapabilities fail it with
+// ErrNotSupported.
+func (c *NetContext) Capabilities() ([]string, error) {
+	return c.CapabilitiesContext(context.Background())
+}
+
+func (c *NetContext) CapabilitiesContext(ctx context.Context) ([]string, error) {
+	release, err := c.borrowConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	bb, err := remoteCall(ctx, c, NewPacketCapabilities(c.conf.AuthToken))
+	if errors.Is(err, ErrCodeUnknownCommand) {
+		return nil, fmt.Errorf("capabilities: %w", ErrNotSupported)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities := new(ServerCapabilities)
+	if err = json.Unmarshal(bb, capabilities); err != nil {
+		return nil, fmt.Errorf("capabilities: error decoding response %w", err)
+	}
+	return capabilities.Features, nil
+}
+
+// requireFeature fails with ErrNotSupported unless the server offers
+// feature, asking it once per Connect. Only features added after
+// CmdCapabilities are gated this way, so a server predating it offers none
+// of them; an older server would not even decode their packets and leave
+// the call waiting for a reply.
+func (c *NetContext) requireFeature(ctx context.Context, feature string) error {
+	c.capsMu.Lock()
+	defer c.capsMu.Unlock()
+
+	if c.features == nil {
+		features, err := c.CapabilitiesContext(ctx)
+		if err != nil && !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+		c.features = append([]string{}, features...)
+	}
+	if !slices.Contains(c.features, feature) {
+		return fmt.Errorf("%s: %w", feature, ErrNotSupported)
+	}
+	return nil
+}
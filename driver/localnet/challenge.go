@@ -0,0 +1,35 @@
+package localnet
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"log/slog"
+)
+
+// ChallengeProof is the HMAC-SHA256 of nonce keyed with authToken, which
+// may be empty when the server takes no tokens. Sending it proves the
+// client received the nonce at the address its connect came from.
+func ChallengeProof(nonce []byte, authToken string) []byte {
+	mac := hmac.New(sha256.New, []byte(authToken))
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// answerChallenge proves the client's address to a server that answered
+// connect with a PacketChallenge, and returns the server's reply to the
+// proof: the connect response. Any other reply is returned as it is.
+func (c *NetContext) answerChallenge(ctx context.Context, connect IPacketCmd, pcRcv IPacketCmd) (IPacketCmd, error) {
+	challenge, ok := pcRcv.(IPacketChallenge)
+	if !ok {
+		return pcRcv, nil
+	}
+	slog.Debug("answering connect challenge", "server", c.rAddr)
+
+	var authToken string
+	if pcConn, ok := connect.(IPacketConnect); ok {
+		authToken = pcConn.GetAuthToken()
+	}
+	nonce := challenge.GetNonce()
+	return remoteCallPacket(ctx, c, NewPacketConnectProof(nonce, ChallengeProof(nonce, authToken)))
+}
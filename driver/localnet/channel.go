@@ -0,0 +1,58 @@
+package localnet
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxLogicalChannel is the highest logical channel ISO 7816-4 allows. The
+// basic channel 0 is always open and never returned by an open.
+const MaxLogicalChannel = 19
+
+var ErrInvalidChannel = errors.New("invalid logical channel")
+
+// ErrTooManyChannels is an open refused because the session already holds
+// as many logical channels as the server's -maxChannels allows. errors.Is
+// matches the server's error against it.
+var ErrTooManyChannels = errors.New("too many logical channels")
+
+// CheckChannel rejects a logical channel outside 1 to MaxLogicalChannel.
+func CheckChannel(channel byte) error {
+	if channel < 1 || channel > MaxLogicalChannel {
+		return fmt.Errorf("%w: %d, expected 1 to %d", ErrInvalidChannel, channel, MaxLogicalChannel)
+	}
+	return nil
+}
+
+// ChannelOfCLA returns the logical channel an interindustry or GlobalPlatform
+// class byte addresses: channels 0 to 3 in its low bits, 4 to 19 in the
+// further interindustry form with bit 0x40 set.
+func ChannelOfCLA(cla byte) byte {
+	if cla&0x40 == 0 {
+		return cla & 0x03
+	}
+	return 4 + cla&0x0F
+}
+
+// CheckCLAChannel rejects an APDU whose class byte does not address channel.
+func CheckCLAChannel(channel byte, apdu []byte) error {
+	if len(apdu) == 0 {
+		return ErrAPDUTooShort
+	}
+	if addressed := ChannelOfCLA(apdu[0]); addressed != channel {
+		return fmt.Errorf("%w: CLA %02X addresses channel %d, not %d", ErrInvalidChannel, apdu[0], addressed, channel)
+	}
+	return nil
+}
+
+// parseChannel reads the channel number from an opch response body, which
+// holds exactly one valid channel byte.
+func parseChannel(body []byte) (byte, error) {
+	if len(body) != 1 {
+		return InvalidChannel, fmt.Errorf("%w: expected one channel byte, received %d: %X", ErrInvalidChannel, len(body), body)
+	}
+	if err := CheckChannel(body[0]); err != nil {
+		return InvalidChannel, err
+	}
+	return body[0], nil
+}
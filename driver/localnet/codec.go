@@ -0,0 +1,380 @@
+package localnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// Codec turns packets into bytes before compression. Its ID occupies the high
+// nibble of the format byte so Decode can pick the matching codec.
+type Codec interface {
+	ID() byte
+	Marshal(p IPacketCmd) ([]byte, error)
+	Unmarshal(r io.Reader) (IPacketCmd, error)
+}
+
+const (
+	CodecGob    byte = 0x00
+	CodecBinary byte = 0x10
+
+	codecMask byte = 0xF0
+)
+
+// Wire describes how a packet travels: the envelope version, the codec and,
+// for WireV1, the compression of packets worth compressing, FormatGzip or
+// FormatZstd. Zero means FormatGzip.
+type Wire struct {
+	Version     WireVersion
+	Codec       Codec
+	Compression byte
+}
+
+var (
+	codecMu      sync.RWMutex
+	currentCodec Codec = GobCodec{}
+
+	codecs = map[byte]Codec{
+		CodecGob:    GobCodec{},
+		CodecBinary: BinaryCodec{},
+	}
+
+	binaryTags  = make(map[reflect.Type]byte)
+	binaryTypes = make(map[byte]reflect.Type)
+)
+
+// SetCodec selects the codec Encode uses. Decode always accepts every codec.
+func SetCodec(c Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	currentCodec = c
+}
+
+// DefaultWire is the wire Encode writes with the current settings.
+func DefaultWire() Wire {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	return Wire{Version: CurrentWireVersion, Codec: currentCodec}
+}
+
+// bufferMarshaler is implemented by the codecs of this package, which
+// Encode lets write straight into its pooled buffer.
+type bufferMarshaler interface {
+	marshalTo(buf *bytes.Buffer, p IPacketCmd) error
+}
+
+// marshalTo appends the encoding of p to buf.
+func marshalTo(buf *bytes.Buffer, c Codec, p IPacketCmd) error {
+	if bm, ok := c.(bufferMarshaler); ok {
+		return bm.marshalTo(buf, p)
+	}
+	byteArray, err := c.Marshal(p)
+	if err != nil {
+		return err
+	}
+	buf.Write(byteArray)
+	return nil
+}
+
+func codecByID(id byte) (Codec, error) {
+	c, ok := codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec 0x%02X", id)
+	}
+	return c, nil
+}
+
+// registerPacket makes a packet type known to both codecs; tag is the first
+// byte of its binary encoding and must never be reused. It is only called
+// from init, so encoding and decoding never touch the gob registry.
+func registerPacket(tag byte, p IPacketCmd) {
+	gob.Register(p)
+
+	rt := reflect.TypeOf(p).Elem()
+	if _, dup := binaryTypes[tag]; dup {
+		panic(fmt.Sprintf("localnet: duplicate binary tag 0x%02X for %s", tag, rt))
+	}
+	binaryTags[rt] = tag
+	binaryTypes[tag] = rt
+}
+
+type GobCodec struct{}
+
+func (GobCodec) ID() byte {
+	return CodecGob
+}
+
+func (c GobCodec) Marshal(p IPacketCmd) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.marshalTo(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) marshalTo(buf *bytes.Buffer, p IPacketCmd) error {
+	enc := gob.NewEncoder(buf)
+	if err := enc.Encode(&p); err != nil {
+		return fmt.Errorf("encode, error using gob: %w", err)
+	}
+	return nil
+}
+
+func (GobCodec) Unmarshal(r io.Reader) (p IPacketCmd, err error) {
+	dec := gob.NewDecoder(r)
+	err = dec.Decode(&p)
+	return p, err
+}
+
+// BinaryCodec is a language-neutral encoding: a packet tag byte followed by
+// the struct fields in declaration order, embedded structs first. Strings and
+// byte slices are prefixed with a uint32 length, other slices with a uint32
+// element count, and integers are fixed-width big-endian.
+type BinaryCodec struct{}
+
+func (BinaryCodec) ID() byte {
+	return CodecBinary
+}
+
+func (BinaryCodec) Marshal(p IPacketCmd) ([]byte, error) {
+	return appendPacket(nil, p)
+}
+
+func (BinaryCodec) marshalTo(buf *bytes.Buffer, p IPacketCmd) error {
+	byteArray, err := appendPacket(buf.AvailableBuffer(), p)
+	if err != nil {
+		return err
+	}
+	buf.Write(byteArray)
+	return nil
+}
+
+func appendPacket(buf []byte, p IPacketCmd) ([]byte, error) {
+	v := reflect.Indirect(reflect.ValueOf(p))
+	tag, ok := binaryTags[v.Type()]
+	if !ok {
+		return nil, fmt.Errorf("encode, unregistered packet type %s", v.Type())
+	}
+	return appendBinary(append(buf, tag), v)
+}
+
+func (BinaryCodec) Unmarshal(r io.Reader) (IPacketCmd, error) {
+	byteArray, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(byteArray) == 0 {
+		return nil, errors.New("decode, empty binary packet")
+	}
+
+	rt, ok := binaryTypes[byteArray[0]]
+	if !ok {
+		return nil, fmt.Errorf("decode, unknown packet tag 0x%02X", byteArray[0])
+	}
+
+	pv := reflect.New(rt)
+	br := binaryReader{data: byteArray[1:]}
+	if err = br.read(pv.Elem()); err != nil {
+		return nil, fmt.Errorf("decode, %s: %w", rt, err)
+	}
+	if len(br.data) != 0 {
+		return nil, fmt.Errorf("decode, %d trailing bytes after %s", len(br.data), rt)
+	}
+	return pv.Interface().(IPacketCmd), nil
+}
+
+func appendBinary(buf []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	switch v.Kind() {
+	case reflect.String:
+		buf = binary.BigEndian.AppendUint32(buf, uint32(v.Len()))
+		return append(buf, v.String()...), nil
+	case reflect.Slice:
+		buf = binary.BigEndian.AppendUint32(buf, uint32(v.Len()))
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append(buf, v.Bytes()...), nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if buf, err = appendBinary(buf, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if buf, err = appendBinary(buf, v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Uint8:
+		return append(buf, byte(v.Uint())), nil
+	case reflect.Uint16:
+		return binary.BigEndian.AppendUint16(buf, uint16(v.Uint())), nil
+	case reflect.Uint32:
+		return binary.BigEndian.AppendUint32(buf, uint32(v.Uint())), nil
+	case reflect.Uint64, reflect.Uint:
+		return binary.BigEndian.AppendUint64(buf, v.Uint()), nil
+	case reflect.Int8:
+		return append(buf, byte(v.Int())), nil
+	case reflect.Int16:
+		return binary.BigEndian.AppendUint16(buf, uint16(v.Int())), nil
+	case reflect.Int32:
+		return binary.BigEndian.AppendUint32(buf, uint32(v.Int())), nil
+	case reflect.Int64, reflect.Int:
+		return binary.BigEndian.AppendUint64(buf, uint64(v.Int())), nil
+	default:
+		return nil, fmt.Errorf("encode, unsupported field kind %s", v.Kind())
+	}
+}
+
+type binaryReader struct {
+	data []byte
+}
+
+func (br *binaryReader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(br.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := br.data[:n]
+	br.data = br.data[n:]
+	return b, nil
+}
+
+func (br *binaryReader) length() (int, error) {
+	b, err := br.next(4)
+	if err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(b)
+	if n > uint32(len(br.data)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+func (br *binaryReader) read(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.String:
+		n, err := br.length()
+		if err != nil {
+			return err
+		}
+		b, _ := br.next(n)
+		v.SetString(string(b))
+	case reflect.Slice:
+		n, err := br.length()
+		if err != nil || n == 0 {
+			return err
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, _ := br.next(n)
+			v.SetBytes(bytes.Clone(b))
+			return nil
+		}
+		// n counts elements, each at least minEncodedSize bytes on the wire,
+		// so a hostile count fails before it is allocated
+		if n > len(br.data)/minEncodedSize(v.Type().Elem()) {
+			return io.ErrUnexpectedEOF
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		for i := 0; i < n; i++ {
+			if err = br.read(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := br.read(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Bool:
+		b, err := br.next(1)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b[0] != 0)
+	case reflect.Uint8, reflect.Int8:
+		b, err := br.next(1)
+		if err != nil {
+			return err
+		}
+		br.setInt(v, uint64(b[0]))
+	case reflect.Uint16, reflect.Int16:
+		b, err := br.next(2)
+		if err != nil {
+			return err
+		}
+		br.setInt(v, uint64(binary.BigEndian.Uint16(b)))
+	case reflect.Uint32, reflect.Int32:
+		b, err := br.next(4)
+		if err != nil {
+			return err
+		}
+		br.setInt(v, uint64(binary.BigEndian.Uint32(b)))
+	case reflect.Uint64, reflect.Uint, reflect.Int64, reflect.Int:
+		b, err := br.next(8)
+		if err != nil {
+			return err
+		}
+		br.setInt(v, binary.BigEndian.Uint64(b))
+	default:
+		return fmt.Errorf("unsupported field kind %s", v.Kind())
+	}
+	return nil
+}
+
+// minEncodedSize is the fewest bytes a value of type t takes on the wire,
+// at least one.
+func minEncodedSize(t reflect.Type) int {
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Uint32, reflect.Int32:
+		return 4
+	case reflect.Uint16, reflect.Int16:
+		return 2
+	case reflect.Uint64, reflect.Uint, reflect.Int64, reflect.Int:
+		return 8
+	case reflect.Struct:
+		size := 0
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				size += minEncodedSize(t.Field(i).Type)
+			}
+		}
+		return max(size, 1)
+	default:
+		return 1
+	}
+}
+
+func (br *binaryReader) setInt(v reflect.Value, u uint64) {
+	switch v.Kind() {
+	case reflect.Int8:
+		v.SetInt(int64(int8(u)))
+	case reflect.Int16:
+		v.SetInt(int64(int16(u)))
+	case reflect.Int32:
+		v.SetInt(int64(int32(u)))
+	case reflect.Int64, reflect.Int:
+		v.SetInt(int64(u))
+	default:
+		v.SetUint(u)
+	}
+}
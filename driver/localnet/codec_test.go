@@ -0,0 +1,82 @@
+package localnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// BenchmarkCodec measures the path every transmit takes on both ends:
+// encoding a command and decoding it, with each codec, below the
+// compression threshold as short APDUs are.
+func BenchmarkCodec(b *testing.B) {
+	packets := []struct {
+		name   string
+		packet IPacketCmd
+	}{
+		{"transmit", NewPacketChannelBody(CmdTransmit, 1, []byte{0x81, 0xE2, 0x91, 0x00, 0x06, 0xBF, 0x3E, 0x03, 0x5C, 0x01, 0x5A})},
+		{"response", NewPacketBody(CmdResponse, []byte{0xBF, 0x3E, 0x12, 0x5A, 0x10, 0x89, 0x04, 0x90, 0x32, 0x12, 0x34, 0x51, 0x23, 0x45, 0x12, 0x34, 0x56, 0x78, 0x90, 0x12, 0x35, 0x90, 0x00})},
+	}
+	codecs := []struct {
+		name  string
+		codec Codec
+	}{{"gob", GobCodec{}}, {"binary", BinaryCodec{}}}
+
+	for _, p := range packets {
+		for _, c := range codecs {
+			wire := Wire{Version: WireV1, Codec: c.codec, Compression: FormatGzip}
+			encoded, err := EncodeWire(p.packet, wire)
+			if err != nil {
+				b.Fatal(err)
+			}
+			decoded, err := Decode(encoded)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if again, _ := EncodeWire(decoded, wire); !bytes.Equal(again, encoded) {
+				b.Fatalf("%s with %s: round trip changed the packet", p.name, c.name)
+			}
+
+			b.Run(p.name+"/"+c.name+"/encode", func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := EncodeWire(p.packet, wire); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run(p.name+"/"+c.name+"/decode", func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := Decode(encoded); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestBinaryReaderRefusesHostileCount(t *testing.T) {
+	// a count of empty APDUs fitting the bytes left, but not the four bytes
+	// each of them takes
+	data := binary.BigEndian.AppendUint32(nil, 1<<20)
+	data = append(data, make([]byte, 1<<20)...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var apdus [][]byte
+	err := (&binaryReader{data}).read(reflect.ValueOf(&apdus).Elem())
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(len(data)) {
+		t.Fatalf("allocated %d bytes for a %d byte packet", allocated, len(data))
+	}
+}
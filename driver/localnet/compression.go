@@ -0,0 +1,187 @@
+package localnet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// CompressionNone stores packets uncompressed.
+	CompressionNone = -1
+
+	DefaultCompressionLevel     = 6
+	DefaultCompressionThreshold = 128
+
+	// DefaultMaxDecompressedSize bounds what a compressed payload may expand to,
+	// well above the largest packet the protocol sends.
+	DefaultMaxDecompressedSize = 4 << 20
+)
+
+var (
+	compressionMu        sync.RWMutex
+	compressionLevel     = DefaultCompressionLevel
+	compressionThreshold = DefaultCompressionThreshold
+	maxDecompressedSize  = DefaultMaxDecompressedSize
+)
+
+// ErrPayloadTooLarge is returned by Decode for a payload expanding past
+// the limit set with SetMaxDecompressedSize, so that a small packet cannot
+// make its receiver allocate gigabytes.
+var ErrPayloadTooLarge = errors.New("decompressed payload too large")
+
+// SetCompression sets the level (0-9) used by Encode with gzip or zstd, or
+// CompressionNone to send every packet uncompressed.
+func SetCompression(level int) error {
+	if level != CompressionNone && (level < gzip.NoCompression || level > gzip.BestCompression) {
+		return fmt.Errorf("invalid compression level: %d", level)
+	}
+
+	compressionMu.Lock()
+	defer compressionMu.Unlock()
+	compressionLevel = level
+	return nil
+}
+
+// SetCompressionThreshold sets the encoded size in bytes below which Encode
+// skips compression, since compressing a few dozen bytes only adds header overhead.
+func SetCompressionThreshold(size int) {
+	compressionMu.Lock()
+	defer compressionMu.Unlock()
+	compressionThreshold = max(size, 0)
+}
+
+// SetMaxDecompressedSize sets how many bytes a compressed payload may expand to
+// in Decode before it fails with ErrPayloadTooLarge; 0 or less restores
+// DefaultMaxDecompressedSize. It applies to clients and servers alike.
+func SetMaxDecompressedSize(size int) {
+	if size <= 0 {
+		size = DefaultMaxDecompressedSize
+	}
+	compressionMu.Lock()
+	defer compressionMu.Unlock()
+	maxDecompressedSize = size
+}
+
+// compressor is a compression algorithm of the WireV1 envelope, picked by
+// the low nibble of the format byte.
+type compressor interface {
+	compress(buf *bytes.Buffer, raw []byte, level int) error
+	// decompress writes the expansion of payload to buf, failing with
+	// ErrPayloadTooLarge past limit bytes.
+	decompress(buf *bytes.Buffer, payload []byte, limit int) error
+}
+
+// compressors maps the format of each algorithm to its implementation, and
+// rawFormats to the format of the packets a peer using it leaves
+// uncompressed, which keeps telling the receiver the algorithm to answer
+// with.
+var (
+	compressors = map[byte]compressor{
+		FormatGzip: gzipCompressor{},
+		FormatZstd: zstdCompressor{},
+	}
+	rawFormats = map[byte]byte{
+		FormatGzip: FormatRaw,
+		FormatZstd: FormatRawZstd,
+	}
+)
+
+// compress compresses raw into buf with algorithm, FormatGzip or
+// FormatZstd, when raw is at least the threshold long and compressing makes
+// it smaller; already dense data such as encrypted profile segments is sent
+// raw rather than paying for the header. The payload returned aliases raw
+// or buf.
+func compress(buf *bytes.Buffer, raw []byte, algorithm byte) (format byte, payload []byte, err error) {
+	compressionMu.RLock()
+	level, threshold := compressionLevel, compressionThreshold
+	compressionMu.RUnlock()
+
+	c, ok := compressors[algorithm]
+	if !ok {
+		return 0, nil, fmt.Errorf("encode, unsupported compression 0x%02X", algorithm)
+	}
+	if level == CompressionNone || len(raw) < threshold {
+		return rawFormats[algorithm], raw, nil
+	}
+
+	if err = c.compress(buf, raw, level); err != nil {
+		return 0, nil, err
+	}
+	if buf.Len() >= len(raw) {
+		return rawFormats[algorithm], raw, nil
+	}
+	return algorithm, buf.Bytes(), nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) compress(buf *bytes.Buffer, raw []byte, level int) error {
+	return gzipTo(buf, raw, level)
+}
+
+func (gzipCompressor) decompress(buf *bytes.Buffer, payload []byte, limit int) error {
+	gr, err := getGzipReader(bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("decode, reader error using gzip: %w", err)
+	}
+	defer putGzipReader(gr)
+
+	return readLimited(buf, gr, limit, "gzip")
+}
+
+func gzipTo(buf *bytes.Buffer, raw []byte, level int) error {
+	gw, err := getGzipWriter(buf, level)
+	if err != nil {
+		return fmt.Errorf("encode, writer error using gzip: %w", err)
+	}
+	defer putGzipWriter(gw, level)
+
+	if _, err = gw.Write(raw); err != nil {
+		return fmt.Errorf("encode, writer error using gzip: %w", err)
+	}
+
+	if err = gw.Close(); err != nil {
+		return fmt.Errorf("encode, error closing gzip writer: %w", err)
+	}
+	return nil
+}
+
+// decompress returns the codec bytes of payload: payload itself when it is
+// raw, or its expansion written to buf, which may not exceed
+// maxDecompressedSize.
+func decompress(buf *bytes.Buffer, format byte, payload []byte) ([]byte, error) {
+	if format == FormatRaw || format == FormatRawZstd {
+		return payload, nil
+	}
+	c, ok := compressors[format]
+	if !ok {
+		return nil, fmt.Errorf("decode, unsupported format 0x%02X", format)
+	}
+
+	compressionMu.RLock()
+	limit := maxDecompressedSize
+	compressionMu.RUnlock()
+
+	if err := c.decompress(buf, payload, limit); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readLimited reads r into buf, failing with ErrPayloadTooLarge past limit
+// bytes.
+func readLimited(buf *bytes.Buffer, r io.Reader, limit int, algorithm string) error {
+	// one byte past the limit tells a payload of exactly limit bytes from a
+	// larger one
+	if _, err := buf.ReadFrom(io.LimitReader(r, int64(limit)+1)); err != nil {
+		return fmt.Errorf("decode, reader error using %s: %w", algorithm, err)
+	}
+	if buf.Len() > limit {
+		return fmt.Errorf("decode, %w: more than %d bytes", ErrPayloadTooLarge, limit)
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package localnet
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestCompressPicksPath(t *testing.T) {
+	small := bytes.Repeat([]byte{0x5A}, DefaultCompressionThreshold-1)
+	large := bytes.Repeat([]byte{0x80, 0xE2, 0x11, 0x00}, 256)
+	noise := make([]byte, 1024)
+	for i := range noise {
+		noise[i] = byte(rng.Uint32())
+	}
+
+	tests := []struct {
+		name      string
+		raw       []byte
+		algorithm byte
+		want      byte
+	}{
+		{"small gzip", small, FormatGzip, FormatRaw},
+		{"small zstd", small, FormatZstd, FormatRawZstd},
+		{"large gzip", large, FormatGzip, FormatGzip},
+		{"large zstd", large, FormatZstd, FormatZstd},
+		// compressing would not make it smaller
+		{"incompressible gzip", noise, FormatGzip, FormatRaw},
+		{"incompressible zstd", noise, FormatZstd, FormatRawZstd},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		format, payload, err := compress(&buf, tt.raw, tt.algorithm)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if format != tt.want {
+			t.Errorf("%s: format 0x%X, want 0x%X", tt.name, format, tt.want)
+		}
+		if raw := format == FormatRaw || format == FormatRawZstd; raw && !bytes.Equal(payload, tt.raw) {
+			t.Errorf("%s: raw payload differs from the input", tt.name)
+		} else if !raw && len(payload) >= len(tt.raw) {
+			t.Errorf("%s: compressed %d bytes to %d", tt.name, len(tt.raw), len(payload))
+		}
+	}
+}
+
+func TestCompressDisabled(t *testing.T) {
+	if err := SetCompression(CompressionNone); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetCompression(DefaultCompressionLevel) })
+
+	large := bytes.Repeat([]byte{0x80, 0xE2, 0x11, 0x00}, 256)
+	if format, _, err := compress(&bytes.Buffer{}, large, FormatGzip); err != nil || format != FormatRaw {
+		t.Fatalf("got format 0x%X, %v, want raw", format, err)
+	}
+}
+
+func TestEncodeWireFormatByte(t *testing.T) {
+	wire := Wire{Version: WireV1, Codec: BinaryCodec{}, Compression: FormatGzip}
+	for _, tt := range []struct {
+		name   string
+		packet IPacketCmd
+		want   byte
+	}{
+		{"ping", NewPacketCmd(CmdPing), FormatRaw},
+		{"batch", NewPacketBatch(storeDataBlocks(bppSegments, structuredBlock)), FormatGzip},
+	} {
+		byteArray, err := EncodeWire(tt.packet, wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format := byteArray[0] & 0x0F; format != tt.want {
+			t.Errorf("%s: format 0x%X, want 0x%X", tt.name, format, tt.want)
+		}
+		if _, err := Decode(byteArray); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestDecodeRefusesCompressionBomb(t *testing.T) {
+	// a body of zeros compresses a thousandfold, so the packet on the wire
+	// stays small while its expansion passes the limit
+	bomb := NewPacketBody(CmdResponse, make([]byte, 2*DefaultMaxDecompressedSize))
+	wires := []struct {
+		name string
+		wire Wire
+	}{
+		{"gzip", Wire{Version: WireV1, Codec: BinaryCodec{}, Compression: FormatGzip}},
+		{"zstd", Wire{Version: WireV1, Codec: BinaryCodec{}, Compression: FormatZstd}},
+		{"legacy gzip", LegacyWire},
+	}
+	for _, tt := range wires {
+		t.Run(tt.name, func(t *testing.T) {
+			byteArray, err := EncodeWire(bomb, tt.wire)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(byteArray) > DefaultMaxDecompressedSize/100 {
+				t.Fatalf("bomb encoded to %d bytes, hardly a bomb", len(byteArray))
+			}
+			if _, err := Decode(byteArray); !errors.Is(err, ErrPayloadTooLarge) {
+				t.Fatalf("got %v, want ErrPayloadTooLarge", err)
+			}
+		})
+	}
+}
+
+func TestDecodeHonorsMaxDecompressedSize(t *testing.T) {
+	t.Cleanup(func() { SetMaxDecompressedSize(0) })
+
+	body := make([]byte, 64<<10)
+	for _, format := range []byte{FormatGzip, FormatZstd} {
+		byteArray, err := EncodeWire(NewPacketBody(CmdResponse, body), Wire{Version: WireV1, Codec: BinaryCodec{}, Compression: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		SetMaxDecompressedSize(len(body) / 2)
+		if _, err := Decode(byteArray); !errors.Is(err, ErrPayloadTooLarge) {
+			t.Fatalf("format 0x%X over a lowered limit: got %v, want ErrPayloadTooLarge", format, err)
+		}
+		SetMaxDecompressedSize(0)
+		if _, err := Decode(byteArray); err != nil {
+			t.Fatalf("format 0x%X within the default limit: %v", format, err)
+		}
+	}
+}
+
+// BenchmarkCompressionLevel compares sending packets raw, as
+// SetCompression(CompressionNone) does, with gzip at the default level, for a
+// short transmit and a profile-sized batch. The threshold is lifted, so the
+// gzip runs show what compressing a short packet costs for no gain.
+func BenchmarkCompressionLevel(b *testing.B) {
+	payloads := []struct {
+		name   string
+		packet IPacketCmd
+	}{
+		{"small", NewPacketChannelBody(CmdTransmit, 1, []byte{0x81, 0xE2, 0x91, 0x00, 0x06, 0xBF, 0x3E, 0x03, 0x5C, 0x01, 0x5A})},
+		{"large", NewPacketBatch(storeDataBlocks(bppSegments, structuredBlock))},
+	}
+	levels := []struct {
+		name  string
+		level int
+	}{{"raw", CompressionNone}, {"gzip", DefaultCompressionLevel}}
+
+	SetCompressionThreshold(0)
+	b.Cleanup(func() {
+		SetCompression(DefaultCompressionLevel)
+		SetCompressionThreshold(DefaultCompressionThreshold)
+	})
+	wire := Wire{Version: WireV1, Codec: BinaryCodec{}, Compression: FormatGzip}
+	for _, p := range payloads {
+		for _, l := range levels {
+			if err := SetCompression(l.level); err != nil {
+				b.Fatal(err)
+			}
+			encoded, err := EncodeWire(p.packet, wire)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.Run(p.name+"/"+l.name+"/encode", func(b *testing.B) {
+				for b.Loop() {
+					if _, err := EncodeWire(p.packet, wire); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(encoded)), "wire-bytes")
+			})
+			b.Run(p.name+"/"+l.name+"/decode", func(b *testing.B) {
+				for b.Loop() {
+					if _, err := Decode(encoded); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// bppSegments is the STORE DATA blocks in a batch; 60 blocks of 255 bytes
+// make a typical bound profile package.
+const bppSegments = 60
+
+// BenchmarkCompressionAlgorithm compares gzip and zstd on packets the size
+// of a profile download, reporting the encoded size as wire-bytes, so that
+// -compression and NetConf.Zstd can be chosen on numbers.
+func BenchmarkCompressionAlgorithm(b *testing.B) {
+	payloads := []struct {
+		name   string
+		packet IPacketCmd
+	}{
+		// profile elements travel encrypted, so a BPP is mostly noise
+		{"bpp-encrypted", NewPacketBatch(storeDataBlocks(bppSegments, encryptedBlock))},
+		// metadata, notifications and unprotected elements repeat structure
+		{"bpp-structured", NewPacketBatch(storeDataBlocks(bppSegments, structuredBlock))},
+		{"profiles-info", NewPacketBody(CmdResponse, profilesInfo(8))},
+	}
+	algorithms := []struct {
+		name   string
+		format byte
+	}{{"gzip", FormatGzip}, {"zstd", FormatZstd}}
+
+	for _, p := range payloads {
+		for _, algorithm := range algorithms {
+			wire := Wire{Version: CurrentWireVersion, Codec: BinaryCodec{}, Compression: algorithm.format}
+			plain, err := wire.Codec.Marshal(p.packet)
+			if err != nil {
+				b.Fatal(err)
+			}
+			encoded, err := EncodeWire(p.packet, wire)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.Run(p.name+"/"+algorithm.name+"/encode", func(b *testing.B) {
+				b.SetBytes(int64(len(plain)))
+				for b.Loop() {
+					if _, err := EncodeWire(p.packet, wire); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(encoded)), "wire-bytes")
+			})
+			b.Run(p.name+"/"+algorithm.name+"/decode", func(b *testing.B) {
+				b.SetBytes(int64(len(plain)))
+				for b.Loop() {
+					if _, err := Decode(encoded); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// storeDataBlocks returns n STORE DATA commands carrying blocks from block.
+func storeDataBlocks(n int, block func(i int) []byte) [][]byte {
+	apdus := make([][]byte, 0, n)
+	for i := range n {
+		data := block(i)
+		apdus = append(apdus, append([]byte{0x80, 0xE2, 0x11, byte(i), byte(len(data))}, data...))
+	}
+	apdus[n-1][2] = 0x91
+	return apdus
+}
+
+// rng is seeded so every run compresses the same bytes.
+var rng = rand.New(rand.NewPCG(1, 2))
+
+// encryptedBlock is a segment of a protected profile element: a short TLV
+// header and ciphertext.
+func encryptedBlock(int) []byte {
+	block := []byte{0x86, 0x81, 0xF8}
+	for range 248 {
+		block = append(block, byte(rng.Uint32()))
+	}
+	return block
+}
+
+// structuredBlock is a segment of plaintext ASN.1 with file system and
+// application elements, repetitive with a few varying fields.
+func structuredBlock(i int) []byte {
+	var block []byte
+	for len(block) < 240 {
+		block = append(block,
+			0xA0, 0x1A, 0x80, 0x02, 0x3F, 0x00, 0x81, 0x02, byte(i), byte(len(block)),
+			0x82, 0x04, 0x78, 0x21, 0x00, 0x00, 0x83, 0x02, 0x2F, 0xE2,
+			0x8A, 0x01, 0x05, 0xAB, 0x03, 0x80, 0x01, 0x00)
+	}
+	return block[:240]
+}
+
+// profilesInfo is a ProfileInfoListResponse for n profiles.
+func profilesInfo(n int) []byte {
+	var body []byte
+	for i := range n {
+		body = append(body, 0xE3, 0x5A,
+			0x5A, 0x0A, 0x98, 0x94, 0x00, 0x01, 0x23, 0x45, 0x67, 0x89, byte(i), 0xF0,
+			0x4F, 0x10, 0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x89, 0x00, 0x00, 0x10, byte(i),
+			0x9F, 0x70, 0x01, 0x00,
+			0x90, 0x08, 'C', 'a', 'r', 'r', 'i', 'e', 'r', byte('0'+i),
+			0x91, 0x08, 'P', 'r', 'o', 'v', 'i', 'd', 'e', 'r',
+			0x92, 0x0C, 'P', 'r', 'o', 'f', 'i', 'l', 'e', ' ', 'n', 'a', 'm', byte('0'+i),
+			0x95, 0x01, 0x02,
+			0xB6, 0x0A, 0x30, 0x08, 0x80, 0x03, 0x00, 0xF1, 0x10, 0x81, 0x01, 0x00)
+	}
+	return append([]byte{0xBF, 0x2D, 0x82, byte(len(body) >> 8), byte(len(body)), 0xA0, 0x82, byte(len(body) >> 8), byte(len(body))}, body...)
+}
@@ -0,0 +1,49 @@
+package localnet
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// MaxDSCP is the largest differentiated services code point.
+const MaxDSCP = 63
+
+// SetDSCP marks the datagrams conn sends with the differentiated services
+// code point dscp, the upper six bits of the IPv4 type of service and the
+// IPv6 traffic class. A socket bound to an IPv6 or unspecified address
+// gets the traffic class, and the type of service too where the system
+// lets it apply to IPv4 traffic on such a socket. Systems without these
+// options return an error.
+func SetDSCP(conn *net.UDPConn, dscp int) error {
+	if dscp < 0 || dscp > MaxDSCP {
+		return fmt.Errorf("dscp out of range: %d, expected 0 to %d", dscp, MaxDSCP)
+	}
+	tos := dscp << 2
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		if err := ipv4.NewConn(conn).SetTOS(tos); err != nil {
+			return fmt.Errorf("error setting dscp %w", err)
+		}
+		return nil
+	}
+	if err := ipv6.NewConn(conn).SetTrafficClass(tos); err != nil {
+		return fmt.Errorf("error setting dscp %w", err)
+	}
+	// dual-stack sockets send IPv4 with the type of service instead
+	ipv4.NewConn(conn).SetTOS(tos)
+	return nil
+}
+
+// markDSCP sets the DSCP NetConf asks for on conn. Failing to is only
+// logged: the traffic goes through all the same, just unprioritized.
+func markDSCP(conn *net.UDPConn, dscp int) {
+	if dscp == 0 {
+		return
+	}
+	if err := SetDSCP(conn, dscp); err != nil {
+		slog.Warn("cannot set dscp, sending unmarked", "dscp", dscp, "error", err)
+	}
+}
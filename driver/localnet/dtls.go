@@ -0,0 +1,144 @@
+package localnet
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pion/dtls/v3"
+)
+
+const DefaultHandshakeTimeout = 10 * time.Second
+
+type DTLSConf struct {
+	PSK                []byte
+	PSKIdentity        string
+	CertFile           string
+	KeyFile            string
+	CAFile             string
+	ServerName         string
+	InsecureSkipVerify bool
+	HandshakeTimeout   time.Duration
+}
+
+var pskCipherSuites = []dtls.CipherSuiteID{
+	dtls.TLS_PSK_WITH_AES_128_GCM_SHA256,
+	dtls.TLS_PSK_WITH_AES_128_CCM_8,
+}
+
+func (d *DTLSConf) clientConfig() (*dtls.Config, error) {
+	config := &dtls.Config{
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		ServerName:           d.ServerName,
+		InsecureSkipVerify:   d.InsecureSkipVerify,
+	}
+
+	if len(d.PSK) > 0 {
+		psk := d.PSK
+		config.PSK = func([]byte) ([]byte, error) { return psk, nil }
+		config.PSKIdentityHint = []byte(d.PSKIdentity)
+		config.CipherSuites = pskCipherSuites
+		return config, nil
+	}
+
+	if d.CertFile != "" || d.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(d.CertFile, d.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate %s %w", d.CertFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if d.CAFile != "" {
+		pool, err := loadCertPool(d.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if config.RootCAs == nil && !d.InsecureSkipVerify {
+		return nil, errors.New("dtls: a CA file or InsecureSkipVerify is required for certificate mode")
+	}
+	return config, nil
+}
+
+func dialDTLS(ctx context.Context, rAddr *net.UDPAddr, d *DTLSConf, readBuffer int, writeBuffer int, dscp int) (net.Conn, error) {
+	config, err := d.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// like dtls.Dial, but with the socket at hand to size its buffers and
+	// mark its datagrams
+	pConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing dtls %s %w", rAddr, err)
+	}
+	if err = setSocketBuffers(pConn, readBuffer, writeBuffer); err != nil {
+		pConn.Close()
+		return nil, err
+	}
+	markDSCP(pConn, dscp)
+	conn, err := dtls.Client(pConn, rAddr, config)
+	if err != nil {
+		pConn.Close()
+		return nil, fmt.Errorf("error dialing dtls %s %w", rAddr, err)
+	}
+
+	timeout := d.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err = conn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("dtls handshake with %s failed %w", rAddr, err)
+	}
+	return conn, nil
+}
+
+// NewDTLSServerConfig builds the listener configuration used by the server.
+// A PSK takes precedence over a certificate/key pair when both are given.
+func NewDTLSServerConfig(certFile string, keyFile string, psk []byte, pskHint string) (*dtls.Config, error) {
+	config := &dtls.Config{
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	}
+
+	if len(psk) > 0 {
+		config.PSK = func([]byte) ([]byte, error) { return psk, nil }
+		config.PSKIdentityHint = []byte(pskHint)
+		config.CipherSuites = pskCipherSuites
+		return config, nil
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("dtls: both certificate and key are required")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading server certificate %s %w", certFile, err)
+	}
+	config.Certificates = []tls.Certificate{cert}
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA file %s %w", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
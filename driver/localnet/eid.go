@@ -0,0 +1,29 @@
+package localnet
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// EIDLength is the size in bytes of an EID.
+const EIDLength = 16
+
+// GetEID reads the EID of the eUICC and returns it in upper case hex. The
+// server selects the ISD-R on a logical channel of its own and sends
+// GetEUICCData, so the caller needs no channel open.
+func (c *NetContext) GetEID() (string, error) {
+	return c.GetEIDContext(context.Background())
+}
+
+func (c *NetContext) GetEIDContext(ctx context.Context) (string, error) {
+	bb, er := remoteCall(ctx, c, NewPacketCmd(CmdGetEID))
+	if er != nil {
+		return "", er
+	}
+	if len(bb) != EIDLength {
+		return "", fmt.Errorf("geteid: expected %d bytes, got %d", EIDLength, len(bb))
+	}
+	return strings.ToUpper(hex.EncodeToString(bb)), nil
+}
@@ -0,0 +1,139 @@
+package localnet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCode classifies an error the server answered with, so clients can tell
+// a busy device from an expired session without matching the message,
+// which stays for people to read. errors.Is matches a server error against
+// its code. The values travel on the wire and never change meaning.
+type ErrCode uint16
+
+const (
+	ErrCodeNone ErrCode = 0
+	// ErrCodeInternal covers errors without a more specific code, driver
+	// and card failures included.
+	ErrCodeInternal ErrCode = 1
+	// ErrCodeInvalidRequest is a malformed packet or an argument out of
+	// range.
+	ErrCodeInvalidRequest ErrCode = 2
+	ErrCodeUnknownCommand ErrCode = 3
+	// ErrCodeUnauthorized is a missing or wrong auth or admin token.
+	ErrCodeUnauthorized ErrCode = 4
+	// ErrCodeForbidden is a device or client refused by the allow-lists.
+	ErrCodeForbidden ErrCode = 5
+	// ErrCodeUnsupportedProto is a protocol without a driver, or one the
+	// server does not allow.
+	ErrCodeUnsupportedProto ErrCode = 6
+	// ErrCodeBusy is a device held by another client.
+	ErrCodeBusy ErrCode = 7
+	// ErrCodeExpired is a session the server no longer has: unknown,
+	// expired, closed or past its maximum duration.
+	ErrCodeExpired     ErrCode = 8
+	ErrCodeRateLimited ErrCode = 9
+	// ErrCodeTimeout is a card operation that outlasted its timeout.
+	ErrCodeTimeout    ErrCode = 10
+	ErrCodeAborted    ErrCode = 11
+	ErrCodeDeviceGone ErrCode = 12
+	// ErrCodeUnavailable is a server shutting down or a feature it has
+	// disabled.
+	ErrCodeUnavailable ErrCode = 13
+	// ErrCodeNotFound is a session an admin command names that does not
+	// exist, or a CmdRepeatLast with no reply to repeat yet.
+	ErrCodeNotFound ErrCode = 14
+)
+
+var errCodeNames = map[ErrCode]string{
+	ErrCodeNone:             "none",
+	ErrCodeInternal:         "internal",
+	ErrCodeInvalidRequest:   "invalid request",
+	ErrCodeUnknownCommand:   "unknown command",
+	ErrCodeUnauthorized:     "unauthorized",
+	ErrCodeForbidden:        "forbidden",
+	ErrCodeUnsupportedProto: "unsupported protocol",
+	ErrCodeBusy:             "busy",
+	ErrCodeExpired:          "expired",
+	ErrCodeRateLimited:      "rate limited",
+	ErrCodeTimeout:          "timeout",
+	ErrCodeAborted:          "aborted",
+	ErrCodeDeviceGone:       "device gone",
+	ErrCodeUnavailable:      "unavailable",
+	ErrCodeNotFound:         "not found",
+}
+
+func (c ErrCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("code %d", uint16(c))
+}
+
+func (c ErrCode) Error() string {
+	return "server error: " + c.String()
+}
+
+// ErrorCode returns the code of the server error err wraps, or ErrCodeNone
+// when err did not come from the server.
+func ErrorCode(err error) ErrCode {
+	var se *serverError
+	if errors.As(err, &se) {
+		return se.code
+	}
+	var be *BatchError
+	if errors.As(err, &be) {
+		return be.Code
+	}
+	return ErrCodeNone
+}
+
+// messageCodes gives the code of the errors servers send without one:
+// servers predating ErrCode, and replies to clients the server cannot place
+// at ProtocolVersion4. A message matches an entry it starts with.
+var messageCodes = []struct {
+	prefix string
+	code   ErrCode
+}{
+	{"invalid session token", ErrCodeExpired},
+	{"no active session, connect first", ErrCodeExpired},
+	{"session expired", ErrCodeExpired},
+	{"session closed", ErrCodeExpired},
+	{"session exceeded maximum duration", ErrCodeExpired},
+	{"device busy", ErrCodeBusy},
+	{"invalid auth token", ErrCodeUnauthorized},
+	{"invalid admin token", ErrCodeUnauthorized},
+	{"protocol not allowed", ErrCodeUnsupportedProto},
+	{"unsupported protocol", ErrCodeUnsupportedProto},
+	{"device not allowed", ErrCodeForbidden},
+	{"rate limited", ErrCodeRateLimited},
+	{"command timed out", ErrCodeTimeout},
+	{"command aborted", ErrCodeAborted},
+	{"device gone", ErrCodeDeviceGone},
+	{"unknown command", ErrCodeUnknownCommand},
+	{"server shutting down", ErrCodeUnavailable},
+	{"events disabled on this server", ErrCodeUnavailable},
+	{"events not supported for protocol", ErrCodeUnsupportedProto},
+	{"slot listing not supported for protocol", ErrCodeUnsupportedProto},
+	{"client already has an active session", ErrCodeBusy},
+}
+
+// codeForMessage guesses the code of an error message sent without one.
+func codeForMessage(msg string) ErrCode {
+	for _, m := range messageCodes {
+		if strings.HasPrefix(msg, m.prefix) {
+			return m.code
+		}
+	}
+	return ErrCodeInternal
+}
+
+// newServerError turns the error pcRcv carries into a serverError, taking
+// its code from a PacketErr or, failing that, from the message.
+func newServerError(pcRcv IPacketCmd) *serverError {
+	if pe, ok := pcRcv.(IPacketErr); ok && pe.GetCode() != ErrCodeNone {
+		return &serverError{msg: pcRcv.GetErr(), code: pe.GetCode()}
+	}
+	return &serverError{msg: pcRcv.GetErr(), code: codeForMessage(pcRcv.GetErr())}
+}
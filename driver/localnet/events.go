@@ -0,0 +1,142 @@
+package localnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// EventSubscriptionTTL is how long the server keeps pushing events to a
+// subscriber that has not renewed its subscription.
+const EventSubscriptionTTL = 90 * time.Second
+
+const (
+	eventRenewInterval = EventSubscriptionTTL / 3
+	eventRetryDelay    = 5 * time.Second
+	eventBuffer        = 16
+)
+
+// Event reports a card inserted into or removed from a slot of the device.
+type Event struct {
+	Slot     uint16
+	Inserted bool
+	Time     time.Time
+}
+
+func (e Event) String() string {
+	if e.Inserted {
+		return fmt.Sprintf("Slot %d: card inserted at %s", e.Slot, e.Time.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("Slot %d: card removed at %s", e.Slot, e.Time.Format(time.RFC3339))
+}
+
+// Events subscribes to card insertion and removal on the context's device
+// and returns the channel they are delivered on. The subscription runs over
+// its own connection, needs no session and is renewed in the background
+// until Disconnect, which closes the channel. Events arriving while the
+// channel is full are dropped. The server must be started with
+// -eventInterval.
+func (c *NetContext) Events() <-chan Event {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+
+	if c.events == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.events = make(chan Event, eventBuffer)
+		c.eventsStop = cancel
+		c.eventsDone = make(chan struct{})
+		go c.watchEvents(ctx, c.events, c.eventsDone)
+	}
+	return c.events
+}
+
+// stopEvents ends the subscription, if any, and closes its channel.
+func (c *NetContext) stopEvents() {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+
+	if c.events == nil {
+		return
+	}
+	c.eventsStop()
+	<-c.eventsDone
+	c.events = nil
+	c.eventsStop = nil
+	c.eventsDone = nil
+}
+
+func (c *NetContext) watchEvents(ctx context.Context, events chan<- Event, done chan<- struct{}) {
+	defer close(done)
+	defer close(events)
+
+	// a context of its own, so that events never mix with the replies read
+	// on the main connection
+	sub := &NetContext{network: c.network, serverAddr: c.serverAddr, rAddr: c.rAddr, pipeCmd: c.pipeCmd, device: c.device, proto: c.proto, bufferSize: c.bufferSize, serverBufferSize: c.serverBufferSize, conf: c.conf}
+
+	for ctx.Err() == nil {
+		err := sub.subscribe(ctx, events)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("event subscription failed, retrying", "server", c.rAddr, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventRetryDelay):
+		}
+	}
+}
+
+// subscribe dials, subscribes and renews the subscription until the
+// connection fails or ctx is cancelled.
+func (c *NetContext) subscribe(ctx context.Context, events chan<- Event) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	defer func() {
+		conn.Close()
+		c.conn = nil
+	}()
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	for {
+		if err = writePacket(c, NewPacketSubscribe(c.device, c.proto, c.conf.AuthToken)); err != nil {
+			return err
+		}
+
+		renew := time.Now().Add(eventRenewInterval)
+		for time.Now().Before(renew) {
+			conn.SetReadDeadline(renew)
+			pcRcv, err := readPacket(ctx, c)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if pcRcv.GetErr() != "" {
+				return fmt.Errorf("error on server %s", pcRcv.GetErr())
+			}
+
+			event, ok := pcRcv.(IPacketEvent)
+			if !ok {
+				continue
+			}
+			select {
+			case events <- Event{Slot: event.GetSlot(), Inserted: event.GetInserted(), Time: time.UnixMilli(event.GetTimestamp())}:
+			default:
+				slog.Warn("event dropped, channel full", "slot", event.GetSlot())
+			}
+		}
+	}
+}
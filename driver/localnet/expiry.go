@@ -0,0 +1,82 @@
+package localnet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultSessionTimeout is how long a server keeps an idle session unless
+// started with another -timeout.
+const DefaultSessionTimeout = 60 * time.Second
+
+// ErrSessionExpired is returned when the server no longer knows the session,
+// usually because it was idle past the session timeout. Connect again to
+// get a new one.
+var ErrSessionExpired = errors.New("session expired, reconnect")
+
+// ErrDeviceGone is returned when the server closed the session because its
+// device went away, such as a modem unplugged mid-session. Retrying cannot
+// help; connect again once the device is back.
+var ErrDeviceGone = errors.New("device gone")
+
+// ErrMaxSessionDuration is matched, together with ErrSessionExpired, when the
+// server ended the session for lasting longer than its -maxSessionDuration,
+// however busy it was.
+var ErrMaxSessionDuration = errors.New("session exceeded maximum duration")
+
+// IsExpired reports whether the session is gone or has been idle long
+// enough for the server to have dropped it: longer than
+// NetConf.SessionTimeout, or DefaultSessionTimeout when that is not set.
+// It is also true before Connect and after Disconnect. With a keepalive
+// running the session never idles.
+func (c *NetContext) IsExpired() bool {
+	last := c.lastActivity.Load()
+	if last == 0 {
+		return true
+	}
+	return time.Since(time.Unix(0, last)) > c.sessionTimeout()
+}
+
+func (c *NetContext) sessionTimeout() time.Duration {
+	if c.conf.SessionTimeout > 0 {
+		return c.conf.SessionTimeout
+	}
+	return DefaultSessionTimeout
+}
+
+// usesSession reports whether cmd runs in the session, so that it keeps the
+// session alive and fails once the session is gone.
+func usesSession(cmd Cmd) bool {
+	switch cmd {
+	case CmdConnect, CmdConnectProof, CmdListSlots, CmdStatus, CmdSubscribe, CmdCapabilities, CmdAdminKick, CmdHealth, CmdListProtocols:
+		return false
+	}
+	return true
+}
+
+// checkExpired fails a session command before it is sent when the session
+// has idled past the timeout the client asked for. Without one the server's
+// timeout is not known for sure, so the server decides.
+func (c *NetContext) checkExpired(pcSnd IPacketCmd) error {
+	if c.conf.SessionTimeout > 0 && usesSession(pcSnd.GetCmd()) && pcSnd.GetCmd() != CmdDisconnect && c.IsExpired() {
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// recordActivity notes the outcome of a call for IsExpired, and turns the
+// server's errors for a missing session into ErrSessionExpired.
+func (c *NetContext) recordActivity(pcSnd IPacketCmd, err error) error {
+	cmd := pcSnd.GetCmd()
+	switch {
+	case err == nil && cmd == CmdDisconnect, errors.Is(err, ErrDeviceGone):
+		c.lastActivity.Store(0)
+	case err == nil && (cmd == CmdConnect || usesSession(cmd)):
+		c.lastActivity.Store(time.Now().UnixNano())
+	case usesSession(cmd) && errors.Is(err, ErrCodeExpired):
+		c.lastActivity.Store(0)
+		return fmt.Errorf("%w: %w", ErrSessionExpired, err)
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package localnet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	MaxFragments           = 1024
+	DefaultFragmentTimeout = 5 * time.Second
+
+	// room left in each datagram for the gob/gzip framing of a PacketFragment
+	fragmentOverhead = 256
+)
+
+var ErrIncompleteFragments = errors.New("incomplete fragmented packet")
+
+// EncodeFragments encodes p into one datagram, or into several
+// PacketFragment datagrams when the encoded packet does not fit in size bytes.
+func EncodeFragments(p IPacketCmd, size int, w Wire) ([][]byte, error) {
+	byteArray, err := EncodeWire(p, w)
+	if err != nil {
+		return nil, err
+	}
+	if len(byteArray) <= size {
+		return [][]byte{byteArray}, nil
+	}
+
+	chunkSize := size - fragmentOverhead
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("fragment, buffer size too small: %d", size)
+	}
+
+	total := (len(byteArray) + chunkSize - 1) / chunkSize
+	if total > MaxFragments {
+		return nil, fmt.Errorf("fragment, packet too large: %d bytes", len(byteArray))
+	}
+
+	datagrams := make([][]byte, 0, total)
+	for i := 0; i < total; i++ {
+		end := min((i+1)*chunkSize, len(byteArray))
+		frag, err := EncodeWire(NewPacketFragment(uint16(i), uint16(total), byteArray[i*chunkSize:end]), w)
+		if err != nil {
+			return nil, err
+		}
+		if len(frag) > size {
+			return nil, fmt.Errorf("fragment, encoded fragment exceeds buffer size: %d > %d", len(frag), size)
+		}
+		datagrams = append(datagrams, frag)
+	}
+	return datagrams, nil
+}
+
+// Reassembler collects the fragments of a single packet. A fragment
+// announcing a different total starts a new packet.
+type Reassembler struct {
+	chunks   [][]byte
+	received int
+	size     int
+}
+
+func (r *Reassembler) Add(f IPacketFragment) (byteArray []byte, complete bool, err error) {
+	total := int(f.GetTotal())
+	index := int(f.GetIndex())
+	if total == 0 || total > MaxFragments || index >= total {
+		return nil, false, fmt.Errorf("fragment, invalid index %d of %d", index, total)
+	}
+
+	if len(r.chunks) != total {
+		r.chunks = make([][]byte, total)
+		r.received = 0
+		r.size = 0
+	}
+
+	if r.chunks[index] == nil {
+		r.chunks[index] = f.GetChunk()
+		r.received++
+		r.size += len(f.GetChunk())
+	}
+
+	if r.received < total {
+		return nil, false, nil
+	}
+
+	for _, chunk := range r.chunks {
+		byteArray = append(byteArray, chunk...)
+	}
+	r.Reset()
+	return byteArray, true, nil
+}
+
+func (r *Reassembler) Pending() bool {
+	return r.received > 0
+}
+
+func (r *Reassembler) Progress() (received int, total int) {
+	return r.received, len(r.chunks)
+}
+
+// Size returns the bytes of the chunks buffered so far.
+func (r *Reassembler) Size() int {
+	return r.size
+}
+
+func (r *Reassembler) Reset() {
+	r.chunks = nil
+	r.received = 0
+	r.size = 0
+}
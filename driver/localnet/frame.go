@@ -0,0 +1,80 @@
+package localnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const MaxFrameSize = 1 << 20
+
+// ErrPartialFrame is a frame that failed after part of it was written or
+// read, a timeout or cancellation cutting into it among other causes. The
+// peer can no longer tell where the next frame starts, so the stream must be
+// closed rather than used further.
+var ErrPartialFrame = errors.New("stream out of step, partial frame")
+
+// WriteFrame writes byteArray prefixed with its 4-byte big-endian length.
+// A writer taking part of the frame is handed the rest until it has all of
+// it, so a short write never leaves the stream out of step.
+func WriteFrame(w io.Writer, byteArray []byte) error {
+	if len(byteArray) > MaxFrameSize {
+		return fmt.Errorf("frame, size %d exceeds maximum %d", len(byteArray), MaxFrameSize)
+	}
+
+	frame := make([]byte, 4+len(byteArray))
+	binary.BigEndian.PutUint32(frame, uint32(len(byteArray)))
+	copy(frame[4:], byteArray)
+
+	written, err := writeFull(w, frame)
+	if err != nil && written > 0 {
+		return fmt.Errorf("%w: wrote %d of %d bytes: %w", ErrPartialFrame, written, len(frame), err)
+	}
+	return err
+}
+
+// writeFull writes all of data to w and returns how much it wrote. A writer
+// reporting a short write without an error breaks the io.Writer contract,
+// so one that makes no progress fails with io.ErrShortWrite rather than
+// being retried forever.
+func writeFull(w io.Writer, data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		n, err := w.Write(data[written:])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// ReadFrame reads one length-prefixed frame written by WriteFrame, however
+// the stream splits it: header and body are each read in full. A failure
+// before the first byte is returned as it is, one inside the frame, or a
+// frame over MaxFrameSize, wraps ErrPartialFrame.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if n, err := io.ReadFull(r, header[:]); err != nil {
+		if n > 0 {
+			return nil, fmt.Errorf("%w: read %d of 4 header bytes: %w", ErrPartialFrame, n, err)
+		}
+		return nil, err
+	}
+
+	// the body of an oversized frame is left unread, so it fails the stream too
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxFrameSize {
+		return nil, fmt.Errorf("%w: size %d exceeds maximum %d", ErrPartialFrame, size, MaxFrameSize)
+	}
+
+	byteArray := make([]byte, size)
+	if n, err := io.ReadFull(r, byteArray); err != nil {
+		return nil, fmt.Errorf("%w: read %d of %d bytes: %w", ErrPartialFrame, n, size, err)
+	}
+	return byteArray, nil
+}
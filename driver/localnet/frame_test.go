@@ -0,0 +1,176 @@
+package localnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+var errConnBroken = errors.New("connection broken")
+
+// shortConn moves at most chunk bytes per Read or Write, as a congested
+// stream may, and with limit set fails writes once that many bytes went out.
+type shortConn struct {
+	net.Conn
+	chunk   int
+	limit   int
+	written int
+}
+
+func (c *shortConn) Write(p []byte) (int, error) {
+	n := min(len(p), c.chunk)
+	if c.limit > 0 {
+		if c.written >= c.limit {
+			return 0, errConnBroken
+		}
+		n = min(n, c.limit-c.written)
+	}
+	n, err := c.Conn.Write(p[:n])
+	c.written += n
+	return n, err
+}
+
+func (c *shortConn) Read(p []byte) (int, error) {
+	return c.Conn.Read(p[:min(len(p), c.chunk)])
+}
+
+// pipe returns both ends of an in-memory stream, closed when the test ends.
+func pipe(t *testing.T) (net.Conn, net.Conn) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestFrameShortWritesAndReads(t *testing.T) {
+	client, server := pipe(t)
+	payload := bytes.Repeat([]byte{0xA5, 0x5A, 0x00}, 1000)
+
+	errs := make(chan error, 1)
+	go func() {
+		w := &shortConn{Conn: client, chunk: 3}
+		if err := WriteFrame(w, payload); err != nil {
+			errs <- err
+			return
+		}
+		errs <- WriteFrame(w, nil)
+	}()
+
+	r := &shortConn{Conn: server, chunk: 2}
+	got, err := ReadFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("read %d bytes, want the %d written", len(got), len(payload))
+	}
+	// the next frame starts where the first ended
+	if got, err = ReadFrame(r); err != nil || len(got) != 0 {
+		t.Fatalf("empty frame read as %X, %v", got, err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteFrameBrokenMidFrame(t *testing.T) {
+	client, server := pipe(t)
+	go io.Copy(io.Discard, server)
+
+	err := WriteFrame(&shortConn{Conn: client, chunk: 4, limit: 6}, []byte("hello"))
+	if !errors.Is(err, ErrPartialFrame) || !errors.Is(err, errConnBroken) {
+		t.Fatalf("got %v, want ErrPartialFrame wrapping the write error", err)
+	}
+}
+
+func TestWriteFrameBrokenBeforeFrame(t *testing.T) {
+	client, _ := pipe(t)
+	client.Close()
+
+	// nothing went out, so the stream is still in step
+	err := WriteFrame(&shortConn{Conn: client, chunk: 4}, []byte("hello"))
+	if err == nil || errors.Is(err, ErrPartialFrame) {
+		t.Fatalf("got %v, want the write error alone", err)
+	}
+}
+
+func TestWriteFrameTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, make([]byte, MaxFrameSize+1)); err == nil {
+		t.Fatal("oversized frame written")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("oversized frame left %d bytes on the stream", buf.Len())
+	}
+}
+
+// stalledWriter takes nothing and reports no error.
+type stalledWriter struct{}
+
+func (stalledWriter) Write([]byte) (int, error) { return 0, nil }
+
+func TestWriteFullNoProgress(t *testing.T) {
+	if n, err := writeFull(stalledWriter{}, []byte{1, 2, 3}); n != 0 || err != io.ErrShortWrite {
+		t.Fatalf("got %d, %v, want 0, io.ErrShortWrite", n, err)
+	}
+}
+
+func TestWriteFullShortWrites(t *testing.T) {
+	client, server := pipe(t)
+	data := []byte("a short write is retried")
+
+	done := make(chan []byte)
+	go func() {
+		got, _ := io.ReadAll(io.LimitReader(server, int64(len(data))))
+		done <- got
+	}()
+
+	n, err := writeFull(&shortConn{Conn: client, chunk: 5}, data)
+	if err != nil || n != len(data) {
+		t.Fatalf("got %d, %v, want %d, nil", n, err, len(data))
+	}
+	if got := <-done; !bytes.Equal(got, data) {
+		t.Fatalf("peer read %q, want %q", got, data)
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	header := func(size uint32) []byte {
+		return binary.BigEndian.AppendUint32(nil, size)
+	}
+	tests := []struct {
+		name    string
+		stream  []byte
+		partial bool
+	}{
+		{"empty stream", nil, false},
+		{"partial header", []byte{0, 0}, true},
+		{"partial body", append(header(5), "hel"...), true},
+		{"oversized", header(MaxFrameSize + 1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := pipe(t)
+			go func() {
+				client.Write(tt.stream)
+				client.Close()
+			}()
+
+			_, err := ReadFrame(&shortConn{Conn: server, chunk: 1})
+			if err == nil {
+				t.Fatal("truncated frame read")
+			}
+			if errors.Is(err, ErrPartialFrame) != tt.partial {
+				t.Fatalf("got %v, partial frame %v", err, tt.partial)
+			}
+			if !tt.partial && err != io.EOF {
+				t.Fatalf("got %v, want io.EOF", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package localnet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// HealthOK is the Status of a server able to take commands.
+const HealthOK = "ok"
+
+// Health is the CmdHealth response, carried as JSON in a PacketBody.
+// DeviceAvailable is set when the probe named a device: whether a connect
+// to it would find it free.
+type Health struct {
+	Status          string `json:"status"`
+	Device          string `json:"device,omitempty"`
+	DeviceAvailable *bool  `json:"deviceAvailable,omitempty"`
+}
+
+// Health probes whether the server is up, for load balancers and
+// monitoring. It needs no session or token and the server answers without
+// touching a card; when the context was created for a device, the answer
+// also says whether that device is free. A server shutting down fails it
+// with ErrCodeUnavailable, and servers predating CmdHealth with
+// ErrNotSupported.
+func (c *NetContext) Health() (*Health, error) {
+	return c.HealthContext(context.Background())
+}
+
+func (c *NetContext) HealthContext(ctx context.Context) (*Health, error) {
+	release, err := c.borrowConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	bb, err := remoteCall(ctx, c, NewPacketHealth(c.device, c.proto))
+	if errors.Is(err, ErrCodeUnknownCommand) {
+		return nil, fmt.Errorf("health: %w", ErrNotSupported)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	health := new(Health)
+	if err = json.Unmarshal(bb, health); err != nil {
+		return nil, fmt.Errorf("health: error decoding response %w", err)
+	}
+	return health, nil
+}
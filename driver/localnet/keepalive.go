@@ -0,0 +1,105 @@
+package localnet
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Ping refreshes the session on the server without touching the card.
+func (c *NetContext) Ping() error {
+	return c.PingContext(context.Background())
+}
+
+func (c *NetContext) PingContext(ctx context.Context) error {
+	pcRcv, err := remoteCallPacket(ctx, c, NewPacketCmd(CmdPing))
+	if err != nil {
+		return err
+	}
+	if pcRcv.GetCmd() != CmdPong {
+		return fmt.Errorf("ping: unexpected response %s", pcRcv)
+	}
+	return nil
+}
+
+// KeepChannelAlive sends STATUS to the card on the logical channel opened
+// last through this context, or on the basic channel when none is open, and
+// returns the status word the card answered with. Unlike Ping it reaches
+// the card, for cards that deactivate when left idle; any status word shows
+// the card is awake. A server without FeatureKeepChannelAlive fails it with
+// ErrNotSupported.
+func (c *NetContext) KeepChannelAlive() (uint16, error) {
+	return c.KeepChannelAliveContext(context.Background())
+}
+
+func (c *NetContext) KeepChannelAliveContext(ctx context.Context) (uint16, error) {
+	var channel byte
+	if channels := c.LogicalChannels(); len(channels) > 0 {
+		channel = channels[len(channels)-1].Channel
+	}
+	return c.KeepChannelAliveOnContext(ctx, channel)
+}
+
+// KeepChannelAliveOn is KeepChannelAlive on the given channel, which the
+// session must have open unless it is the basic channel 0.
+func (c *NetContext) KeepChannelAliveOn(channel byte) (uint16, error) {
+	return c.KeepChannelAliveOnContext(context.Background(), channel)
+}
+
+func (c *NetContext) KeepChannelAliveOnContext(ctx context.Context, channel byte) (uint16, error) {
+	if channel != 0 {
+		if err := CheckChannel(channel); err != nil {
+			return 0, err
+		}
+	}
+	if err := c.requireFeature(ctx, FeatureKeepChannelAlive); err != nil {
+		return 0, err
+	}
+	bb, err := remoteCall(ctx, c, NewPacketChannelBody(CmdKeepChannelAlive, channel, nil))
+	if err != nil {
+		return 0, err
+	}
+	if len(bb) != 2 {
+		return 0, fmt.Errorf("keep channel alive: expected a status word, received %X", bb)
+	}
+	return uint16(bb[0])<<8 | uint16(bb[1]), nil
+}
+
+func (c *NetContext) startKeepAlive(interval time.Duration) {
+	c.keepAliveStop = make(chan struct{})
+	c.keepAliveDone = make(chan struct{})
+	go c.keepAlive(interval, c.keepAliveStop, c.keepAliveDone)
+}
+
+// stopKeepAlive returns once the keepalive goroutine, if any, has exited.
+func (c *NetContext) stopKeepAlive() {
+	if c.keepAliveStop == nil {
+		return
+	}
+	close(c.keepAliveStop)
+	<-c.keepAliveDone
+	c.keepAliveStop = nil
+	c.keepAliveDone = nil
+}
+
+func (c *NetContext) keepAlive(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// a lost datagram must not wedge the connection until the next tick
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := c.PingContext(ctx); err != nil {
+				slog.Warn("keepalive ping failed", "server", c.rAddr, "error", err)
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package localnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// AdminKick has the server end the session holding device, as an operator
+// freeing a modem from a stuck client, and returns that client's address,
+// or the addresses separated by commas when sessions shared the device.
+// An empty device kicks the only session and fails if there are several.
+// It needs no session of its own and presents NetConf.AdminToken; servers
+// started without -adminToken refuse it with ErrCodeUnauthorized. Servers
+// predating CmdAdminKick fail it with ErrNotSupported.
+func (c *NetContext) AdminKick(device string, proto string) (string, error) {
+	return c.AdminKickContext(context.Background(), device, proto)
+}
+
+func (c *NetContext) AdminKickContext(ctx context.Context, device string, proto string) (string, error) {
+	release, err := c.borrowConn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	bb, err := remoteCall(ctx, c, NewPacketAdminKick(device, proto, c.conf.AdminToken))
+	if errors.Is(err, ErrCodeUnknownCommand) {
+		return "", fmt.Errorf("kick: %w", ErrNotSupported)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(bb), nil
+}
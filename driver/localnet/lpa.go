@@ -0,0 +1,35 @@
+package localnet
+
+import (
+	"github.com/damonto/euicc-go/lpa"
+)
+
+// NewRemoteLPA returns an LPA client for the eUICC of a modem behind the
+// server at serverAddr, reached over UDP. The client connects and opens the
+// ISD-R at once; Close on the client closes the channel and the session.
+func NewRemoteLPA(serverAddr string, device string, proto string, slot uint16) (*lpa.Client, error) {
+	return NewRemoteLPAConf(serverAddr, device, proto, slot, NetConf{}, nil)
+}
+
+// NewRemoteLPAConf is NewRemoteLPA with conf for the connection and opts for
+// the LPA client, nil meaning the lpa defaults. opts.Channel is ignored.
+func NewRemoteLPAConf(serverAddr string, device string, proto string, slot uint16, conf NetConf, opts *lpa.Options) (*lpa.Client, error) {
+	ch, err := NewUDPConf(serverAddr, device, proto, slot, 0, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var options lpa.Options
+	if opts != nil {
+		options = *opts
+	}
+	options.Channel = ch
+
+	client, err := lpa.New(&options)
+	if err != nil {
+		// lpa.New keeps the session when the ISD-R cannot be opened
+		ch.Disconnect()
+		return nil, err
+	}
+	return client, nil
+}
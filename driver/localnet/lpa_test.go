@@ -0,0 +1,116 @@
+package localnet
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// mockCard answers the EID and profile list requests of an LPA client on
+// logical channel 1, where the ISD-R opens first.
+const mockCard = `81E2910006BF3E035C015A 6116
+81C0000016 BF3E125A10890490321234512345123456789012359000
+81E2910010BF2D* BF2D20A01EE31C5A0A9844070000000000001091044D6F636B9204546573749F7001019000
+* 6A82
+`
+
+// startMockServer builds the server and runs it on a free loopback port,
+// returning its address; it is stopped when the test ends.
+func startMockServer(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds and runs the server")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	dir := t.TempDir()
+	server := filepath.Join(dir, "server")
+	if out, err := exec.Command(goTool, "build", "-o", server, "../../server").CombinedOutput(); err != nil {
+		t.Fatalf("building server: %v\n%s", err, out)
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	cmd := exec.Command(server, "-bindAddr", "127.0.0.1", "-bindPort", strconv.Itoa(port), "-allowProtos", "mock", "-logLevel", "warn")
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Signal(os.Interrupt)
+		cmd.Wait()
+	})
+
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		ch, _ := NewUDPConf(addr, "", "", 0, 0, NetConf{Timeout: 200 * time.Millisecond})
+		if _, err := ch.(*NetContext).Health(); err == nil {
+			return addr
+		} else if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+	}
+}
+
+func TestNewRemoteLPA(t *testing.T) {
+	addr := startMockServer(t)
+	script := filepath.Join(t.TempDir(), "card.txt")
+	if err := os.WriteFile(script, []byte(mockCard), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewRemoteLPA(addr, script, "mock", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eid, err := client.EID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x89, 0x04, 0x90, 0x32, 0x12, 0x34, 0x51, 0x23, 0x45, 0x12, 0x34, 0x56, 0x78, 0x90, 0x12, 0x35}; !bytes.Equal(eid, want) {
+		t.Fatalf("EID %X, want %X", eid, want)
+	}
+
+	profiles, err := client.ListProfile(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 || profiles[0].ProfileName != "Test" {
+		t.Fatalf("profiles %+v, want one named Test", profiles)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Close ends the session, so the card is free for the next client
+	ch, _ := NewUDP(addr, script, "mock", 0, 0)
+	health, err := ch.(*NetContext).Health()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.DeviceAvailable == nil || !*health.DeviceAvailable {
+		t.Fatalf("device still busy after Close: %+v", health)
+	}
+}
+
+func TestNewRemoteLPAConnectFails(t *testing.T) {
+	addr := startMockServer(t)
+	// the mock driver cannot load a missing script
+	if _, err := NewRemoteLPA(addr, filepath.Join(t.TempDir(), "missing.txt"), "mock", 0); err == nil {
+		t.Fatal("NewRemoteLPA succeeded without a card")
+	}
+}
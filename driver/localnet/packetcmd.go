@@ -2,25 +2,57 @@ package localnet
 
 import (
 	"bytes"
-	"compress/gzip"
-	"encoding/gob"
 	"fmt"
+	"math"
+	"time"
 )
 
 type Cmd string
 
 const (
-	CmdConnect      Cmd = "conn"
-	CmdDisconnect   Cmd = "disc"
-	CmdOpenLogical  Cmd = "opch"
-	CmdCloseLogical Cmd = "clch"
-	CmdTransmit     Cmd = "tran"
-	CmdResponse     Cmd = "resp"
+	CmdConnect          Cmd = "conn"
+	CmdDisconnect       Cmd = "disc"
+	CmdOpenLogical      Cmd = "opch"
+	CmdCloseLogical     Cmd = "clch"
+	CmdTransmit         Cmd = "tran"
+	CmdResponse         Cmd = "resp"
+	CmdFragment         Cmd = "frag"
+	CmdListSlots        Cmd = "slot"
+	CmdReset            Cmd = "rset"
+	CmdPing             Cmd = "ping"
+	CmdPong             Cmd = "pong"
+	CmdTransmitBatch    Cmd = "tbat"
+	CmdStatus           Cmd = "stat"
+	CmdSubscribe        Cmd = "subs"
+	CmdEvent            Cmd = "evnt"
+	CmdGetEID           Cmd = "geid"
+	CmdTransmitOn       Cmd = "trch"
+	CmdCapabilities     Cmd = "caps"
+	CmdAbort            Cmd = "abrt"
+	CmdSelect           Cmd = "slct"
+	CmdAdminKick        Cmd = "kick"
+	CmdStoreData        Cmd = "stdt"
+	CmdHealth           Cmd = "hlth"
+	CmdGetProfilesInfo  Cmd = "prof"
+	CmdSwitchSlot       Cmd = "swsl"
+	CmdKeepChannelAlive Cmd = "kpal"
+	CmdRepeatLast       Cmd = "rept"
+	CmdListProtocols    Cmd = "lspr"
+	CmdEnableProfile    Cmd = "enpr"
+	CmdDisableProfile   Cmd = "dspr"
+	CmdSelfTest         Cmd = "slft"
+	CmdConnectProof     Cmd = "cprf"
 )
 
 type IPacketCmd interface {
 	GetCmd() Cmd
 	GetErr() string
+	GetSessionToken() string
+	SetSessionToken(token string)
+	GetRequestID() uint64
+	SetRequestID(id uint64)
+	GetTimeout() time.Duration
+	SetTimeout(timeout time.Duration)
 }
 
 type IPacketBody interface {
@@ -28,16 +60,96 @@ type IPacketBody interface {
 	GetBody() []byte
 }
 
+type IPacketChannelBody interface {
+	IPacketBody
+	GetChannel() byte
+}
+
 type IPacketConnect interface {
 	IPacketCmd
 	GetDevice() string
 	GetProto() string
-	GetSlot() uint8
+	GetSlot() uint16
+	GetProtocolVersion() uint16
+	GetAuthToken() string
+	GetRequestedTimeout() time.Duration
+}
+
+type IPacketConnectAID interface {
+	IPacketConnect
+	GetAID() []byte
+}
+
+type IPacketConnectSlot interface {
+	IPacketConnectAID
+	GetWideSlot() uint16
+}
+
+type IPacketConnectResp interface {
+	IPacketCmd
+	GetProtocolVersion() uint16
+	GetResumed() bool
+}
+
+type IPacketConnectInfo interface {
+	IPacketConnectResp
+	GetBufferSize() uint16
+}
+
+type IPacketConnectChannel interface {
+	IPacketConnectInfo
+	GetChannel() byte
+}
+
+type IPacketChallenge interface {
+	IPacketCmd
+	GetNonce() []byte
+}
+
+type IPacketConnectProof interface {
+	IPacketChallenge
+	GetProof() []byte
+}
+
+type IPacketFragment interface {
+	IPacketCmd
+	GetIndex() uint16
+	GetTotal() uint16
+	GetChunk() []byte
+}
+
+type IPacketBatch interface {
+	IPacketCmd
+	GetAPDUs() [][]byte
+}
+
+type IPacketBatchResp interface {
+	IPacketCmd
+	GetResponses() [][]byte
+	GetFailedIndex() int32
+	GetFailedErr() string
 }
 
+type IPacketErr interface {
+	IPacketCmd
+	GetCode() ErrCode
+}
+
+type IPacketEvent interface {
+	IPacketCmd
+	GetSlot() uint16
+	GetInserted() bool
+	GetTimestamp() int64
+}
+
+// PacketCmd starts every packet. Timeout is how many milliseconds the client
+// waits for the reply, 0 when it waits indefinitely.
 type PacketCmd struct {
-	Cmd Cmd
-	Err string
+	Cmd          Cmd
+	Err          string
+	SessionToken string
+	RequestID    uint64
+	Timeout      uint32
 }
 
 type PacketBody struct {
@@ -45,46 +157,193 @@ type PacketBody struct {
 	Body []byte
 }
 
+// PacketChannelBody is a PacketBody meant for logical channel Channel, which
+// the server checks against the body's class byte.
+type PacketChannelBody struct {
+	PacketBody
+	Channel uint8
+}
+
+// PacketConnect carries slots up to 255, the ones of physical slots; wider
+// slots travel in a PacketConnectSlot.
 type PacketConnect struct {
 	PacketCmd
-	Device string
-	Proto  string
-	Slot   uint8
+	Device          string
+	Proto           string
+	Slot            uint8
+	ProtocolVersion uint16
+	AuthToken       string
+	// RequestedTimeout is the session timeout in milliseconds the client
+	// asks for, 0 for the server default.
+	RequestedTimeout uint32
+}
+
+// PacketConnectAID is a connect that also opens a logical channel on AID,
+// for servers offering FeatureConnectAID.
+type PacketConnectAID struct {
+	PacketConnect
+	AID []byte
+}
+
+// PacketConnectSlot is a PacketConnectAID for a slot above 255, which the
+// Slot byte cannot hold and leaves 0; servers offering FeatureWideSlots take
+// WideSlot instead. AID may be empty, the connect then opens no channel.
+type PacketConnectSlot struct {
+	PacketConnectAID
+	WideSlot uint16
+}
+
+type PacketConnectResp struct {
+	PacketCmd
+	ProtocolVersion uint16
+	Resumed         bool
+}
+
+// PacketConnectInfo is the connect response to clients speaking
+// ProtocolVersion5 or later. BufferSize is the server's -bufferSize, the
+// largest datagram it reads and sends.
+type PacketConnectInfo struct {
+	PacketConnectResp
+	BufferSize uint16
+}
+
+// PacketConnectChannel answers a PacketConnectAID with the logical channel
+// opened on its AID.
+type PacketConnectChannel struct {
+	PacketConnectInfo
+	Channel uint8
+}
+
+// PacketChallenge answers a connect over UDP when the server runs with
+// -connectChallenge. The session is only started once the client proves it
+// received Nonce at its address.
+type PacketChallenge struct {
+	PacketCmd
+	Nonce []byte
+}
+
+// PacketConnectProof answers a PacketChallenge; Proof is ChallengeProof of
+// Nonce with the connect's auth token.
+type PacketConnectProof struct {
+	PacketChallenge
+	Proof []byte
+}
+
+type PacketFragment struct {
+	PacketCmd
+	Index uint16
+	Total uint16
+	Chunk []byte
+}
+
+type PacketBatch struct {
+	PacketCmd
+	APDUs [][]byte
+}
+
+// PacketBatchResp holds the responses of the APDUs that ran. FailedIndex is
+// the position of the APDU that failed, or -1 when all of them succeeded.
+type PacketBatchResp struct {
+	PacketCmd
+	Responses   [][]byte
+	FailedIndex int32
+	FailedErr   string
+}
+
+// PacketErr is an error response whose Code classifies Err. Only clients
+// speaking ProtocolVersion4 or later get it; older ones get the PacketCmd
+// alone.
+type PacketErr struct {
+	PacketCmd
+	Code ErrCode
+}
+
+// PacketEvent reports a card inserted into or removed from Slot. Timestamp
+// is when the server noticed, in Unix milliseconds.
+type PacketEvent struct {
+	PacketCmd
+	Slot      uint8
+	Inserted  bool
+	Timestamp int64
 }
 
 func init() {
-	gob.Register(&PacketCmd{})
-	gob.Register(&PacketBody{})
-	gob.Register(&PacketConnect{})
+	registerPacket(0x01, &PacketCmd{})
+	registerPacket(0x02, &PacketBody{})
+	registerPacket(0x03, &PacketConnect{})
+	registerPacket(0x04, &PacketFragment{})
+	registerPacket(0x05, &PacketConnectResp{})
+	registerPacket(0x06, &PacketBatch{})
+	registerPacket(0x07, &PacketBatchResp{})
+	registerPacket(0x08, &PacketEvent{})
+	registerPacket(0x09, &PacketChannelBody{})
+	registerPacket(0x0A, &PacketErr{})
+	registerPacket(0x0B, &PacketConnectInfo{})
+	registerPacket(0x0C, &PacketConnectAID{})
+	registerPacket(0x0D, &PacketConnectChannel{})
+	registerPacket(0x0E, &PacketConnectSlot{})
+	registerPacket(0x0F, &PacketChallenge{})
+	registerPacket(0x10, &PacketConnectProof{})
 }
 
 func Decode(byteArray []byte) (p IPacketCmd, e error) {
-	gr, err := gzip.NewReader(bytes.NewReader(byteArray))
+	p, _, e = DecodeWire(byteArray)
+	return p, e
+}
+
+// DecodeWire also reports the wire the packet was encoded with, so a reply
+// can be sent back in a form the peer understands.
+func DecodeWire(byteArray []byte) (p IPacketCmd, w Wire, e error) {
+	payload, compression, w, e := unwrap(byteArray)
+	if e != nil {
+		return nil, w, e
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	raw, err := decompress(buf, compression, payload)
 	if err != nil {
-		return nil, fmt.Errorf("decode, reader error using gzip: %w", err)
+		return nil, w, err
 	}
-	defer gr.Close()
 
-	dec := gob.NewDecoder(gr)
-	e = dec.Decode(&p)
-	return p, e
+	p, e = w.Codec.Unmarshal(bytes.NewReader(raw))
+	return p, w, e
 }
 
 func Encode(p IPacketCmd) (byteArray []byte, err error) {
-	var buf bytes.Buffer
-
-	gw := gzip.NewWriter(&buf)
+	return EncodeWire(p, DefaultWire())
+}
 
-	enc := gob.NewEncoder(gw)
-	if err = enc.Encode(&p); err != nil {
-		return nil, fmt.Errorf("encode, writer error using gzip: %w", err)
+func EncodeWire(p IPacketCmd, w Wire) (byteArray []byte, err error) {
+	raw := getBuffer()
+	defer putBuffer(raw)
+	if err = marshalTo(raw, w.Codec, p); err != nil {
+		return nil, err
 	}
 
-	if err = gw.Close(); err != nil {
-		return nil, fmt.Errorf("encode, error closing gzip writer: %w", err)
-	}
+	zipped := getBuffer()
+	defer putBuffer(zipped)
 
-	return buf.Bytes(), nil
+	switch w.Version {
+	case WireLegacy:
+		if err = gzipTo(zipped, raw.Bytes(), DefaultCompressionLevel); err != nil {
+			return nil, err
+		}
+		return bytes.Clone(zipped.Bytes()), nil
+	case WireV1:
+		algorithm := w.Compression
+		if algorithm == 0 {
+			algorithm = FormatGzip
+		}
+		compression, payload, err := compress(zipped, raw.Bytes(), algorithm)
+		if err != nil {
+			return nil, err
+		}
+		return seal(w.Codec.ID()|compression, payload), nil
+	default:
+		return nil, fmt.Errorf("unsupported wire version 0x%02X", byte(w.Version))
+	}
 }
 
 func (p PacketCmd) GetCmd() Cmd {
@@ -95,10 +354,38 @@ func (p PacketCmd) GetErr() string {
 	return p.Err
 }
 
+func (p PacketCmd) GetSessionToken() string {
+	return p.SessionToken
+}
+
+func (p *PacketCmd) SetSessionToken(token string) {
+	p.SessionToken = token
+}
+
+func (p PacketCmd) GetRequestID() uint64 {
+	return p.RequestID
+}
+
+func (p *PacketCmd) SetRequestID(id uint64) {
+	p.RequestID = id
+}
+
+func (p PacketCmd) GetTimeout() time.Duration {
+	return time.Duration(p.Timeout) * time.Millisecond
+}
+
+func (p *PacketCmd) SetTimeout(timeout time.Duration) {
+	p.Timeout = uint32(min(max(timeout.Milliseconds(), 0), math.MaxUint32))
+}
+
 func (p PacketBody) GetBody() []byte {
 	return p.Body
 }
 
+func (p PacketChannelBody) GetChannel() byte {
+	return p.Channel
+}
+
 func (p PacketConnect) GetDevice() string {
 	return p.Device
 }
@@ -107,8 +394,110 @@ func (p PacketConnect) GetProto() string {
 	return p.Proto
 }
 
-func (p PacketConnect) GetSlot() uint8 {
-	return p.Slot
+func (p PacketConnect) GetSlot() uint16 {
+	return uint16(p.Slot)
+}
+
+func (p PacketConnect) GetProtocolVersion() uint16 {
+	return p.ProtocolVersion
+}
+
+func (p PacketConnect) GetAuthToken() string {
+	return p.AuthToken
+}
+
+func (p PacketConnect) GetRequestedTimeout() time.Duration {
+	return time.Duration(p.RequestedTimeout) * time.Millisecond
+}
+
+// requestedTimeoutSetter is implemented by the connect packets, all of which
+// embed PacketConnect.
+type requestedTimeoutSetter interface {
+	SetRequestedTimeout(timeout time.Duration)
+}
+
+func (p *PacketConnect) SetRequestedTimeout(timeout time.Duration) {
+	p.RequestedTimeout = uint32(min(max(timeout.Milliseconds(), 0), math.MaxUint32))
+}
+
+func (p PacketConnectAID) GetAID() []byte {
+	return p.AID
+}
+
+func (p PacketConnectSlot) GetSlot() uint16 {
+	return p.WideSlot
+}
+
+func (p PacketConnectSlot) GetWideSlot() uint16 {
+	return p.WideSlot
+}
+
+func (p PacketConnectResp) GetProtocolVersion() uint16 {
+	return p.ProtocolVersion
+}
+
+func (p PacketConnectResp) GetResumed() bool {
+	return p.Resumed
+}
+
+func (p PacketConnectInfo) GetBufferSize() uint16 {
+	return p.BufferSize
+}
+
+func (p PacketConnectChannel) GetChannel() byte {
+	return p.Channel
+}
+
+func (p PacketChallenge) GetNonce() []byte {
+	return p.Nonce
+}
+
+func (p PacketConnectProof) GetProof() []byte {
+	return p.Proof
+}
+
+func (p PacketFragment) GetIndex() uint16 {
+	return p.Index
+}
+
+func (p PacketFragment) GetTotal() uint16 {
+	return p.Total
+}
+
+func (p PacketFragment) GetChunk() []byte {
+	return p.Chunk
+}
+
+func (p PacketBatch) GetAPDUs() [][]byte {
+	return p.APDUs
+}
+
+func (p PacketBatchResp) GetResponses() [][]byte {
+	return p.Responses
+}
+
+func (p PacketBatchResp) GetFailedIndex() int32 {
+	return p.FailedIndex
+}
+
+func (p PacketBatchResp) GetFailedErr() string {
+	return p.FailedErr
+}
+
+func (p PacketEvent) GetSlot() uint16 {
+	return uint16(p.Slot)
+}
+
+func (p PacketEvent) GetInserted() bool {
+	return p.Inserted
+}
+
+func (p PacketEvent) GetTimestamp() int64 {
+	return p.Timestamp
+}
+
+func (p PacketErr) GetCode() ErrCode {
+	return p.Code
 }
 
 func (p PacketCmd) String() string {
@@ -119,26 +508,173 @@ func (p PacketCmd) String() string {
 	}
 }
 
+func (p PacketErr) String() string {
+	return fmt.Sprintf("%s, Code: %s", p.PacketCmd, p.GetCode())
+}
+
 func (p PacketBody) String() string {
 	return fmt.Sprintf("%s, Body(size): %4d, Body(hex): %X", p.PacketCmd, len(p.GetBody()), p.GetBody())
 }
 
+func (p PacketChannelBody) String() string {
+	return fmt.Sprintf("%s, Channel: %d", p.PacketBody, p.GetChannel())
+}
+
 func (p PacketConnect) String() string {
-	return fmt.Sprintf("%s, Device: %s, Proto: %s, Slot: %d", p.PacketCmd, p.GetDevice(), p.GetProto(), p.GetSlot())
+	return fmt.Sprintf("%s, Device: %s, Proto: %s, Slot: %d, Version: %d", p.PacketCmd, p.GetDevice(), p.GetProto(), p.GetSlot(), p.GetProtocolVersion())
+}
+
+func (p PacketConnectAID) String() string {
+	return fmt.Sprintf("%s, AID: %X", p.PacketConnect, p.GetAID())
+}
+
+func (p PacketConnectSlot) String() string {
+	return fmt.Sprintf("%s, WideSlot: %d", p.PacketConnectAID, p.GetWideSlot())
+}
+
+func (p PacketConnectResp) String() string {
+	return fmt.Sprintf("%s, Version: %d, Resumed: %t", p.PacketCmd, p.GetProtocolVersion(), p.GetResumed())
+}
+
+func (p PacketConnectInfo) String() string {
+	return fmt.Sprintf("%s, BufferSize: %d", p.PacketConnectResp, p.GetBufferSize())
+}
+
+func (p PacketConnectChannel) String() string {
+	return fmt.Sprintf("%s, Channel: %d", p.PacketConnectInfo, p.GetChannel())
+}
+
+func (p PacketChallenge) String() string {
+	return fmt.Sprintf("%s, Nonce: %X", p.PacketCmd, p.GetNonce())
+}
+
+func (p PacketConnectProof) String() string {
+	return fmt.Sprintf("%s, Proof: %X", p.PacketChallenge, p.GetProof())
+}
+
+func (p PacketFragment) String() string {
+	return fmt.Sprintf("%s, Fragment: %d/%d, Chunk(size): %4d", p.PacketCmd, p.GetIndex()+1, p.GetTotal(), len(p.GetChunk()))
 }
 
 func NewPacketCmd(cmd Cmd) IPacketCmd {
-	return PacketCmd{cmd, ""}
+	return &PacketCmd{Cmd: cmd}
 }
 
 func NewPacketCmdErr(cmd Cmd, err string) IPacketCmd {
-	return PacketCmd{cmd, err}
+	return &PacketCmd{Cmd: cmd, Err: err}
+}
+
+func NewPacketErr(code ErrCode, err string) IPacketCmd {
+	return &PacketErr{PacketCmd{Cmd: CmdResponse, Err: err}, code}
 }
 
 func NewPacketBody(cmd Cmd, body []byte) IPacketCmd {
-	return PacketBody{PacketCmd{cmd, ""}, body}
+	return &PacketBody{PacketCmd{Cmd: cmd}, body}
+}
+
+func NewPacketChannelBody(cmd Cmd, channel byte, body []byte) IPacketCmd {
+	return &PacketChannelBody{PacketBody{PacketCmd{Cmd: cmd}, body}, channel}
+}
+
+// NewPacketConnect returns a PacketConnect, or a PacketConnectSlot when slot
+// does not fit a byte.
+func NewPacketConnect(device string, proto string, slot uint16, authToken string) IPacketCmd {
+	if slot > math.MaxUint8 {
+		return NewPacketConnectSlot(device, proto, slot, authToken, nil)
+	}
+	return &PacketConnect{PacketCmd{Cmd: CmdConnect}, device, proto, uint8(slot), CurrentProtocolVersion, authToken, 0}
+}
+
+// NewPacketConnectAID returns a PacketConnectAID, or a PacketConnectSlot
+// when slot does not fit a byte.
+func NewPacketConnectAID(device string, proto string, slot uint16, authToken string, aid []byte) IPacketCmd {
+	if slot > math.MaxUint8 {
+		return NewPacketConnectSlot(device, proto, slot, authToken, aid)
+	}
+	return &PacketConnectAID{PacketConnect{PacketCmd{Cmd: CmdConnect}, device, proto, uint8(slot), CurrentProtocolVersion, authToken, 0}, aid}
+}
+
+func NewPacketConnectSlot(device string, proto string, slot uint16, authToken string, aid []byte) IPacketCmd {
+	return &PacketConnectSlot{PacketConnectAID{PacketConnect{PacketCmd{Cmd: CmdConnect}, device, proto, 0, CurrentProtocolVersion, authToken, 0}, aid}, slot}
+}
+
+func NewPacketListSlots(device string, proto string, authToken string) IPacketCmd {
+	return &PacketConnect{PacketCmd{Cmd: CmdListSlots}, device, proto, 0, CurrentProtocolVersion, authToken, 0}
+}
+
+func NewPacketStatus(authToken string) IPacketCmd {
+	return &PacketConnect{PacketCmd{Cmd: CmdStatus}, "", "", 0, CurrentProtocolVersion, authToken, 0}
+}
+
+func NewPacketCapabilities(authToken string) IPacketCmd {
+	return &PacketConnect{PacketCmd{Cmd: CmdCapabilities}, "", "", 0, CurrentProtocolVersion, authToken, 0}
+}
+
+func NewPacketListProtocols(authToken string) IPacketCmd {
+	return &PacketConnect{PacketCmd{Cmd: CmdListProtocols}, "", "", 0, CurrentProtocolVersion, authToken, 0}
+}
+
+func NewPacketAdminKick(device string, proto string, adminToken string) IPacketCmd {
+	return &PacketConnect{PacketCmd{Cmd: CmdAdminKick}, device, proto, 0, CurrentProtocolVersion, adminToken, 0}
+}
+
+func NewPacketHealth(device string, proto string) IPacketCmd {
+	return &PacketConnect{PacketCmd{Cmd: CmdHealth}, device, proto, 0, CurrentProtocolVersion, "", 0}
+}
+
+func NewPacketSubscribe(device string, proto string, authToken string) IPacketCmd {
+	return &PacketConnect{PacketCmd{Cmd: CmdSubscribe}, device, proto, 0, CurrentProtocolVersion, authToken, 0}
+}
+
+// NewPacketEvent reports an event on a physical slot, whose number fits the
+// byte of PacketEvent.
+func NewPacketEvent(slot uint16, inserted bool, at time.Time) IPacketCmd {
+	return &PacketEvent{PacketCmd{Cmd: CmdEvent}, uint8(slot), inserted, at.UnixMilli()}
+}
+
+func NewPacketBatch(apdus [][]byte) IPacketCmd {
+	return &PacketBatch{PacketCmd{Cmd: CmdTransmitBatch}, apdus}
+}
+
+func NewPacketBatchResp(responses [][]byte, failedIndex int32, failedErr string) IPacketCmd {
+	return &PacketBatchResp{PacketCmd{Cmd: CmdResponse}, responses, failedIndex, failedErr}
+}
+
+func NewPacketChallenge(nonce []byte) IPacketCmd {
+	return &PacketChallenge{PacketCmd{Cmd: CmdResponse}, nonce}
+}
+
+func NewPacketConnectProof(nonce []byte, proof []byte) IPacketCmd {
+	return &PacketConnectProof{PacketChallenge{PacketCmd{Cmd: CmdConnectProof}, nonce}, proof}
+}
+
+func NewPacketConnectResp(version uint16, resumed bool) IPacketCmd {
+	return &PacketConnectResp{PacketCmd{Cmd: CmdResponse}, version, resumed}
+}
+
+func NewPacketConnectInfo(version uint16, resumed bool, bufferSize uint16) IPacketCmd {
+	return &PacketConnectInfo{PacketConnectResp{PacketCmd{Cmd: CmdResponse}, version, resumed}, bufferSize}
+}
+
+func NewPacketConnectChannel(version uint16, resumed bool, bufferSize uint16, channel byte) IPacketCmd {
+	return &PacketConnectChannel{PacketConnectInfo{PacketConnectResp{PacketCmd{Cmd: CmdResponse}, version, resumed}, bufferSize}, channel}
+}
+
+func NewPacketFragment(index uint16, total uint16, chunk []byte) IPacketCmd {
+	return &PacketFragment{PacketCmd{Cmd: CmdFragment}, index, total, chunk}
+}
+
+func (p PacketBatch) String() string {
+	return fmt.Sprintf("%s, APDUs: %d", p.PacketCmd, len(p.GetAPDUs()))
+}
+
+func (p PacketBatchResp) String() string {
+	if p.GetFailedIndex() < 0 {
+		return fmt.Sprintf("%s, Responses: %d", p.PacketCmd, len(p.GetResponses()))
+	}
+	return fmt.Sprintf("%s, Responses: %d, Failed: %d %s", p.PacketCmd, len(p.GetResponses()), p.GetFailedIndex(), p.GetFailedErr())
 }
 
-func NewPacketConnect(device string, proto string, slot uint8) IPacketCmd {
-	return PacketConnect{PacketCmd{CmdConnect, ""}, device, proto, slot}
+func (p PacketEvent) String() string {
+	return fmt.Sprintf("%s, Slot: %d, Inserted: %t, Timestamp: %d", p.PacketCmd, p.GetSlot(), p.GetInserted(), p.GetTimestamp())
 }
@@ -0,0 +1,152 @@
+package localnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// pipeExitTimeout is how long a server started by NewPipe may take to shut
+// down after its stdin closes before it is killed.
+const pipeExitTimeout = 10 * time.Second
+
+func NewPipe(cmd *exec.Cmd, device string, proto string, slot uint16) (apdu.SmartCardChannel, error) {
+	return NewPipeConf(cmd, device, proto, slot, NetConf{})
+}
+
+// NewPipeConf returns a channel talking to a server run as a subprocess with
+// -transport stdio, using the same length-prefixed framing as TCP over its
+// stdin and stdout. cmd is a template: each connection, Connect included,
+// starts a copy of it and ends it by closing its stdin, so cmd itself is
+// never started and its Stdin and Stdout are set by the client. Its Stderr
+// receives the server's logs. Abort needs a second connection to the same
+// server and is not supported.
+func NewPipeConf(cmd *exec.Cmd, device string, proto string, slot uint16, conf NetConf) (apdu.SmartCardChannel, error) {
+	if cmd == nil || cmd.Path == "" {
+		return nil, errors.New("pipe: a command to run is required")
+	}
+	if cmd.Stdin != nil || cmd.Stdout != nil {
+		return nil, errors.New("pipe: the command's stdin and stdout are reserved for the protocol")
+	}
+
+	warnSlot(proto, slot)
+	netctx := &NetContext{network: "pipe", serverAddr: cmd.Path, rAddr: pipeAddr(cmd.Path), pipeCmd: cmd, device: device, proto: proto, slot: slot, bufferSize: 2048, conf: conf}
+	return netctx, nil
+}
+
+// pipeAddr names the server program of a pipe connection.
+type pipeAddr string
+
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (a pipeAddr) String() string {
+	return string(a)
+}
+
+// pipeConn is a connection to a server subprocess: writes go to its stdin
+// and reads come from its stdout. Deadlines work where the OS supports them
+// on pipes.
+type pipeConn struct {
+	cmd       *exec.Cmd
+	r         *os.File
+	w         *os.File
+	closeOnce sync.Once
+}
+
+// dialPipe starts a copy of template with pipes for its stdin and stdout.
+func dialPipe(template *exec.Cmd) (net.Conn, error) {
+	cmd := &exec.Cmd{
+		Path:        template.Path,
+		Args:        template.Args,
+		Env:         template.Env,
+		Dir:         template.Dir,
+		Stderr:      template.Stderr,
+		SysProcAttr: template.SysProcAttr,
+	}
+
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+
+	err = cmd.Start()
+	// the child holds its own copies of these ends
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, fmt.Errorf("pipe: error starting %s %w", cmd.Path, err)
+	}
+	return &pipeConn{cmd: cmd, r: stdoutR, w: stdinW}, nil
+}
+
+func (p *pipeConn) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func (p *pipeConn) Write(b []byte) (int, error) {
+	return p.w.Write(b)
+}
+
+// Close closes the server's stdin, which shuts it down, and kills it if it
+// has not exited within pipeExitTimeout. It does not wait for the exit.
+func (p *pipeConn) Close() error {
+	p.closeOnce.Do(func() {
+		p.w.Close()
+		p.r.Close()
+		go p.reap()
+	})
+	return nil
+}
+
+func (p *pipeConn) reap() {
+	exited := make(chan struct{})
+	go func() {
+		p.cmd.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+	case <-time.After(pipeExitTimeout):
+		p.cmd.Process.Kill()
+		<-exited
+	}
+}
+
+func (p *pipeConn) LocalAddr() net.Addr {
+	return pipeAddr("stdio")
+}
+
+func (p *pipeConn) RemoteAddr() net.Addr {
+	return pipeAddr(p.cmd.Path)
+}
+
+func (p *pipeConn) SetDeadline(t time.Time) error {
+	return errors.Join(p.r.SetReadDeadline(t), p.w.SetWriteDeadline(t))
+}
+
+func (p *pipeConn) SetReadDeadline(t time.Time) error {
+	return p.r.SetReadDeadline(t)
+}
+
+func (p *pipeConn) SetWriteDeadline(t time.Time) error {
+	return p.w.SetWriteDeadline(t)
+}
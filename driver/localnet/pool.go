@@ -0,0 +1,217 @@
+package localnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// poolPingAfter is how long a pooled context may sit idle before Get checks
+// that its session is still alive.
+const poolPingAfter = 5 * time.Second
+
+var ErrPoolClosed = errors.New("pool closed")
+
+// Pool keeps connected NetContexts for reuse, one per device on a server,
+// so that a job opening and closing the same card repeatedly does not dial
+// and connect each time. The server allows one session per device, so a
+// context is handed to one caller at a time: Get waits while another caller
+// holds it, and Put returns it. It is safe for concurrent use.
+type Pool struct {
+	network    string
+	bufferSize uint16
+	conf       NetConf
+
+	mu      sync.Mutex
+	entries map[poolKey]*poolEntry
+	// checkedOut holds the key each context was handed out under, which
+	// SwitchSlot may have moved it away from.
+	checkedOut map[*NetContext]poolKey
+	closed     bool
+}
+
+type poolKey struct {
+	serverAddr string
+	device     string
+	proto      string
+	slot       uint16
+}
+
+// poolEntry holds the idle context of a key in a one-slot channel; taking
+// it out checks the context out. A nil value means none is connected yet.
+type poolEntry struct {
+	idle     chan *NetContext
+	lastUsed time.Time
+}
+
+// NewPool returns a pool creating contexts over network, which is "udp",
+// "tcp", "unix" or "ws", with bufferSize and conf as NewUDPConf takes them.
+func NewPool(network string, bufferSize uint16, conf NetConf) (*Pool, error) {
+	switch network {
+	case "udp", "tcp", "unix", "ws":
+	default:
+		return nil, fmt.Errorf("unsupported network: %s", network)
+	}
+	return &Pool{network: network, bufferSize: bufferSize, conf: conf, entries: make(map[poolKey]*poolEntry), checkedOut: make(map[*NetContext]poolKey)}, nil
+}
+
+// Get returns a connected context for the device, reusing the pooled one
+// when its session is still alive and connecting otherwise. Hand it back
+// with Put when done.
+func (p *Pool) Get(ctx context.Context, serverAddr string, device string, proto string, slot uint16) (*NetContext, error) {
+	key := poolKey{serverAddr: serverAddr, device: device, proto: proto, slot: slot}
+	entry, err := p.entry(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var nc *NetContext
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case nc = <-entry.idle:
+	}
+
+	if p.isClosed() {
+		if nc != nil && nc.conn != nil {
+			nc.Disconnect()
+		}
+		entry.idle <- nil
+		return nil, ErrPoolClosed
+	}
+
+	if nc == nil {
+		if nc, err = p.newContext(serverAddr, device, proto, slot); err != nil {
+			entry.idle <- nil
+			return nil, err
+		}
+	}
+
+	if nc.conn != nil && time.Since(entry.lastUsed) < poolPingAfter {
+		return p.checkOut(nc, key), nil
+	}
+	if nc.conn != nil && nc.PingContext(ctx) == nil {
+		return p.checkOut(nc, key), nil
+	}
+
+	// the session expired or was never opened; a reconnect presents the old
+	// token, so a session the server still holds is resumed
+	if err = nc.ConnectContext(ctx); err != nil {
+		// keep the token for a later resume, but not a connection without a
+		// session behind it
+		if nc.conn != nil {
+			nc.conn.Close()
+			nc.conn = nil
+		}
+		entry.idle <- nc
+		return nil, err
+	}
+	return p.checkOut(nc, key), nil
+}
+
+func (p *Pool) checkOut(nc *NetContext, key poolKey) *NetContext {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checkedOut[nc] = key
+	return nc
+}
+
+// Put returns a context obtained from Get. A context the caller disconnected
+// is connected again by the next Get. A context the pool did not hand out is
+// disconnected rather than pooled, and so is one switched to another slot,
+// which would otherwise be handed out for the slot it was got for.
+func (p *Pool) Put(nc *NetContext) {
+	p.mu.Lock()
+	key, ok := p.checkedOut[nc]
+	delete(p.checkedOut, nc)
+	entry := p.entries[key]
+	closed := p.closed
+	p.mu.Unlock()
+
+	if !ok || entry == nil {
+		if nc.conn != nil {
+			nc.Disconnect()
+		}
+		return
+	}
+	if closed || nc.slot != key.slot {
+		if nc.conn != nil {
+			nc.Disconnect()
+		}
+		nc = nil
+	}
+	entry.lastUsed = time.Now()
+	entry.idle <- nc
+}
+
+// Close disconnects the idle contexts and makes further calls to Get fail.
+// Contexts still checked out are disconnected when they are Put back.
+func (p *Pool) Close() error {
+	// Get adds entries under the lock, so they are collected under it too
+	p.mu.Lock()
+	p.closed = true
+	entries := make([]*poolEntry, 0, len(p.entries))
+	for _, entry := range p.entries {
+		entries = append(entries, entry)
+	}
+	p.mu.Unlock()
+
+	var errs []error
+	for _, entry := range entries {
+		select {
+		case nc := <-entry.idle:
+			if nc != nil && nc.conn != nil {
+				errs = append(errs, nc.Disconnect())
+			}
+			// wakes callers waiting in Get, which then see the pool closed
+			entry.idle <- nil
+		default:
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Pool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (p *Pool) entry(key poolKey) (*poolEntry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	entry, ok := p.entries[key]
+	if !ok {
+		entry = &poolEntry{idle: make(chan *NetContext, 1)}
+		entry.idle <- nil
+		p.entries[key] = entry
+	}
+	return entry, nil
+}
+
+func (p *Pool) newContext(serverAddr string, device string, proto string, slot uint16) (*NetContext, error) {
+	var channel apdu.SmartCardChannel
+	var err error
+	switch p.network {
+	case "tcp":
+		channel, err = NewTCPConf(serverAddr, device, proto, slot, p.bufferSize, p.conf)
+	case "unix":
+		channel, err = NewUnixConf(serverAddr, device, proto, slot, p.conf)
+	case "ws":
+		channel, err = NewWebSocketConf(serverAddr, device, proto, slot, p.conf)
+	default:
+		channel, err = NewUDPConf(serverAddr, device, proto, slot, p.bufferSize, p.conf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return channel.(*NetContext), nil
+}
@@ -0,0 +1,87 @@
+package localnet
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestPoolPutForeignContext(t *testing.T) {
+	p, err := NewPool("udp", 0, NetConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := NewUDP("127.0.0.1:9", "/dev/cdc-wdm0", "qmi", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pool never handed it out, so it must not be pooled
+	p.Put(ch.(*NetContext))
+	if len(p.entries) != 0 {
+		t.Fatalf("foreign context created %d entries", len(p.entries))
+	}
+}
+
+func TestPoolCloseWhileGetting(t *testing.T) {
+	p, err := NewPool("udp", 0, NetConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Get keeps adding entries for new devices while Close walks them; run
+	// with -race to catch unguarded access to the entries
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				_, err := p.Get(ctx, "127.0.0.1:9", fmt.Sprintf("/dev/ttyUSB%d.%d", g, i), "qmi", 1)
+				if err == ErrPoolClosed {
+					return
+				}
+			}
+		}()
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if _, err := p.Get(context.Background(), "127.0.0.1:9", "/dev/cdc-wdm0", "qmi", 1); err != ErrPoolClosed {
+		t.Fatalf("Get after Close: %v, want ErrPoolClosed", err)
+	}
+}
+
+func TestPoolPutAfterSwitchSlot(t *testing.T) {
+	p, err := NewPool("udp", 0, NetConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := poolKey{serverAddr: "127.0.0.1:9", device: "/dev/cdc-wdm0", proto: "qmi", slot: 1}
+	entry, err := p.entry(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-entry.idle
+	nc, err := p.newContext(key.serverAddr, key.device, key.proto, key.slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.checkOut(nc, key)
+
+	// as SwitchSlot leaves it
+	nc.slot = 2
+	p.Put(nc)
+
+	if idle := <-entry.idle; idle != nil {
+		t.Fatalf("context switched to slot %d pooled for slot %d", idle.slot, key.slot)
+	}
+	if len(p.checkedOut) != 0 {
+		t.Fatalf("%d contexts still checked out", len(p.checkedOut))
+	}
+}
@@ -0,0 +1,170 @@
+package localnet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ProfileState is the state of an installed profile, as in the SGP.22
+// profileState.
+type ProfileState byte
+
+const (
+	ProfileDisabled ProfileState = 0
+	ProfileEnabled  ProfileState = 1
+)
+
+func (s ProfileState) String() string {
+	switch s {
+	case ProfileDisabled:
+		return "disabled"
+	case ProfileEnabled:
+		return "enabled"
+	}
+	return fmt.Sprintf("ProfileState(%d)", byte(s))
+}
+
+// Profile is one installed profile in the CmdGetProfilesInfo response,
+// carried as a JSON array in a PacketBody. Fields the card leaves out are
+// empty.
+type Profile struct {
+	ICCID               string       `json:"iccid"`
+	State               ProfileState `json:"state"`
+	Nickname            string       `json:"nickname,omitempty"`
+	ServiceProviderName string       `json:"serviceProviderName,omitempty"`
+	Name                string       `json:"name,omitempty"`
+}
+
+// GetProfilesInfo lists the profiles installed on the eUICC, an empty list
+// when there are none. Like GetEID, the server selects the ISD-R on a
+// logical channel of its own and parses the ProfileInfoListResponse, so the
+// caller needs no channel open. A server without FeatureProfiles fails it
+// with ErrNotSupported.
+func (c *NetContext) GetProfilesInfo() ([]Profile, error) {
+	return c.GetProfilesInfoContext(context.Background())
+}
+
+func (c *NetContext) GetProfilesInfoContext(ctx context.Context) ([]Profile, error) {
+	if err := c.requireFeature(ctx, FeatureProfiles); err != nil {
+		return nil, err
+	}
+	bb, er := remoteCall(ctx, c, NewPacketCmd(CmdGetProfilesInfo))
+	if er != nil {
+		return nil, er
+	}
+
+	profiles := []Profile{}
+	if err := json.Unmarshal(bb, &profiles); err != nil {
+		return nil, fmt.Errorf("getprofilesinfo: error decoding response %w", err)
+	}
+	return profiles, nil
+}
+
+// ProfileResult is the result code of an SGP.22 EnableProfileResponse or
+// DisableProfileResponse, which the server answers CmdEnableProfile and
+// CmdDisableProfile with as a one-byte body.
+type ProfileResult byte
+
+const (
+	ProfileResultOK ProfileResult = 0
+	// ProfileResultNotFound is an ICCID the eUICC has no profile for.
+	ProfileResultNotFound ProfileResult = 1
+	// ProfileResultWrongState is enabling a profile that is not disabled,
+	// or disabling one that is not enabled.
+	ProfileResultWrongState         ProfileResult = 2
+	ProfileResultDisallowedByPolicy ProfileResult = 3
+	ProfileResultWrongReenabling    ProfileResult = 4
+	// ProfileResultCATBusy is a card busy with a proactive session, which
+	// may succeed when asked again.
+	ProfileResultCATBusy   ProfileResult = 5
+	ProfileResultUndefined ProfileResult = 127
+)
+
+var profileResultNames = map[ProfileResult]string{
+	ProfileResultOK:                 "ok",
+	ProfileResultNotFound:           "iccidOrAidNotFound",
+	ProfileResultWrongState:         "profileNotInExpectedState",
+	ProfileResultDisallowedByPolicy: "disallowedByPolicy",
+	ProfileResultWrongReenabling:    "wrongProfileReenabling",
+	ProfileResultCATBusy:            "catBusy",
+	ProfileResultUndefined:          "undefinedError",
+}
+
+func (r ProfileResult) String() string {
+	if name, ok := profileResultNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("ProfileResult(%d)", byte(r))
+}
+
+// ProfileError is a profile the eUICC refused to enable or disable.
+type ProfileError struct {
+	Op     string
+	ICCID  string
+	Result ProfileResult
+}
+
+func (e *ProfileError) Error() string {
+	return fmt.Sprintf("%s %s: card returned %s (%d)", e.Op, e.ICCID, e.Result, byte(e.Result))
+}
+
+var ErrInvalidICCID = errors.New("invalid iccid")
+
+// CheckICCID rejects an ICCID that is not 18 to 20 decimal digits.
+func CheckICCID(iccid string) error {
+	if len(iccid) < 18 || len(iccid) > 20 || strings.Trim(iccid, "0123456789") != "" {
+		return fmt.Errorf("%w: %q, need 18 to 20 digits", ErrInvalidICCID, iccid)
+	}
+	return nil
+}
+
+// EnableProfile enables the profile with the given ICCID, which disables
+// the one enabled before. The server sends the EnableProfileRequest to the
+// ISD-R like GetProfilesInfo and asks the eUICC to REFRESH, so the modem
+// picks up the new profile; the server then reopens its connection to the
+// card once it is back. Logical channels opened before are gone, as after
+// Reset. A card refusing the change fails the call with a *ProfileError
+// holding its result code. A server without FeatureProfileState fails it
+// with ErrNotSupported.
+func (c *NetContext) EnableProfile(iccid string) error {
+	return c.EnableProfileContext(context.Background(), iccid)
+}
+
+func (c *NetContext) EnableProfileContext(ctx context.Context, iccid string) error {
+	return c.setProfileState(ctx, CmdEnableProfile, "enable profile", iccid)
+}
+
+// DisableProfile disables the enabled profile with the given ICCID, leaving
+// the eUICC without an enabled profile. It refreshes the card and fails
+// like EnableProfile.
+func (c *NetContext) DisableProfile(iccid string) error {
+	return c.DisableProfileContext(context.Background(), iccid)
+}
+
+func (c *NetContext) DisableProfileContext(ctx context.Context, iccid string) error {
+	return c.setProfileState(ctx, CmdDisableProfile, "disable profile", iccid)
+}
+
+func (c *NetContext) setProfileState(ctx context.Context, cmd Cmd, op string, iccid string) error {
+	if err := CheckICCID(iccid); err != nil {
+		return err
+	}
+	if err := c.requireFeature(ctx, FeatureProfileState); err != nil {
+		return err
+	}
+	bb, er := remoteCall(ctx, c, NewPacketBody(cmd, []byte(iccid)))
+	if er != nil {
+		return er
+	}
+	if len(bb) != 1 {
+		return fmt.Errorf("%s: malformed response %X", op, bb)
+	}
+	if result := ProfileResult(bb[0]); result != ProfileResultOK {
+		return &ProfileError{Op: op, ICCID: iccid, Result: result}
+	}
+	c.forgetChannels()
+	return nil
+}
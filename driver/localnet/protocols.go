@@ -0,0 +1,67 @@
+package localnet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ProtocolInfo describes a driver a server offers, in the CmdListProtocols
+// response, carried as JSON in a PacketBody. Slots is set for drivers that
+// open a slot numbered from 1. Available reports whether what the driver
+// needs is present on the server's host, such as the PC/SC service or a
+// device node of its kind; Reason says what is missing when it is not. An
+// available driver may still fail to open a given device.
+type ProtocolInfo struct {
+	Name      string `json:"name"`
+	Slots     bool   `json:"slots"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// ListProtocols returns the names of the protocols the server lets clients
+// connect with, available on its host or not. Like Status it needs no
+// session. A server without FeatureListProtocols fails it with
+// ErrNotSupported.
+func (c *NetContext) ListProtocols() ([]string, error) {
+	return c.ListProtocolsContext(context.Background())
+}
+
+func (c *NetContext) ListProtocolsContext(ctx context.Context) ([]string, error) {
+	protocols, err := c.ProtocolsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(protocols))
+	for i, p := range protocols {
+		names[i] = p.Name
+	}
+	return names, nil
+}
+
+// Protocols is ListProtocols with the details of each protocol, sorted by
+// name.
+func (c *NetContext) Protocols() ([]ProtocolInfo, error) {
+	return c.ProtocolsContext(context.Background())
+}
+
+func (c *NetContext) ProtocolsContext(ctx context.Context) ([]ProtocolInfo, error) {
+	release, err := c.borrowConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err = c.requireFeature(ctx, FeatureListProtocols); err != nil {
+		return nil, err
+	}
+	bb, err := remoteCall(ctx, c, NewPacketListProtocols(c.conf.AuthToken))
+	if err != nil {
+		return nil, err
+	}
+	var protocols []ProtocolInfo
+	if err = json.Unmarshal(bb, &protocols); err != nil {
+		return nil, fmt.Errorf("list protocols: error decoding response %w", err)
+	}
+	return protocols, nil
+}
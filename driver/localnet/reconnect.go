@@ -0,0 +1,70 @@
+package localnet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+)
+
+// LogicalChannel is a channel this context opened and the AID selected on
+// it.
+type LogicalChannel struct {
+	Channel byte
+	AID     []byte
+}
+
+// LogicalChannels lists the channels opened through this context and not
+// closed, oldest first. Reconnect may move them to other numbers.
+func (c *NetContext) LogicalChannels() []LogicalChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.channels)
+}
+
+func (c *NetContext) rememberChannel(channel byte, aid []byte) {
+	c.mu.Lock()
+	c.channels = append(c.channels, LogicalChannel{Channel: channel, AID: bytes.Clone(aid)})
+	c.mu.Unlock()
+}
+
+func (c *NetContext) forgetChannel(channel byte) {
+	c.mu.Lock()
+	c.channels = slices.DeleteFunc(c.channels, func(lc LogicalChannel) bool { return lc.Channel == channel })
+	c.mu.Unlock()
+}
+
+func (c *NetContext) forgetChannels() {
+	c.mu.Lock()
+	c.channels = nil
+	c.mu.Unlock()
+}
+
+// Reconnect re-dials the server and runs the connect handshake again, for a
+// long-running client to survive a server restart or a broken connection.
+// If the server still holds the session it is resumed with its channels
+// open. Otherwise every channel opened through this context is opened
+// again on the same AID, in the original order; the card may hand out other
+// numbers, which LogicalChannels reports. Only the transport and the
+// channels are restored: whatever the card held beyond the selected
+// application, such as a half-finished profile download, is lost.
+func (c *NetContext) Reconnect() error {
+	return c.ReconnectContext(context.Background())
+}
+
+func (c *NetContext) ReconnectContext(ctx context.Context) error {
+	channels := c.LogicalChannels()
+	if err := c.ConnectContext(ctx); err != nil {
+		return err
+	}
+	if c.resumed {
+		return nil
+	}
+
+	for _, lc := range channels {
+		if _, err := c.OpenLogicalChannelContext(ctx, lc.AID); err != nil {
+			return fmt.Errorf("reconnect: error reopening channel %d aid=%X %w", lc.Channel, lc.AID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package localnet
+
+import "context"
+
+// RepeatLast asks the server for its last reply in the session again and
+// returns its body, or its error. Nothing runs on the card, so it is the
+// safe way to recover the reply to a Transmit that timed out, where sending
+// the APDU again would run it twice. Ping, which the background keepalive
+// sends, Abort, Subscribe and queries needing no session such as Status do
+// not count as the last reply. A session with none yet fails with
+// ErrCodeNotFound. A server without FeatureRepeatLast fails it with
+// ErrNotSupported.
+func (c *NetContext) RepeatLast() ([]byte, error) {
+	return c.RepeatLastContext(context.Background())
+}
+
+func (c *NetContext) RepeatLastContext(ctx context.Context) ([]byte, error) {
+	if err := c.requireFeature(ctx, FeatureRepeatLast); err != nil {
+		return nil, err
+	}
+	return remoteCall(ctx, c, NewPacketCmd(CmdRepeatLast))
+}
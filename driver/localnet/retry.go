@@ -0,0 +1,63 @@
+package localnet
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	DefaultRetryTimeout = 2 * time.Second
+	DefaultRetryBackoff = 200 * time.Millisecond
+)
+
+// retryable reports whether pcSnd may be resent after its reply was lost.
+// Streams never lose packets. Commands that change the card or the session
+// are repeated only when the server replays responses by request ID, or for
+// CmdTransmit when the application opted in.
+func (c *NetContext) retryable(pcSnd IPacketCmd) bool {
+	if c.isStream() || c.conf.MaxRetries <= 0 {
+		return false
+	}
+
+	switch pcSnd.GetCmd() {
+	case CmdConnect, CmdConnectProof, CmdPing, CmdListSlots, CmdStatus, CmdGetEID, CmdCapabilities, CmdSelect, CmdHealth, CmdGetProfilesInfo, CmdKeepChannelAlive, CmdRepeatLast, CmdListProtocols, CmdSelfTest:
+		return true
+	case CmdOpenLogical, CmdCloseLogical, CmdReset, CmdStoreData, CmdSwitchSlot, CmdEnableProfile, CmdDisableProfile:
+		return c.protocolVersion >= ProtocolVersion3
+	case CmdTransmit, CmdTransmitOn, CmdTransmitBatch:
+		return c.conf.RetryTransmit || c.protocolVersion >= ProtocolVersion3
+	}
+	return false
+}
+
+func exchangeWithRetry(ctx context.Context, nc *NetContext, pcSnd IPacketCmd) (IPacketCmd, error) {
+	timeout := nc.conf.RetryTimeout
+	if timeout == 0 {
+		timeout = DefaultRetryTimeout
+	}
+	backoff := nc.conf.RetryBackoff
+	if backoff == 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		pcRcv, err := exchange(attemptCtx, nc, pcSnd)
+		cancel()
+
+		if err == nil || attempt >= nc.conf.MaxRetries || ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+			return pcRcv, err
+		}
+
+		slog.Debug("no reply, retrying", "cmd", pcSnd.GetCmd(), "attempt", attempt+1, "server", nc.rAddr)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff << attempt):
+		}
+		nc.countRetry()
+	}
+}
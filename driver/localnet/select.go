@@ -0,0 +1,42 @@
+package localnet
+
+import "context"
+
+// Select selects the application aid on the logical channel opened last
+// through this context, or on the basic channel when none is open, and
+// returns its FCI without the status word. The server builds the SELECT and
+// fetches the rest of the FCI when the card answers 61xx; any final status
+// other than 9000 is an error. A server without FeatureSelect fails it with
+// ErrNotSupported.
+func (c *NetContext) Select(aid []byte) ([]byte, error) {
+	return c.SelectContext(context.Background(), aid)
+}
+
+func (c *NetContext) SelectContext(ctx context.Context, aid []byte) ([]byte, error) {
+	var channel byte
+	if channels := c.LogicalChannels(); len(channels) > 0 {
+		channel = channels[len(channels)-1].Channel
+	}
+	return c.SelectOnContext(ctx, channel, aid)
+}
+
+// SelectOn is Select on the given channel, which the session must have open
+// unless it is the basic channel 0.
+func (c *NetContext) SelectOn(channel byte, aid []byte) ([]byte, error) {
+	return c.SelectOnContext(context.Background(), channel, aid)
+}
+
+func (c *NetContext) SelectOnContext(ctx context.Context, channel byte, aid []byte) ([]byte, error) {
+	if err := CheckAID(aid); err != nil {
+		return nil, err
+	}
+	if channel != 0 {
+		if err := CheckChannel(channel); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.requireFeature(ctx, FeatureSelect); err != nil {
+		return nil, err
+	}
+	return remoteCall(ctx, c, NewPacketChannelBody(CmdSelect, channel, aid))
+}
@@ -0,0 +1,78 @@
+package localnet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Steps of a CmdSelfTest, in the order the server runs them.
+const (
+	SelfTestOpenISDR  = "openISDR"
+	SelfTestGetEID    = "getEID"
+	SelfTestCloseISDR = "closeISDR"
+)
+
+// SelfTestResult is the CmdSelfTest response, carried as JSON in a
+// PacketBody. OK is set when every step passed, and EID when it could be
+// read. Durations are in nanoseconds.
+type SelfTestResult struct {
+	OK       bool           `json:"ok"`
+	EID      string         `json:"eid,omitempty"`
+	Steps    []SelfTestStep `json:"steps"`
+	Duration time.Duration  `json:"duration"`
+}
+
+// SelfTestStep is one step of a self-test. Detail says what the step saw,
+// such as the channel opened, and Error why it failed. A step that could
+// not run because an earlier one failed is Skipped.
+type SelfTestStep struct {
+	Name     string        `json:"name"`
+	OK       bool          `json:"ok"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Detail   string        `json:"detail,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Err describes the first failed step, nil when the self-test passed.
+func (r *SelfTestResult) Err() error {
+	for _, step := range r.Steps {
+		if !step.OK && !step.Skipped {
+			return fmt.Errorf("self test: %s failed: %s", step.Name, step.Error)
+		}
+	}
+	if !r.OK {
+		return errors.New("self test failed")
+	}
+	return nil
+}
+
+// SelfTest has the server check the modem and card path of the session: it
+// opens a logical channel on the ISD-R, reads the EID over it and closes
+// the channel, timing each step. A card failing a step is reported in the
+// result, not as an error; the error is for a call that did not run, such
+// as a device gone or a command timeout. The session's own channels are
+// left alone. A server without FeatureSelfTest fails it with
+// ErrNotSupported.
+func (c *NetContext) SelfTest() (*SelfTestResult, error) {
+	return c.SelfTestContext(context.Background())
+}
+
+func (c *NetContext) SelfTestContext(ctx context.Context) (*SelfTestResult, error) {
+	if err := c.requireFeature(ctx, FeatureSelfTest); err != nil {
+		return nil, err
+	}
+	bb, er := remoteCall(ctx, c, NewPacketCmd(CmdSelfTest))
+	if er != nil {
+		return nil, er
+	}
+
+	result := new(SelfTestResult)
+	if err := json.Unmarshal(bb, result); err != nil {
+		return nil, fmt.Errorf("selftest: error decoding response %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,85 @@
+package localnet
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"github.com/damonto/euicc-go/apdu"
+	"golang.org/x/net/websocket"
+)
+
+func NewTCP(serverAddr string, device string, proto string, slot uint16, bufferSize uint16) (apdu.SmartCardChannel, error) {
+	return NewTCPConf(serverAddr, device, proto, slot, bufferSize, NetConf{})
+}
+
+// NewTCPConf returns a channel that sends length-prefixed packets over a
+// persistent TCP connection. Packets are never fragmented on a stream, so
+// bufferSize is only kept for symmetry with NewUDP.
+func NewTCPConf(serverAddr string, device string, proto string, slot uint16, bufferSize uint16, conf NetConf) (apdu.SmartCardChannel, error) {
+	rAddr, err := net.ResolveTCPAddr("tcp", serverAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving address: %s %w", serverAddr, err)
+	}
+
+	if bufferSize == 0 {
+		bufferSize = 2048 // default
+	}
+
+	warnSlot(proto, slot)
+	netctx := &NetContext{network: "tcp", serverAddr: serverAddr, rAddr: rAddr, device: device, proto: proto, slot: slot, bufferSize: bufferSize, conf: conf}
+	return netctx, nil
+}
+
+func writeStreamPacket(nc *NetContext, pcSnd IPacketCmd) error {
+	byteArray, err := EncodeWire(pcSnd, nc.wire())
+	if err != nil {
+		return fmt.Errorf("error encoding message %s %w", pcSnd, err)
+	}
+
+	if ws, ok := nc.conn.(*websocket.Conn); ok {
+		err = websocket.Message.Send(ws, byteArray)
+	} else {
+		err = WriteFrame(nc.conn, byteArray)
+	}
+	if err != nil {
+		closeOutOfStep(nc, err)
+		return fmt.Errorf("error sending message %s %w", pcSnd, err)
+	}
+	nc.countSent(len(byteArray))
+	return nil
+}
+
+func readStreamPacket(nc *NetContext) (IPacketCmd, error) {
+	var byteArray []byte
+	var err error
+	if ws, ok := nc.conn.(*websocket.Conn); ok {
+		err = websocket.Message.Receive(ws, &byteArray)
+	} else {
+		byteArray, err = ReadFrame(nc.conn)
+	}
+	if err != nil {
+		closeOutOfStep(nc, err)
+		return nil, fmt.Errorf("error receiving response %w", err)
+	}
+	nc.countReceived(len(byteArray))
+
+	pcRcv, err := Decode(byteArray)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding response %X %w", byteArray, err)
+	}
+	return pcRcv, nil
+}
+
+// closeOutOfStep closes a stream a call failed on in the middle of a frame,
+// typically when its deadline cut into a large packet. Later calls then
+// fail on the closed connection instead of reading a reply from the middle
+// of a frame; Reconnect dials a new one. A deadline passing between frames
+// leaves the stream usable and the late reply is dropped by its request ID.
+func closeOutOfStep(nc *NetContext, err error) {
+	if errors.Is(err, ErrPartialFrame) {
+		slog.Warn("closing stream out of step", "server", nc.rAddr, "error", err)
+		nc.conn.Close()
+	}
+}
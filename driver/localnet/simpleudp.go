@@ -1,9 +1,17 @@
 package localnet
 
 import (
-	"errors"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"log/slog"
+	"math"
 	"net"
+	"os/exec"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/damonto/euicc-go/apdu"
 )
@@ -11,19 +19,123 @@ import (
 const InvalidChannel byte = 0xFF
 
 type NetContext struct {
+	network    string
 	serverAddr string
-	rAddr      *net.UDPAddr
-	conn       *net.UDPConn
+	rAddr      net.Addr
+	conn       net.Conn
 	device     string
 	proto      string
-	slot       uint8
+	slot       uint16
 	bufferSize uint16
+	conf       NetConf
+
+	// pipeCmd is the server program NewPipe starts for each connection.
+	pipeCmd *exec.Cmd
+	// compression is the algorithm agreed with the server on connect, 0
+	// for gzip.
+	compression byte
+
+	// serverBufferSize is the server's buffer size from the connect
+	// response, 0 when the server did not report it.
+	serverBufferSize uint16
+
+	protocolVersion uint16
+	sessionToken    string
+	resumed         bool
+	lastRequestID   uint64
+	lastStatusWord  uint16
+	breaker         breaker
+	// channels are the logical channels opened and not closed, for
+	// Reconnect to reopen.
+	channels []LogicalChannel
+	// lastActivity is when the session was last used, in Unix nanoseconds,
+	// 0 without a session.
+	lastActivity atomic.Int64
+	// tracer is the Tracer set with SetTracer, nil without one.
+	tracer atomic.Pointer[Tracer]
+
+	// mu serializes request/response exchanges with the keepalive goroutine.
+	mu            sync.Mutex
+	keepAliveStop chan struct{}
+	keepAliveDone chan struct{}
+
+	// capsMu guards features, the server's capabilities once asked for.
+	capsMu   sync.Mutex
+	features []string
+
+	// statsMu guards stats, the traffic counters Stats returns.
+	statsMu sync.Mutex
+	stats   Stats
+
+	eventsMu   sync.Mutex
+	events     chan Event
+	eventsStop context.CancelFunc
+	eventsDone chan struct{}
 }
 
 type NetConf struct {
+	// DTLS wraps the UDP socket in a DTLS 1.2 session when set.
+	DTLS *DTLSConf
+	// AllowPlaintext permits falling back to plain UDP if the DTLS handshake fails.
+	AllowPlaintext bool
+	// FragmentTimeout bounds how long to wait for the rest of a fragmented response.
+	FragmentTimeout time.Duration
+	// AuthToken is presented to servers started with -authToken.
+	AuthToken string
+	// AdminToken is presented with admin commands, which servers refuse
+	// unless started with the same -adminToken.
+	AdminToken string
+	// KeepAliveInterval, when positive, pings the server at this interval
+	// while connected so idle sessions do not time out.
+	KeepAliveInterval time.Duration
+	// MaxRetries is how many times an idempotent command is resent over UDP
+	// when no reply arrives within RetryTimeout.
+	MaxRetries int
+	// RetryTimeout bounds each attempt when retries are enabled.
+	RetryTimeout time.Duration
+	// RetryBackoff is the pause before the first retry, doubled for each
+	// further one.
+	RetryBackoff time.Duration
+	// RetryTransmit also retries CmdTransmit. A lost reply does not mean the
+	// card never ran the APDU, so only enable this for APDUs safe to repeat.
+	RetryTransmit bool
+	// MaxAPDUSize rejects larger APDUs before they are sent; 0 means
+	// DefaultMaxAPDUSize.
+	MaxAPDUSize int
+	// BreakerThreshold, when positive, is how many calls in a row may fail
+	// to get an answer before further calls fail fast with ErrCircuitOpen.
+	BreakerThreshold int
+	// BreakerCooldown is how long calls fail fast before one is let through
+	// to probe the server; 0 means DefaultBreakerCooldown.
+	BreakerCooldown time.Duration
+	// SessionTimeout asks the server to keep an idle session this long
+	// instead of its default. Servers reject values outside the bounds they
+	// were started with.
+	SessionTimeout time.Duration
+	// ReadBuffer and WriteBuffer, when positive, set the kernel receive and
+	// send buffers of the UDP socket in bytes. The OS may clamp them.
+	ReadBuffer  int
+	WriteBuffer int
+	// Zstd compresses packets with zstd rather than gzip once Connect finds
+	// that the server offers FeatureZstd; otherwise gzip stays in use.
+	Zstd bool
+	// DSCP, when positive, marks the UDP datagrams sent with this
+	// differentiated services code point, 0 to MaxDSCP, so that networks
+	// honouring it can prioritize them. Where the system cannot set it the
+	// client logs a warning and sends unmarked.
+	DSCP int
+	// Timeout bounds each call, Connect included, made with a context that
+	// has no deadline, retries included; 0 means DefaultTimeout and a
+	// negative value waits indefinitely. It is also what the server is told
+	// it may spend on the command.
+	Timeout time.Duration
+}
+
+func NewUDP(serverAddr string, device string, proto string, slot uint16, bufferSize uint16) (apdu.SmartCardChannel, error) {
+	return NewUDPConf(serverAddr, device, proto, slot, bufferSize, NetConf{})
 }
 
-func NewUDP(serverAddr string, device string, proto string, slot uint8, bufferSize uint16) (apdu.SmartCardChannel, error) {
+func NewUDPConf(serverAddr string, device string, proto string, slot uint16, bufferSize uint16, conf NetConf) (apdu.SmartCardChannel, error) {
 	rAddr, err := net.ResolveUDPAddr("udp", serverAddr)
 	if err != nil {
 		return nil, fmt.Errorf("error resolving address: %s %w", serverAddr, err)
@@ -37,75 +149,401 @@ func NewUDP(serverAddr string, device string, proto string, slot uint8, bufferSi
 		return nil, fmt.Errorf("bufferSize too small: %d (minimum 512)", bufferSize)
 	}
 
-	netctx := &NetContext{serverAddr: serverAddr, rAddr: rAddr, device: device, proto: proto, slot: slot, bufferSize: bufferSize}
+	if conf.DSCP < 0 || conf.DSCP > MaxDSCP {
+		return nil, fmt.Errorf("dscp out of range: %d, expected 0 to %d", conf.DSCP, MaxDSCP)
+	}
+
+	warnSlot(proto, slot)
+	netctx := &NetContext{network: "udp", serverAddr: serverAddr, rAddr: rAddr, device: device, proto: proto, slot: slot, bufferSize: bufferSize, conf: conf}
 	return netctx, nil
 }
 
+// Connect starts a session on the device. A slot above 255 needs a server
+// offering FeatureWideSlots, others fail it with ErrNotSupported before
+// connecting.
 func (c *NetContext) Connect() error {
-	conn, err := net.DialUDP("udp", nil, c.rAddr)
+	return c.ConnectContext(context.Background())
+}
+
+func (c *NetContext) ConnectContext(ctx context.Context) error {
+	if err := c.requireConnectFeature(ctx, c.slot > math.MaxUint8, FeatureWideSlots); err != nil {
+		return err
+	}
+	connect := NewPacketConnect(c.device, c.proto, c.slot, c.conf.AuthToken)
+	connect.(requestedTimeoutSetter).SetRequestedTimeout(c.conf.SessionTimeout)
+	_, err := c.connect(ctx, connect)
+	return err
+}
+
+// ConnectOpen connects like Connect and opens a logical channel on aid in
+// the same round trip, returning its number. If the open fails the server
+// ends the session it just started and ConnectOpen returns the error. A
+// resumed session answers with the channel it already has open on aid, if
+// any. A server without FeatureConnectAID fails it with ErrNotSupported
+// before connecting.
+func (c *NetContext) ConnectOpen(aid []byte) (byte, error) {
+	return c.ConnectOpenContext(context.Background(), aid)
+}
+
+func (c *NetContext) ConnectOpenContext(ctx context.Context, aid []byte) (byte, error) {
+	if err := CheckAID(aid); err != nil {
+		return InvalidChannel, err
+	}
+	if err := c.requireConnectFeature(ctx, true, FeatureConnectAID); err != nil {
+		return InvalidChannel, err
+	}
+	if c.slot > math.MaxUint8 {
+		if err := c.requireFeature(ctx, FeatureWideSlots); err != nil {
+			return InvalidChannel, err
+		}
+	}
+
+	connect := NewPacketConnectAID(c.device, c.proto, c.slot, c.conf.AuthToken, aid)
+	connect.(requestedTimeoutSetter).SetRequestedTimeout(c.conf.SessionTimeout)
+	pcRcv, err := c.connect(ctx, connect)
 	if err != nil {
-		return fmt.Errorf("error establishing connection with %s %w", c.rAddr, err)
+		return InvalidChannel, err
+	}
+	resp, ok := pcRcv.(IPacketConnectChannel)
+	if !ok {
+		return InvalidChannel, fmt.Errorf("connect: expected a logical channel, received %s", pcRcv)
+	}
+	channel := resp.GetChannel()
+	if err = CheckChannel(channel); err != nil {
+		return InvalidChannel, fmt.Errorf("connect: server returned %w", err)
+	}
+	if !slices.ContainsFunc(c.LogicalChannels(), func(lc LogicalChannel) bool { return lc.Channel == channel }) {
+		c.rememberChannel(channel, aid)
+	}
+	return channel, nil
+}
+
+// requireConnectFeature checks, when needed, that the server offers feature
+// before connecting. The features of the server connected before may differ,
+// so they are asked for again.
+func (c *NetContext) requireConnectFeature(ctx context.Context, needed bool, feature string) error {
+	if !needed {
+		return nil
+	}
+	c.capsMu.Lock()
+	c.features = nil
+	c.capsMu.Unlock()
+	return c.requireFeature(ctx, feature)
+}
+
+// connect dials the server and runs the connect handshake with connect,
+// returning the server's answer.
+func (c *NetContext) connect(ctx context.Context, connect IPacketCmd) (IPacketCmd, error) {
+	c.stopKeepAlive()
+
+	dialCtx, cancel, _ := c.withCallTimeout(ctx)
+	conn, err := c.dial(dialCtx)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("error establishing connection with %s %w", c.rAddr, err)
+	}
+	if c.conn != nil {
+		c.conn.Close()
 	}
 	c.conn = conn
+	// the server may have changed, so the handshake goes with gzip
+	c.compression = 0
 
-	_, err = remoteCall(c, NewPacketConnect(c.device, c.proto, c.slot))
-	return err
+	// a token left over from a lost connection lets the server hand the
+	// session back instead of reporting the device busy
+	pcRcv, err := remoteCallPacket(ctx, c, connect)
+	if err == nil {
+		pcRcv, err = c.answerChallenge(ctx, connect, pcRcv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.sessionToken = pcRcv.GetSessionToken()
+
+	c.capsMu.Lock()
+	c.features = nil
+	c.capsMu.Unlock()
+
+	// servers predating the handshake answer with a bare PacketCmd
+	c.protocolVersion = ProtocolVersionLegacy
+	c.resumed = false
+	c.serverBufferSize = 0
+	if resp, ok := pcRcv.(IPacketConnectResp); ok {
+		c.protocolVersion, err = NegotiateVersion(CurrentProtocolVersion, resp.GetProtocolVersion())
+		c.resumed = resp.GetResumed()
+	}
+	if info, ok := pcRcv.(IPacketConnectInfo); ok {
+		c.serverBufferSize = info.GetBufferSize()
+		if !c.isStream() && c.bufferSize > c.serverBufferSize {
+			slog.Warn("bufferSize exceeds the server's, sending smaller datagrams", "bufferSize", c.bufferSize, "serverBufferSize", c.serverBufferSize, "server", c.rAddr)
+		}
+	}
+	if !c.resumed {
+		c.forgetChannels()
+	}
+	if err == nil {
+		c.negotiateCompression(ctx)
+	}
+	if err == nil && c.conf.KeepAliveInterval > 0 {
+		c.startKeepAlive(c.conf.KeepAliveInterval)
+	}
+	return pcRcv, err
+}
+
+// ProtocolVersion is the version agreed with the server during Connect.
+func (c *NetContext) ProtocolVersion() uint16 {
+	return c.protocolVersion
+}
+
+// NegotiatedBufferSize is the largest datagram the context sends: its own
+// buffer size, or the server's if that is smaller. The server reports its
+// size on connect since ProtocolVersion5; before that, and against older
+// servers, the context's own size is used. Streams do not fragment, so only
+// datagram transports are affected.
+func (c *NetContext) NegotiatedBufferSize() uint16 {
+	if c.serverBufferSize != 0 && c.serverBufferSize < c.bufferSize {
+		return c.serverBufferSize
+	}
+	return c.bufferSize
+}
+
+// readBufferSize is the size of the buffer a datagram is read into, large
+// enough for the datagrams of the server as well.
+func (c *NetContext) readBufferSize() uint16 {
+	return max(c.bufferSize, c.serverBufferSize)
+}
+
+// Resumed reports whether the last Connect took over a session this client
+// already held on the server. Logical channels opened in that session are
+// still open.
+func (c *NetContext) Resumed() bool {
+	return c.resumed
 }
 
 func (c *NetContext) Disconnect() error {
+	return c.DisconnectContext(context.Background())
+}
+
+func (c *NetContext) DisconnectContext(ctx context.Context) error {
+	c.stopKeepAlive()
+	c.stopEvents()
+
 	var err error
 	if c.conn != nil {
-		_, err = remoteCall(c, NewPacketCmd(CmdDisconnect))
+		_, err = remoteCall(ctx, c, NewPacketCmd(CmdDisconnect))
 		c.conn.Close()
 		c.conn = nil
+		c.sessionToken = ""
 	}
+	c.forgetChannels()
 	return err
 }
 
 func (c *NetContext) Transmit(command []byte) ([]byte, error) {
-	return remoteCall(c, NewPacketBody(CmdTransmit, command))
+	return c.TransmitContext(context.Background(), command)
+}
+
+func (c *NetContext) TransmitContext(ctx context.Context, command []byte) ([]byte, error) {
+	if err := CheckAPDUSize(command, c.maxAPDUSize()); err != nil {
+		return nil, err
+	}
+	return c.transmit(ctx, command, NewPacketBody(CmdTransmit, command))
+}
+
+// TransmitOn sends command like Transmit, but has the server check that
+// channel is open in this session and that the class byte of command
+// addresses it. Channel 0, the basic channel, is always open. A mismatch
+// fails with ErrInvalidChannel without reaching the card, and a server
+// without FeatureTransmitOn fails it with ErrNotSupported.
+func (c *NetContext) TransmitOn(channel byte, command []byte) ([]byte, error) {
+	return c.TransmitOnContext(context.Background(), channel, command)
+}
+
+func (c *NetContext) TransmitOnContext(ctx context.Context, channel byte, command []byte) ([]byte, error) {
+	if err := CheckAPDUSize(command, c.maxAPDUSize()); err != nil {
+		return nil, err
+	}
+	if err := CheckCLAChannel(channel, command); err != nil {
+		return nil, err
+	}
+	if err := c.requireFeature(ctx, FeatureTransmitOn); err != nil {
+		return nil, err
+	}
+	return c.transmit(ctx, command, NewPacketChannelBody(CmdTransmitOn, channel, command))
+}
+
+func (c *NetContext) transmit(ctx context.Context, command []byte, pcSnd IPacketCmd) ([]byte, error) {
+	c.traceCommand(command)
+	response, err := remoteCall(ctx, c, pcSnd)
+	if err != nil {
+		return nil, err
+	}
+	c.traceResponse(response)
+	if _, sw, err := SplitStatusWord(response); err == nil {
+		c.mu.Lock()
+		c.lastStatusWord = sw
+		c.mu.Unlock()
+	}
+	return response, nil
+}
+
+func (c *NetContext) maxAPDUSize() int {
+	if c.conf.MaxAPDUSize > 0 {
+		return c.conf.MaxAPDUSize
+	}
+	return DefaultMaxAPDUSize
 }
 
 func (c *NetContext) OpenLogicalChannel(AID []byte) (byte, error) {
-	bb, er := remoteCall(c, NewPacketBody(CmdOpenLogical, AID))
+	return c.OpenLogicalChannelContext(context.Background(), AID)
+}
+
+func (c *NetContext) OpenLogicalChannelContext(ctx context.Context, AID []byte) (byte, error) {
+	if err := CheckAID(AID); err != nil {
+		return InvalidChannel, err
+	}
+	bb, er := remoteCall(ctx, c, NewPacketBody(CmdOpenLogical, AID))
 	if er != nil {
 		return InvalidChannel, er
-	} else if bb == nil || len(bb) != 1 {
-		return InvalidChannel, errors.New("openlogicalchannel: empty channel received")
 	}
-	return bb[0], er
+	channel, err := parseChannel(bb)
+	if err != nil {
+		return InvalidChannel, err
+	}
+	c.rememberChannel(channel, AID)
+	return channel, nil
 }
 
 func (c *NetContext) CloseLogicalChannel(channel byte) error {
-	_, er := remoteCall(c, NewPacketBody(CmdCloseLogical, []byte{channel}))
+	return c.CloseLogicalChannelContext(context.Background(), channel)
+}
+
+func (c *NetContext) CloseLogicalChannelContext(ctx context.Context, channel byte) error {
+	if err := CheckChannel(channel); err != nil {
+		return err
+	}
+	_, er := remoteCall(ctx, c, NewPacketBody(CmdCloseLogical, []byte{channel}))
+	if er == nil {
+		c.forgetChannel(channel)
+	}
+	return er
+}
+
+// Reset asks the server to reset the card. Logical channels opened before
+// the reset are gone and must be reopened.
+func (c *NetContext) Reset() error {
+	return c.ResetContext(context.Background())
+}
+
+func (c *NetContext) ResetContext(ctx context.Context) error {
+	_, er := remoteCall(ctx, c, NewPacketCmd(CmdReset))
+	if er == nil {
+		c.forgetChannels()
+	}
 	return er
 }
 
-func remoteCall(nc *NetContext, pcSnd IPacketCmd) (by []byte, er error) {
+// SwitchSlot makes slot the active SIM slot of the session's modem without
+// ending the session. Logical channels opened before are gone, as after
+// Reset, and later Connects and Reconnects open slot. Only protocols with
+// slots can switch: the server refuses others with ErrCodeUnsupportedProto,
+// and servers without FeatureSwitchSlot fail the call with ErrNotSupported,
+// as do servers without FeatureWideSlots for a slot above 255.
+// A switch the modem fails ends the session.
+func (c *NetContext) SwitchSlot(slot uint16) error {
+	return c.SwitchSlotContext(context.Background(), slot)
+}
 
-	byteToTransmit, err1 := Encode(pcSnd)
-	if err1 != nil {
-		return nil, fmt.Errorf("error encoding message %s %w", pcSnd, err1)
+func (c *NetContext) SwitchSlotContext(ctx context.Context, slot uint16) error {
+	if err := c.requireFeature(ctx, FeatureSwitchSlot); err != nil {
+		return err
+	}
+	body := []byte{byte(slot)}
+	if slot > math.MaxUint8 {
+		if err := c.requireFeature(ctx, FeatureWideSlots); err != nil {
+			return err
+		}
+		body = binary.BigEndian.AppendUint16(nil, slot)
+	}
+	_, er := remoteCall(ctx, c, NewPacketBody(CmdSwitchSlot, body))
+	if er == nil {
+		c.slot = slot
+		c.forgetChannels()
 	}
+	return er
+}
 
-	_, err2 := nc.conn.Write(byteToTransmit)
-	if err2 != nil {
-		return nil, fmt.Errorf("error sending message %s %w", pcSnd, err2)
+// ListSlots asks the server which SIM slots device offers. It does not need
+// a session and may be called before Connect.
+func (c *NetContext) ListSlots(device string, proto string) ([]SlotInfo, error) {
+	return c.ListSlotsContext(context.Background(), device, proto)
+}
+
+func (c *NetContext) ListSlotsContext(ctx context.Context, device string, proto string) ([]SlotInfo, error) {
+	release, err := c.borrowConn(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 
-	buffer := make([]byte, nc.bufferSize)
-	n, _, err3 := nc.conn.ReadFromUDP(buffer)
-	if err3 != nil {
-		return nil, fmt.Errorf("error receiving response %X %w", buffer, err3)
+	bb, err := remoteCall(ctx, c, NewPacketListSlots(device, proto, c.conf.AuthToken))
+	if err != nil {
+		return nil, err
 	}
+	return DecodeSlotInfos(bb)
+}
 
-	pcRcv, err4 := Decode(buffer[:n])
-	if err4 != nil {
-		return nil, fmt.Errorf("error decoding response %X %w", buffer[:n], err4)
+// borrowConn dials for a request that needs no session when the context is
+// not connected; release closes that temporary connection again.
+func (c *NetContext) borrowConn(ctx context.Context) (release func(), err error) {
+	if c.conn != nil {
+		return func() {}, nil
 	}
 
-	if pcRcv.GetErr() != "" {
-		return nil, fmt.Errorf("error on server %s", pcRcv.GetErr())
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error establishing connection with %s %w", c.rAddr, err)
+	}
+	c.conn = conn
+	return func() {
+		c.conn.Close()
+		c.conn = nil
+	}, nil
+}
+
+func (c *NetContext) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	if c.network == "ws" {
+		return dialWebSocket(ctx, c.serverAddr)
+	}
+	if c.network == "pipe" {
+		return dialPipe(c.pipeCmd)
+	}
+	if c.isStream() {
+		return dialer.DialContext(ctx, c.network, c.rAddr.String())
+	}
+
+	rAddr := c.rAddr.(*net.UDPAddr)
+	if c.conf.DTLS == nil {
+		return c.dialUDP(rAddr)
+	}
+
+	conn, err := dialDTLS(ctx, rAddr, c.conf.DTLS, c.conf.ReadBuffer, c.conf.WriteBuffer, c.conf.DSCP)
+	if err != nil && c.conf.AllowPlaintext {
+		slog.Warn("dtls unavailable, falling back to plaintext", "server", c.rAddr, "error", err)
+		return c.dialUDP(rAddr)
+	}
+	return conn, err
+}
+
+func (c *NetContext) isStream() bool {
+	return c.network == "tcp" || c.network == "unix" || c.network == "ws" || c.network == "pipe"
+}
+
+func remoteCall(ctx context.Context, nc *NetContext, pcSnd IPacketCmd) (by []byte, er error) {
+	pcRcv, err := remoteCallPacket(ctx, nc, pcSnd)
+	if err != nil {
+		return nil, err
 	}
 
 	if ext, ok := pcRcv.(IPacketBody); ok {
@@ -113,3 +551,200 @@ func remoteCall(nc *NetContext, pcSnd IPacketCmd) (by []byte, er error) {
 	}
 	return nil, nil
 }
+
+// remoteCallPacket sends pcSnd and waits for the reply, retrying commands
+// that are safe to repeat when the reply does not arrive in time. Without
+// a context deadline NetConf.Timeout bounds it. An open breaker fails it at
+// once.
+func remoteCallPacket(ctx context.Context, nc *NetContext, pcSnd IPacketCmd) (IPacketCmd, error) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	parent := ctx
+	ctx, cancel, timeout := nc.withCallTimeout(ctx)
+	defer cancel()
+
+	if err := nc.breaker.allow(nc.conf); err != nil {
+		return nil, err
+	}
+	if err := nc.checkExpired(pcSnd); err != nil {
+		return nil, err
+	}
+
+	nc.lastRequestID++
+	pcSnd.SetRequestID(nc.lastRequestID)
+
+	var pcRcv IPacketCmd
+	var err error
+	if !nc.retryable(pcSnd) {
+		pcRcv, err = exchange(ctx, nc, pcSnd)
+	} else {
+		pcRcv, err = exchangeWithRetry(ctx, nc, pcSnd)
+	}
+	err = timeoutError(parent, pcSnd, timeout, err)
+	nc.countRequest(err)
+	nc.breaker.record(nc.conf, nc.serverAddr, err)
+	return pcRcv, nc.recordActivity(pcSnd, err)
+}
+
+// exchange performs a single request/response round trip. The context's
+// deadline bounds it and cancelling the context unblocks a pending read.
+func exchange(ctx context.Context, nc *NetContext, pcSnd IPacketCmd) (IPacketCmd, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		nc.conn.SetDeadline(time.Now())
+	})
+	defer func() {
+		stop()
+		nc.conn.SetDeadline(time.Time{})
+	}()
+
+	pcSnd.SetSessionToken(nc.sessionToken)
+	pcSnd.SetTimeout(advertisedTimeout(ctx))
+
+	if err1 := writePacket(nc, pcSnd); err1 != nil {
+		return nil, contextError(ctx, err1)
+	}
+
+	pcRcv, err2 := readReply(ctx, nc, pcSnd)
+	if err2 != nil {
+		return nil, contextError(ctx, err2)
+	}
+
+	if pcRcv.GetErr() != "" {
+		return nil, newServerError(pcRcv)
+	}
+	return pcRcv, nil
+}
+
+// advertisedTimeout is how long the server may spend on a command: a tenth
+// of the time left is kept back so that its timeout error still arrives
+// before the client gives up. Without a deadline the server's own
+// -commandTimeout applies.
+func advertisedTimeout(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	left := time.Until(deadline)
+	return max(left-left/10, time.Millisecond)
+}
+
+// readReply reads until the reply to pcSnd arrives. Servers echo the
+// request ID, so a reply carrying another one answers an earlier request
+// whose caller gave up, a late datagram or a response still queued on a
+// stream, and is dropped. Replies without an ID come from servers
+// predating the echo, or answer packets the server could not decode, and
+// are taken as they are.
+func readReply(ctx context.Context, nc *NetContext, pcSnd IPacketCmd) (IPacketCmd, error) {
+	for {
+		pcRcv, err := readPacket(ctx, nc)
+		if err != nil {
+			return nil, err
+		}
+		if id := pcRcv.GetRequestID(); id == 0 || id == pcSnd.GetRequestID() {
+			return pcRcv, nil
+		}
+		slog.Debug("discarding stale reply", "cmd", pcRcv.GetCmd(), "requestID", pcRcv.GetRequestID(), "expected", pcSnd.GetRequestID(), "server", nc.rAddr)
+	}
+}
+
+func writePacket(nc *NetContext, pcSnd IPacketCmd) error {
+	if nc.isStream() {
+		return writeStreamPacket(nc, pcSnd)
+	}
+
+	datagrams, err := EncodeFragments(pcSnd, int(nc.NegotiatedBufferSize()), nc.wire())
+	if err != nil {
+		return fmt.Errorf("error encoding message %s %w", pcSnd, err)
+	}
+
+	for _, datagram := range datagrams {
+		if _, err = nc.conn.Write(datagram); err != nil {
+			return fmt.Errorf("error sending message %s %w", pcSnd, err)
+		}
+		nc.countSent(len(datagram))
+	}
+	return nil
+}
+
+// contextError reports the context's error alongside the I/O error its
+// deadline or cancellation caused.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w %w", ctxErr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return fmt.Errorf("%w %w", context.DeadlineExceeded, err)
+	}
+	return err
+}
+
+func readPacket(ctx context.Context, nc *NetContext) (IPacketCmd, error) {
+	if nc.isStream() {
+		return readStreamPacket(nc)
+	}
+
+	var reassembler Reassembler
+
+	fragmentTimeout := nc.conf.FragmentTimeout
+	if fragmentTimeout == 0 {
+		fragmentTimeout = DefaultFragmentTimeout
+	}
+
+	buffer := make([]byte, nc.readBufferSize())
+	for {
+		n, err := nc.conn.Read(buffer)
+		if err != nil {
+			if reassembler.Pending() {
+				received, total := reassembler.Progress()
+				return nil, fmt.Errorf("%w: received %d of %d fragments %w", ErrIncompleteFragments, received, total, err)
+			}
+			return nil, fmt.Errorf("error receiving response %w", err)
+		}
+		nc.countReceived(n)
+
+		// a stray or corrupt datagram is no reason to give up on the reply,
+		// or on the fragments already in
+		pcRcv, err := Decode(buffer[:n])
+		if err != nil {
+			slog.Debug("discarding undecodable datagram", "len", n, "server", nc.rAddr, "error", err)
+			nc.countDiscarded()
+			continue
+		}
+
+		frag, ok := pcRcv.(IPacketFragment)
+		if !ok {
+			return pcRcv, nil
+		}
+
+		if !reassembler.Pending() {
+			deadline := time.Now().Add(fragmentTimeout)
+			if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+				deadline = ctxDeadline
+			}
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+			nc.conn.SetReadDeadline(deadline)
+		}
+
+		byteArray, complete, err := reassembler.Add(frag)
+		if err != nil {
+			return nil, err
+		}
+		if complete {
+			pcRcv, err = Decode(byteArray)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding reassembled response %w", err)
+			}
+			return pcRcv, nil
+		}
+	}
+}
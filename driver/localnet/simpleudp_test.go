@@ -0,0 +1,66 @@
+package localnet
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadSkipsUndecodableDatagrams(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// the server answers the status request after a stray datagram and
+	// between the fragments of its reply
+	go func() {
+		buffer := make([]byte, 64<<10)
+		n, client, err := server.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		pcRcv, err := Decode(buffer[:n])
+		if err != nil {
+			return
+		}
+		body, _ := json.Marshal(ServerStatus{RequestsServed: 3})
+		pcSnd := NewPacketBody(CmdResponse, body)
+		pcSnd.SetRequestID(pcRcv.GetRequestID())
+		data, err := Encode(pcSnd)
+		if err != nil {
+			return
+		}
+		half := len(data) / 2
+		stray := []byte("not a packet")
+		for _, datagram := range [][]byte{stray, encodeFragment(t, 0, data[:half]), stray, encodeFragment(t, 1, data[half:])} {
+			server.WriteToUDP(datagram, client)
+		}
+	}()
+
+	ch, err := NewUDPConf(server.LocalAddr().String(), "", "", 0, 0, NetConf{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := ch.(*NetContext)
+	status, err := nc.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.RequestsServed != 3 {
+		t.Fatalf("status %+v, want 3 requests served", status)
+	}
+	if discarded := nc.Stats().PacketsDiscarded; discarded != 2 {
+		t.Fatalf("%d datagrams discarded, want 2", discarded)
+	}
+}
+
+func encodeFragment(t *testing.T, index uint16, chunk []byte) []byte {
+	data, err := Encode(NewPacketFragment(index, 2, chunk))
+	if err != nil {
+		t.Error(err)
+	}
+	return data
+}
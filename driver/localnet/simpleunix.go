@@ -0,0 +1,25 @@
+package localnet
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/damonto/euicc-go/apdu"
+)
+
+func NewUnix(socketPath string, device string, proto string, slot uint16) (apdu.SmartCardChannel, error) {
+	return NewUnixConf(socketPath, device, proto, slot, NetConf{})
+}
+
+// NewUnixConf returns a channel talking to a server on the same host through
+// its -socket, using the same length-prefixed framing as TCP.
+func NewUnixConf(socketPath string, device string, proto string, slot uint16, conf NetConf) (apdu.SmartCardChannel, error) {
+	rAddr, err := net.ResolveUnixAddr("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving address: %s %w", socketPath, err)
+	}
+
+	warnSlot(proto, slot)
+	netctx := &NetContext{network: "unix", serverAddr: socketPath, rAddr: rAddr, device: device, proto: proto, slot: slot, bufferSize: 2048, conf: conf}
+	return netctx, nil
+}
@@ -0,0 +1,53 @@
+package localnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/damonto/euicc-go/apdu"
+	"golang.org/x/net/websocket"
+)
+
+func NewWebSocket(serverURL string, device string, proto string, slot uint16) (apdu.SmartCardChannel, error) {
+	return NewWebSocketConf(serverURL, device, proto, slot, NetConf{})
+}
+
+// NewWebSocketConf returns a channel talking to a server's -wsAddr endpoint,
+// such as "ws://host:8081/ws" or "wss://..." behind a TLS proxy. Each packet
+// travels as one binary message.
+func NewWebSocketConf(serverURL string, device string, proto string, slot uint16, conf NetConf) (apdu.SmartCardChannel, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+		return nil, fmt.Errorf("error resolving address: %s, expected a ws or wss url", serverURL)
+	}
+
+	warnSlot(proto, slot)
+	netctx := &NetContext{network: "ws", serverAddr: serverURL, rAddr: &websocket.Addr{URL: u}, device: device, proto: proto, slot: slot, bufferSize: 2048, conf: conf}
+	return netctx, nil
+}
+
+// dialWebSocket connects to serverURL. The handshake needs an origin, so the
+// client claims the server's own, which the server accepts as same-origin.
+func dialWebSocket(ctx context.Context, serverURL string) (net.Conn, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	origin := &url.URL{Scheme: "http", Host: u.Host}
+	if u.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+
+	config, err := websocket.NewConfig(serverURL, origin.String())
+	if err != nil {
+		return nil, err
+	}
+	ws, err := config.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ws.MaxPayloadBytes = MaxFrameSize
+	return ws, nil
+}
@@ -0,0 +1,76 @@
+package localnet
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+const (
+	slotFlagCardPresent byte = 0x01
+	slotFlagActive      byte = 0x02
+)
+
+// slotProtos are the protocols of the standard server drivers that open a
+// slot, numbered from 1; slotlessProtos are those that ignore it.
+var (
+	slotProtos     = []string{"qmi", "mbim", "qrtr"}
+	slotlessProtos = []string{"at", "pcsc", "mock"}
+)
+
+// warnSlot logs a slot the server will ignore or refuse for proto, which
+// otherwise only shows up at Connect or as the wrong card answering.
+func warnSlot(proto string, slot uint16) {
+	switch {
+	case slot != 0 && slices.Contains(slotlessProtos, proto):
+		slog.Warn("slot is ignored by this protocol", "proto", proto, "slot", slot)
+	case slot == 0 && slices.Contains(slotProtos, proto):
+		slog.Warn("protocol needs a slot numbered from 1, servers refuse 0", "proto", proto)
+	}
+}
+
+// SlotInfo describes one SIM slot reported by CmdListSlots. Slot numbers are
+// 1-based, as passed to NewUDP.
+type SlotInfo struct {
+	Slot        uint16
+	CardPresent bool
+	Active      bool
+}
+
+func (s SlotInfo) String() string {
+	return fmt.Sprintf("Slot: %d, CardPresent: %t, Active: %t", s.Slot, s.CardPresent, s.Active)
+}
+
+// EncodeSlotInfos packs slots into a CmdListSlots response body, two bytes
+// per slot: the slot number and a flags byte. The slots listed are physical
+// ones, whose numbers fit the byte.
+func EncodeSlotInfos(slots []SlotInfo) []byte {
+	body := make([]byte, 0, 2*len(slots))
+	for _, s := range slots {
+		var flags byte
+		if s.CardPresent {
+			flags |= slotFlagCardPresent
+		}
+		if s.Active {
+			flags |= slotFlagActive
+		}
+		body = append(body, byte(s.Slot), flags)
+	}
+	return body
+}
+
+func DecodeSlotInfos(body []byte) ([]SlotInfo, error) {
+	if len(body)%2 != 0 {
+		return nil, fmt.Errorf("listslots: malformed body of %d bytes", len(body))
+	}
+
+	slots := make([]SlotInfo, 0, len(body)/2)
+	for i := 0; i < len(body); i += 2 {
+		slots = append(slots, SlotInfo{
+			Slot:        uint16(body[i]),
+			CardPresent: body[i+1]&slotFlagCardPresent != 0,
+			Active:      body[i+1]&slotFlagActive != 0,
+		})
+	}
+	return slots, nil
+}
@@ -0,0 +1,37 @@
+package localnet
+
+import (
+	"fmt"
+	"net"
+)
+
+// dialUDP dials the server over plain UDP with the socket buffers and DSCP
+// NetConf asks for.
+func (c *NetContext) dialUDP(rAddr *net.UDPAddr) (net.Conn, error) {
+	conn, err := net.DialUDP("udp", nil, rAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err = setSocketBuffers(conn, c.conf.ReadBuffer, c.conf.WriteBuffer); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	markDSCP(conn, c.conf.DSCP)
+	return conn, nil
+}
+
+// setSocketBuffers sets the kernel buffers of conn, leaving the OS default
+// where a size is not positive.
+func setSocketBuffers(conn *net.UDPConn, read int, write int) error {
+	if read > 0 {
+		if err := conn.SetReadBuffer(read); err != nil {
+			return fmt.Errorf("error setting udp read buffer %w", err)
+		}
+	}
+	if write > 0 {
+		if err := conn.SetWriteBuffer(write); err != nil {
+			return fmt.Errorf("error setting udp write buffer %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package localnet
+
+import (
+	"context"
+	"errors"
+)
+
+// Stats counts the traffic of a NetContext since it was created, to judge
+// the link when only the client can be observed. It holds plain values, so
+// the Stats that NetContext.Stats returns is a snapshot later calls leave
+// alone.
+type Stats struct {
+	// Requests counts the commands sent to the server, Retries the times
+	// one was sent again because its reply did not arrive, and Timeouts the
+	// commands that got no reply in time after any retries.
+	Requests uint64
+	Retries  uint64
+	Timeouts uint64
+	// PacketsSent and PacketsReceived count datagrams, fragments included,
+	// or stream messages. BytesSent and BytesReceived are their encoded
+	// size, without IP, UDP or stream framing.
+	PacketsSent     uint64
+	PacketsReceived uint64
+	BytesSent       uint64
+	BytesReceived   uint64
+	// PacketsDiscarded counts the datagrams received that did not decode,
+	// skipped while the reply was awaited.
+	PacketsDiscarded uint64
+}
+
+// Stats returns the traffic counters of the context. Commands of Abort,
+// which uses a connection of its own, and event subscriptions are left out.
+func (c *NetContext) Stats() Stats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	return c.stats
+}
+
+func (c *NetContext) countSent(n int) {
+	c.statsMu.Lock()
+	c.stats.PacketsSent++
+	c.stats.BytesSent += uint64(n)
+	c.statsMu.Unlock()
+}
+
+func (c *NetContext) countReceived(n int) {
+	c.statsMu.Lock()
+	c.stats.PacketsReceived++
+	c.stats.BytesReceived += uint64(n)
+	c.statsMu.Unlock()
+}
+
+func (c *NetContext) countDiscarded() {
+	c.statsMu.Lock()
+	c.stats.PacketsDiscarded++
+	c.statsMu.Unlock()
+}
+
+func (c *NetContext) countRetry() {
+	c.statsMu.Lock()
+	c.stats.Retries++
+	c.statsMu.Unlock()
+}
+
+// countRequest records a command that went to the server and ended with
+// err.
+func (c *NetContext) countRequest(err error) {
+	c.statsMu.Lock()
+	c.stats.Requests++
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.stats.Timeouts++
+	}
+	c.statsMu.Unlock()
+}
@@ -0,0 +1,73 @@
+package localnet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ServerStatus is the CmdStatus response, carried as JSON in a PacketBody so
+// that non-Go tooling can read it too. Durations are in nanoseconds.
+type ServerStatus struct {
+	StartedAt      time.Time       `json:"startedAt"`
+	Uptime         time.Duration   `json:"uptime"`
+	RequestsServed uint64          `json:"requestsServed"`
+	Sessions       []SessionStatus `json:"sessions"`
+}
+
+// SessionStatus describes one session. LogicalChannels lists the open
+// channels, oldest first, and LogicalChannel repeats the newest for older
+// readers. Transmits, Opens and Closes count the APDUs, including batched
+// ones, and the channel opens and closes the session's client asked for,
+// whether the card accepted them or not.
+type SessionStatus struct {
+	Client          string        `json:"client"`
+	Device          string        `json:"device"`
+	Proto           string        `json:"proto"`
+	Slot            uint16        `json:"slot"`
+	ProtocolVersion uint16        `json:"protocolVersion"`
+	StartedAt       time.Time     `json:"startedAt"`
+	Idle            time.Duration `json:"idle"`
+	Timeout         time.Duration `json:"timeout"`
+	LogicalChannel  *byte         `json:"logicalChannel,omitempty"`
+	LogicalChannels []int         `json:"logicalChannels,omitempty"`
+	Transmits       uint64        `json:"transmits"`
+	Opens           uint64        `json:"opens"`
+	Closes          uint64        `json:"closes"`
+	LastError       *SessionError `json:"lastError,omitempty"`
+}
+
+// SessionError is the last command of a session the server answered with an
+// error, kept so the detail survives the retries that follow.
+type SessionError struct {
+	Cmd       Cmd       `json:"cmd"`
+	Error     string    `json:"error"`
+	RequestID uint64    `json:"requestID,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Status asks the server what it is doing. It does not need a session and
+// may be called before Connect.
+func (c *NetContext) Status() (*ServerStatus, error) {
+	return c.StatusContext(context.Background())
+}
+
+func (c *NetContext) StatusContext(ctx context.Context) (*ServerStatus, error) {
+	release, err := c.borrowConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	bb, err := remoteCall(ctx, c, NewPacketStatus(c.conf.AuthToken))
+	if err != nil {
+		return nil, err
+	}
+
+	status := new(ServerStatus)
+	if err = json.Unmarshal(bb, status); err != nil {
+		return nil, fmt.Errorf("status: error decoding response %w", err)
+	}
+	return status, nil
+}
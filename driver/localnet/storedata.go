@@ -0,0 +1,78 @@
+package localnet
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// StoreDataBlockSize is the data the server puts in each STORE DATA
+	// command, the most a short APDU carries.
+	StoreDataBlockSize = 255
+	// MaxStoreDataSize is the most one CmdStoreData sends: P2 numbers at
+	// most 256 blocks.
+	MaxStoreDataSize = 256 * StoreDataBlockSize
+)
+
+// StoreData sends data to the ISD-R as chained STORE DATA commands on the
+// logical channel opened last through this context, or on the basic channel
+// when none is open, and returns the response data of all blocks without
+// status words. A non-zero tag wraps data in a BER-TLV with that one-byte
+// tag; with tag 0 data is sent as given, as a bound profile package segment
+// already encoded is. The server splits it into blocks and sets the
+// chaining bits, in one round trip; a block answered with anything but
+// 9000, after any GET RESPONSE, fails the call. A server without
+// FeatureStoreData fails it with ErrNotSupported.
+func (c *NetContext) StoreData(tag byte, data []byte) ([]byte, error) {
+	return c.StoreDataContext(context.Background(), tag, data)
+}
+
+func (c *NetContext) StoreDataContext(ctx context.Context, tag byte, data []byte) ([]byte, error) {
+	var channel byte
+	if channels := c.LogicalChannels(); len(channels) > 0 {
+		channel = channels[len(channels)-1].Channel
+	}
+	return c.StoreDataOnContext(ctx, channel, tag, data)
+}
+
+// StoreDataOn is StoreData on the given channel, which the session must have
+// open unless it is the basic channel 0.
+func (c *NetContext) StoreDataOn(channel byte, tag byte, data []byte) ([]byte, error) {
+	return c.StoreDataOnContext(context.Background(), channel, tag, data)
+}
+
+func (c *NetContext) StoreDataOnContext(ctx context.Context, channel byte, tag byte, data []byte) ([]byte, error) {
+	if tag != 0 {
+		data = wrapTLV(tag, data)
+	}
+	if len(data) == 0 || len(data) > MaxStoreDataSize {
+		return nil, fmt.Errorf("invalid store data size: %d, allowed 1 to %d", len(data), MaxStoreDataSize)
+	}
+	if channel != 0 {
+		if err := CheckChannel(channel); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.requireFeature(ctx, FeatureStoreData); err != nil {
+		return nil, err
+	}
+	return remoteCall(ctx, c, NewPacketChannelBody(CmdStoreData, channel, data))
+}
+
+// wrapTLV encodes value under tag with a definite length, in the short form
+// below 0x80 and as 81 to 83 followed by the length bytes above.
+func wrapTLV(tag byte, value []byte) []byte {
+	n := len(value)
+	tlv := []byte{tag}
+	switch {
+	case n < 0x80:
+		tlv = append(tlv, byte(n))
+	case n <= 0xFF:
+		tlv = append(tlv, 0x81, byte(n))
+	case n <= 0xFFFF:
+		tlv = append(tlv, 0x82, byte(n>>8), byte(n))
+	default:
+		tlv = append(tlv, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(tlv, value...)
+}
@@ -0,0 +1,27 @@
+package localnet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrapTLV(t *testing.T) {
+	tests := []struct {
+		n      int
+		header []byte
+	}{
+		{0, []byte{0x5A, 0x00}},
+		{0x7F, []byte{0x5A, 0x7F}},
+		{0x80, []byte{0x5A, 0x81, 0x80}},
+		{0xFF, []byte{0x5A, 0x81, 0xFF}},
+		{0x100, []byte{0x5A, 0x82, 0x01, 0x00}},
+		{0x10000, []byte{0x5A, 0x83, 0x01, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		value := bytes.Repeat([]byte{0xAB}, tt.n)
+		got := wrapTLV(0x5A, value)
+		if !bytes.HasPrefix(got, tt.header) || !bytes.Equal(got[len(tt.header):], value) {
+			t.Errorf("length %d: header %X, want %X", tt.n, got[:min(len(got), len(tt.header))], tt.header)
+		}
+	}
+}
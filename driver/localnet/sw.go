@@ -0,0 +1,41 @@
+package localnet
+
+import "fmt"
+
+// Common status words. SWMoreData and SWWrongLe carry a length in their low
+// byte, so compare them against sw&0xFF00.
+const (
+	SWSuccess                uint16 = 0x9000
+	SWMoreData               uint16 = 0x6100
+	SWWrongLength            uint16 = 0x6700
+	SWSecurityNotSatisfied   uint16 = 0x6982
+	SWConditionsNotSatisfied uint16 = 0x6985
+	SWIncorrectData          uint16 = 0x6A80
+	SWFunctionNotSupported   uint16 = 0x6A81
+	SWFileNotFound           uint16 = 0x6A82
+	SWRecordNotFound         uint16 = 0x6A83
+	SWIncorrectP1P2          uint16 = 0x6A86
+	SWReferenceNotFound      uint16 = 0x6A88
+	SWWrongLe                uint16 = 0x6C00
+	SWINSNotSupported        uint16 = 0x6D00
+	SWCLANotSupported        uint16 = 0x6E00
+	SWUnknown                uint16 = 0x6F00
+)
+
+// SplitStatusWord separates the trailing status word from the data of an
+// APDU response.
+func SplitStatusWord(response []byte) (data []byte, sw uint16, err error) {
+	if len(response) < 2 {
+		return nil, 0, fmt.Errorf("response too short for a status word: %X", response)
+	}
+	n := len(response) - 2
+	return response[:n], uint16(response[n])<<8 | uint16(response[n+1]), nil
+}
+
+// LastStatusWord is the status word that ended the last successful
+// Transmit, 0 before the first one.
+func (c *NetContext) LastStatusWord() uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastStatusWord
+}
@@ -0,0 +1,48 @@
+package localnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultTimeout bounds a call made without a context deadline when
+// NetConf.Timeout is not set.
+const DefaultTimeout = 10 * time.Second
+
+// ErrTimeout is returned, together with context.DeadlineExceeded, when the
+// server did not answer within NetConf.Timeout.
+var ErrTimeout = errors.New("no reply from server")
+
+// callTimeout is how long a call without a deadline may take, 0 when
+// NetConf.Timeout is negative and calls wait indefinitely.
+func (c *NetContext) callTimeout() time.Duration {
+	switch {
+	case c.conf.Timeout < 0:
+		return 0
+	case c.conf.Timeout == 0:
+		return DefaultTimeout
+	}
+	return c.conf.Timeout
+}
+
+// withCallTimeout bounds ctx by the call timeout unless the caller set a
+// deadline of its own, which is then left alone whether longer or shorter.
+func (c *NetContext) withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc, time.Duration) {
+	timeout := c.callTimeout()
+	if _, ok := ctx.Deadline(); ok || timeout == 0 {
+		return ctx, func() {}, 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, timeout
+}
+
+// timeoutError adds ErrTimeout to err when the call timeout, rather than
+// the caller's own context, cut the call short.
+func timeoutError(parent context.Context, pcSnd IPacketCmd, timeout time.Duration, err error) error {
+	if timeout == 0 || parent.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %s after %s: %w", ErrTimeout, pcSnd.GetCmd(), timeout, err)
+}
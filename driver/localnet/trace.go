@@ -0,0 +1,72 @@
+package localnet
+
+// Direction tells a Tracer whether it sees a command or a response.
+type Direction uint8
+
+const (
+	DirCommand  Direction = iota // APDU sent to the card
+	DirResponse                  // data the card answered with
+)
+
+func (d Direction) String() string {
+	if d == DirResponse {
+		return "response"
+	}
+	return "command"
+}
+
+// Tracer observes the APDU traffic of a NetContext. For a response, apdu is
+// the data without the status word, which comes in sw; for a command sw is
+// 0. apdu must not be modified or kept after the call.
+type Tracer func(dir Direction, apdu []byte, sw uint16)
+
+// SetTracer has tracer called with every APDU sent through Transmit,
+// TransmitOn and TransmitBatch, and with the response to it; nil removes
+// it. A command the server answers with an error has no response traced,
+// and a batch is traced once its answer arrives, up to the APDU that failed.
+// The tracer runs on the caller's goroutine, so it should be quick and must
+// not use the NetContext.
+func (c *NetContext) SetTracer(tracer Tracer) {
+	if tracer == nil {
+		c.tracer.Store(nil)
+		return
+	}
+	c.tracer.Store(&tracer)
+}
+
+func (c *NetContext) traceCommand(command []byte) {
+	if tracer := c.tracer.Load(); tracer != nil {
+		(*tracer)(DirCommand, command, 0)
+	}
+}
+
+func (c *NetContext) traceResponse(response []byte) {
+	tracer := c.tracer.Load()
+	if tracer == nil {
+		return
+	}
+	data, sw, err := SplitStatusWord(response)
+	if err != nil {
+		data, sw = response, 0
+	}
+	(*tracer)(DirResponse, data, sw)
+}
+
+// traceBatch traces the APDUs of a batch the server ran, each followed by
+// its response, and the one that failed without a response.
+func (c *NetContext) traceBatch(apdus [][]byte, resp IPacketBatchResp) {
+	if c.tracer.Load() == nil {
+		return
+	}
+	responses := resp.GetResponses()
+	ran := len(responses)
+	if resp.GetFailedIndex() >= 0 {
+		ran++
+	}
+	for i, apdu := range apdus[:min(ran, len(apdus))] {
+		c.traceCommand(apdu)
+		if i < len(responses) {
+			c.traceResponse(responses[i])
+		}
+	}
+}
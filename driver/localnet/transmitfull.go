@@ -0,0 +1,111 @@
+package localnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// maxGetResponses bounds the GET RESPONSE chain of one TransmitFull, enough
+// for 64KB of response data.
+const maxGetResponses = 256
+
+// TransmitFull sends command and follows the status words asking for more:
+// 61xx fetches the remaining data with GET RESPONSE until the card is done,
+// and 6Cxx sends the command again with the Le the card asked for. It
+// returns the collected data without status word and the final status word.
+// Transmit leaves these to the caller.
+func (c *NetContext) TransmitFull(command []byte) ([]byte, uint16, error) {
+	return c.TransmitFullContext(context.Background(), command)
+}
+
+func (c *NetContext) TransmitFullContext(ctx context.Context, command []byte) ([]byte, uint16, error) {
+	data, sw, err := c.transmitSplit(ctx, command)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if sw&0xFF00 == SWWrongLe {
+		if command, err = withLe(command, byte(sw)); err != nil {
+			return nil, 0, err
+		}
+		if data, sw, err = c.transmitSplit(ctx, command); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	for i := 0; sw&0xFF00 == SWMoreData; i++ {
+		if i == maxGetResponses {
+			return nil, 0, fmt.Errorf("transmitfull: card still has data after %d GET RESPONSE", maxGetResponses)
+		}
+		getResponse := []byte{getResponseCLA(command[0]), 0xC0, 0x00, 0x00, byte(sw)}
+		more, moreSW, err := c.transmitSplit(ctx, getResponse)
+		if err != nil {
+			return nil, 0, err
+		}
+		data = append(data, more...)
+		sw = moreSW
+	}
+
+	return data, sw, nil
+}
+
+func (c *NetContext) transmitSplit(ctx context.Context, command []byte) ([]byte, uint16, error) {
+	response, err := c.TransmitContext(ctx, command)
+	if err != nil {
+		return nil, 0, err
+	}
+	return SplitStatusWord(response)
+}
+
+// getResponseCLA keeps the logical channel of cla in an interindustry class
+// byte, as GET RESPONSE must be sent on the channel of the command.
+func getResponseCLA(cla byte) byte {
+	if cla&0x40 == 0 {
+		return cla & 0x03
+	}
+	return 0x40 | cla&0x0F
+}
+
+// withLe returns command with its Le set to le, added when it had none.
+// Extended length commands get a two-byte Le.
+func withLe(command []byte, le byte) ([]byte, error) {
+	if len(command) < MinAPDUSize {
+		return nil, ErrAPDUTooShort
+	}
+	header, body := command[:4], command[4:]
+	out := append([]byte{}, header...)
+
+	switch {
+	case len(body) == 0:
+		// case 1: no data, no Le
+		return append(out, le), nil
+	case len(body) == 1:
+		// case 2 short: Le only
+		return append(out, le), nil
+	case body[0] != 0:
+		lc := int(body[0])
+		switch len(body) {
+		case 1 + lc:
+			// case 3 short
+			return append(append(out, body...), le), nil
+		case 2 + lc:
+			// case 4 short
+			return append(append(out, body[:1+lc]...), le), nil
+		}
+	case len(body) == 3:
+		// case 2 extended: 00 Le Le
+		return append(out, 0x00, 0x00, le), nil
+	default:
+		lc := int(body[1])<<8 | int(body[2])
+		switch len(body) {
+		case 3 + lc:
+			// case 3 extended
+			return append(append(out, body...), 0x00, le), nil
+		case 5 + lc:
+			// case 4 extended
+			return append(append(out, body[:3+lc]...), 0x00, le), nil
+		}
+	}
+	return nil, errors.New("transmitfull: malformed apdu, cannot set Le")
+}
@@ -0,0 +1,51 @@
+package localnet
+
+import "fmt"
+
+// Protocol versions exchanged in CmdConnect. Clients that predate the
+// handshake do not send a version and decode as ProtocolVersionLegacy.
+const (
+	ProtocolVersionLegacy uint16 = 0
+	ProtocolVersion1      uint16 = 1
+	// ProtocolVersion2 identifies sessions by a server-issued token.
+	ProtocolVersion2 uint16 = 2
+	// ProtocolVersion3 replays the cached response to a repeated RequestID
+	// instead of executing the command again.
+	ProtocolVersion3 uint16 = 3
+	// ProtocolVersion4 answers errors with a PacketErr carrying an ErrCode.
+	ProtocolVersion4 uint16 = 4
+	// ProtocolVersion5 reports the server's buffer size in a
+	// PacketConnectInfo.
+	ProtocolVersion5 uint16 = 5
+	// ProtocolVersion6 answers a PacketChallenge to its connect with a
+	// PacketConnectProof.
+	ProtocolVersion6 uint16 = 6
+
+	CurrentProtocolVersion = ProtocolVersion6
+)
+
+// minPeerVersion lists, for each version this package speaks, the oldest
+// peer version it still interoperates with.
+var minPeerVersion = map[uint16]uint16{
+	ProtocolVersionLegacy: ProtocolVersionLegacy,
+	ProtocolVersion1:      ProtocolVersionLegacy,
+	ProtocolVersion2:      ProtocolVersionLegacy,
+	ProtocolVersion3:      ProtocolVersionLegacy,
+	ProtocolVersion4:      ProtocolVersionLegacy,
+	ProtocolVersion5:      ProtocolVersionLegacy,
+	ProtocolVersion6:      ProtocolVersionLegacy,
+}
+
+// NegotiateVersion returns the version used between a local end speaking
+// local and a peer speaking remote: the lower of the two, provided the local
+// end still supports it.
+func NegotiateVersion(local uint16, remote uint16) (uint16, error) {
+	minPeer, ok := minPeerVersion[local]
+	if !ok {
+		return 0, fmt.Errorf("unknown local protocol version %d", local)
+	}
+	if remote < minPeer {
+		return 0, fmt.Errorf("protocol version %d no longer supported (minimum %d)", remote, minPeer)
+	}
+	return min(local, remote), nil
+}
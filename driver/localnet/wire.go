@@ -0,0 +1,78 @@
+package localnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// WireVersion tells legacy packets, a bare gzip stream, from packets wrapped
+// in the checksummed envelope.
+type WireVersion byte
+
+const (
+	WireLegacy WireVersion = 0x1f
+	WireV1     WireVersion = 0x01
+
+	CurrentWireVersion = WireV1
+)
+
+// Format bytes opening a WireV1 envelope: the low nibble is the compression,
+// the high nibble the codec ID. FormatRawZstd is an uncompressed packet from
+// a peer compressing with zstd, which wants zstd back. A legacy packet
+// starts with the gzip magic instead.
+const (
+	FormatGzip    byte = 0x01
+	FormatRaw     byte = 0x02
+	FormatZstd    byte = 0x03
+	FormatRawZstd byte = 0x04
+	formatLegacy  byte = 0x1f
+)
+
+// LegacyWire is understood by peers that predate the envelope.
+var LegacyWire = Wire{Version: WireLegacy, Codec: GobCodec{}}
+
+var ErrChecksumMismatch = errors.New("packet checksum mismatch")
+
+func seal(format byte, payload []byte) []byte {
+	byteArray := make([]byte, 0, 1+len(payload)+crc32.Size)
+	byteArray = append(byteArray, format)
+	byteArray = append(byteArray, payload...)
+	return binary.BigEndian.AppendUint32(byteArray, crc32.ChecksumIEEE(payload))
+}
+
+func unwrap(byteArray []byte) (payload []byte, compression byte, wire Wire, err error) {
+	if len(byteArray) == 0 {
+		return nil, 0, LegacyWire, errors.New("empty packet")
+	}
+
+	format := byteArray[0]
+	if format == formatLegacy {
+		return byteArray, FormatGzip, LegacyWire, nil
+	}
+
+	wire.Version = WireV1
+	compression = format &^ codecMask
+	switch compression {
+	case FormatGzip, FormatRaw:
+		wire.Compression = FormatGzip
+	case FormatZstd, FormatRawZstd:
+		wire.Compression = FormatZstd
+	default:
+		return nil, compression, LegacyWire, fmt.Errorf("unsupported wire format 0x%02X", format)
+	}
+	if wire.Codec, err = codecByID(format & codecMask); err != nil {
+		return nil, compression, LegacyWire, err
+	}
+
+	if len(byteArray) < 1+crc32.Size {
+		return nil, compression, wire, fmt.Errorf("packet too short: %d bytes", len(byteArray))
+	}
+	payload = byteArray[1 : len(byteArray)-crc32.Size]
+	sum := binary.BigEndian.Uint32(byteArray[len(byteArray)-crc32.Size:])
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, compression, wire, ErrChecksumMismatch
+	}
+	return payload, compression, wire, nil
+}
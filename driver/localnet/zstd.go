@@ -0,0 +1,99 @@
+package localnet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMaxWindow bounds the window a zstd frame may ask its decoder to
+// allocate. Packets are far smaller, so a frame claiming more is crafted.
+const zstdMaxWindow = 8 << 20
+
+// zstdEncoders holds an encoder per compression level. EncodeAll is safe
+// for concurrent use, so one encoder serves every caller at its level.
+var zstdEncoders [gzip.BestCompression + 1]struct {
+	once sync.Once
+	enc  *zstd.Encoder
+	err  error
+}
+
+var zstdDecoders sync.Pool
+
+type zstdCompressor struct{}
+
+// compress maps the gzip level onto the zstd speed presets: 0 to 2 are the
+// fastest, 3 to 5 the default and 6 to 9, DefaultCompressionLevel among
+// them, compress better.
+func (zstdCompressor) compress(buf *bytes.Buffer, raw []byte, level int) error {
+	enc, err := zstdEncoder(level)
+	if err != nil {
+		return fmt.Errorf("encode, writer error using zstd: %w", err)
+	}
+	buf.Write(enc.EncodeAll(raw, buf.AvailableBuffer()))
+	return nil
+}
+
+func (zstdCompressor) decompress(buf *bytes.Buffer, payload []byte, limit int) error {
+	dec, err := getZstdDecoder(bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("decode, reader error using zstd: %w", err)
+	}
+	defer zstdDecoders.Put(dec)
+
+	return readLimited(buf, dec, limit, "zstd")
+}
+
+func zstdEncoder(level int) (*zstd.Encoder, error) {
+	level = min(max(level, 0), gzip.BestCompression)
+	e := &zstdEncoders[level]
+	e.once.Do(func() {
+		// the envelope already checksums the payload
+		e.enc, e.err = zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+			zstd.WithEncoderCRC(false))
+	})
+	return e.enc, e.err
+}
+
+// getZstdDecoder returns a pooled decoder reading r. With a concurrency of
+// one it decodes synchronously and holds no goroutines, so a decoder
+// dropped from the pool needs no Close.
+func getZstdDecoder(r io.Reader) (*zstd.Decoder, error) {
+	if dec, ok := zstdDecoders.Get().(*zstd.Decoder); ok {
+		if err := dec.Reset(r); err != nil {
+			zstdDecoders.Put(dec)
+			return nil, err
+		}
+		return dec, nil
+	}
+	return zstd.NewReader(r,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(zstdMaxWindow))
+}
+
+// negotiateCompression switches the context to zstd after a connect when
+// NetConf.Zstd asks for it and the server offers FeatureZstd.
+func (c *NetContext) negotiateCompression(ctx context.Context) {
+	if !c.conf.Zstd {
+		return
+	}
+	if err := c.requireFeature(ctx, FeatureZstd); err != nil {
+		slog.Debug("server does not offer zstd, keeping gzip", "server", c.rAddr, "error", err)
+		return
+	}
+	c.compression = FormatZstd
+}
+
+// wire is the wire the context sends with.
+func (c *NetContext) wire() Wire {
+	w := DefaultWire()
+	w.Compression = c.compression
+	return w
+}
@@ -0,0 +1,267 @@
+// Package mock provides a scripted smart card, so the localnet protocol can
+// be exercised end to end without a modem.
+package mock
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/damonto/euicc-go/apdu"
+)
+
+func init() {
+	driver.RegisterDriver("mock", func(device string, slot uint16) (apdu.SmartCardChannel, error) {
+		return Load(device)
+	})
+}
+
+// maxChannels is how many logical channels the card opens besides the basic
+// channel 0.
+const maxChannels = 3
+
+// Card answers APDUs from a script. Rules are tried in the order they were
+// added and the first whose command matches answers; a command ending in
+// "*" matches every APDU starting with it. Unmatched APDUs get the fallback
+// response, 6D00 (instruction not supported) unless changed.
+type Card struct {
+	mu        sync.Mutex
+	rules     []rule
+	fallback  []byte
+	connected bool
+	channels  [maxChannels + 1]bool
+	delay     time.Duration
+	gone      bool
+
+	// cancel ends the delay of the APDU in flight, nil while none is.
+	cancelMu sync.Mutex
+	cancel   chan struct{}
+}
+
+type rule struct {
+	command  []byte
+	prefix   bool
+	response []byte
+	// unplug makes the APDU unplug the card instead of answering.
+	unplug bool
+}
+
+func New() *Card {
+	return &Card{fallback: []byte{0x6D, 0x00}}
+}
+
+// Load reads a script with one rule per line: the command and the response
+// in hex, separated by whitespace. "*" alone as the command sets the
+// fallback response and "delay" followed by a duration such as 5s slows
+// every response down. "unplug" followed by a command makes that APDU unplug
+// the card, as Unplug does. Blank lines and lines starting with # are
+// skipped.
+func Load(file string) (*Card, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening mock script %w", err)
+	}
+	defer f.Close()
+
+	card := New()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("mock script line %d: expected command and response", n)
+		}
+		if fields[0] == "delay" {
+			if card.delay, err = time.ParseDuration(fields[1]); err != nil || card.delay < 0 {
+				return nil, fmt.Errorf("mock script line %d: invalid delay", n)
+			}
+			continue
+		}
+		if fields[0] == "unplug" {
+			command, prefix := strings.CutSuffix(fields[1], "*")
+			decoded, err := hex.DecodeString(command)
+			if err != nil {
+				return nil, fmt.Errorf("mock script line %d: invalid command hex", n)
+			}
+			card.rules = append(card.rules, rule{command: decoded, prefix: prefix, unplug: true})
+			continue
+		}
+		response, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("mock script line %d: invalid response hex", n)
+		}
+
+		if fields[0] == "*" {
+			card.fallback = response
+			continue
+		}
+		command, prefix := strings.CutSuffix(fields[0], "*")
+		decoded, err := hex.DecodeString(command)
+		if err != nil {
+			return nil, fmt.Errorf("mock script line %d: invalid command hex", n)
+		}
+		card.rules = append(card.rules, rule{command: decoded, prefix: prefix, response: response})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading mock script %w", err)
+	}
+	return card, nil
+}
+
+// SetDelay makes every APDU take d before it is answered, to stand in for a
+// slow card.
+func (c *Card) SetDelay(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delay = d
+}
+
+// Unplug makes the card behave like a removed device: every later call
+// fails with driver.ErrDeviceGone.
+func (c *Card) Unplug() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gone = true
+}
+
+// Handle answers command with response.
+func (c *Card) Handle(command []byte, response []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = append(c.rules, rule{command: command, response: response})
+}
+
+// HandlePrefix answers every APDU starting with prefix with response.
+func (c *Card) HandlePrefix(prefix []byte, response []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = append(c.rules, rule{command: prefix, prefix: true, response: response})
+}
+
+// SetFallback sets the response to APDUs no rule matches.
+func (c *Card) SetFallback(response []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fallback = response
+}
+
+func (c *Card) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = true
+	return nil
+}
+
+func (c *Card) Disconnect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = false
+	c.channels = [maxChannels + 1]bool{}
+	return nil
+}
+
+func (c *Card) OpenLogicalChannel(AID []byte) (byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gone {
+		return 0, driver.ErrDeviceGone
+	}
+	if !c.connected {
+		return 0, errors.New("mock: not connected")
+	}
+	for channel := 1; channel <= maxChannels; channel++ {
+		if !c.channels[channel] {
+			c.channels[channel] = true
+			return byte(channel), nil
+		}
+	}
+	return 0, errors.New("mock: no free logical channel")
+}
+
+func (c *Card) CloseLogicalChannel(channel byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gone {
+		return driver.ErrDeviceGone
+	}
+	if channel == 0 || int(channel) > maxChannels || !c.channels[channel] {
+		return fmt.Errorf("mock: logical channel %d not open", channel)
+	}
+	c.channels[channel] = false
+	return nil
+}
+
+func (c *Card) Transmit(command []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gone {
+		return nil, driver.ErrDeviceGone
+	}
+	if !c.connected {
+		return nil, errors.New("mock: not connected")
+	}
+	if err := c.wait(); err != nil {
+		return nil, err
+	}
+	for _, r := range c.rules {
+		if bytes.Equal(command, r.command) || r.prefix && bytes.HasPrefix(command, r.command) {
+			if r.unplug {
+				c.gone = true
+				return nil, fmt.Errorf("mock: write: %w", driver.ErrDeviceGone)
+			}
+			return bytes.Clone(r.response), nil
+		}
+	}
+	return bytes.Clone(c.fallback), nil
+}
+
+// wait sleeps for the delay unless Interrupt ends it first; callers hold mu.
+func (c *Card) wait() error {
+	if c.delay <= 0 {
+		return nil
+	}
+
+	cancel := make(chan struct{})
+	c.cancelMu.Lock()
+	c.cancel = cancel
+	c.cancelMu.Unlock()
+	defer func() {
+		c.cancelMu.Lock()
+		c.cancel = nil
+		c.cancelMu.Unlock()
+	}()
+
+	timer := time.NewTimer(c.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-cancel:
+		return driver.ErrInterrupted
+	}
+}
+
+// Interrupt ends the delay of the APDU in flight, which then fails with
+// driver.ErrInterrupted.
+func (c *Card) Interrupt() {
+	c.cancelMu.Lock()
+	defer c.cancelMu.Unlock()
+	if c.cancel != nil {
+		close(c.cancel)
+		c.cancel = nil
+	}
+}
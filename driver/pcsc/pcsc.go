@@ -0,0 +1,199 @@
+// Package pcsc drives a card in a PC/SC smart-card reader, such as a USB
+// eUICC dongle, through the host's PC/SC service. The service library is
+// loaded when the first reader is opened, so hosts without one still run
+// the other drivers.
+package pcsc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// ErrNoService is returned when the PC/SC library is missing or its service,
+// pcscd on Linux, is not running.
+var ErrNoService = errors.New("pcsc: no PC/SC service available")
+
+// ErrNoReader is returned when the named reader does not exist, or no
+// reader is attached when none was named.
+var ErrNoReader = errors.New("pcsc: reader not found")
+
+// terminalCapability tells an eUICC that the terminal supports the local
+// profile assistant, which some cards require before they answer ES10
+// commands. Modems send it themselves, readers do not.
+var terminalCapability = []byte{0x80, 0xAA, 0x00, 0x00, 0x0A, 0xA9, 0x08, 0x81, 0x00, 0x82, 0x01, 0x01, 0x83, 0x01, 0x07}
+
+func init() {
+	driver.RegisterDriver("pcsc", func(device string, slot uint16) (apdu.SmartCardChannel, error) {
+		return New(device)
+	})
+	driver.RegisterCheck("pcsc", serviceAvailable)
+}
+
+// serviceAvailable fails with ErrNoService unless the PC/SC library loads
+// and its service answers; readers may still be missing.
+func serviceAvailable() error {
+	context, err := establishContext()
+	if err != nil {
+		return err
+	}
+	releaseContext(context)
+	return nil
+}
+
+// Reader is the card in a PC/SC reader. The card is held exclusively from
+// Connect to Disconnect.
+type Reader struct {
+	mu      sync.Mutex
+	name    string
+	context uintptr
+	card    uintptr
+	proto   uintptr
+}
+
+// New returns the card in the reader named reader, or in the first reader
+// attached when reader is empty.
+func New(reader string) (*Reader, error) {
+	context, err := establishContext()
+	if err != nil {
+		return nil, err
+	}
+	readers, err := listReaders(context)
+	if err != nil {
+		releaseContext(context)
+		return nil, err
+	}
+
+	switch {
+	case reader == "" && len(readers) > 0:
+		reader = readers[0]
+	case !containsReader(readers, reader):
+		releaseContext(context)
+		return nil, fmt.Errorf("%w: %q, attached: %q", ErrNoReader, reader, readers)
+	}
+	return &Reader{name: reader, context: context}, nil
+}
+
+func containsReader(readers []string, reader string) bool {
+	for _, r := range readers {
+		if r == reader {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the name of the reader holding the card.
+func (r *Reader) Name() string {
+	return r.name
+}
+
+func (r *Reader) Connect() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.context == 0 {
+		context, err := establishContext()
+		if err != nil {
+			return err
+		}
+		r.context = context
+	}
+	card, proto, err := connect(r.context, r.name)
+	if err != nil {
+		return err
+	}
+	r.card, r.proto = card, proto
+
+	// cards that do not know the command reject it, which does no harm
+	if _, err = transmit(r.card, r.proto, terminalCapability); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Disconnect leaves the card powered and releases the reader to others.
+func (r *Reader) Disconnect() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	if r.card != 0 {
+		err = disconnect(r.card)
+		r.card = 0
+	}
+	if r.context != 0 {
+		releaseContext(r.context)
+		r.context = 0
+	}
+	return err
+}
+
+func (r *Reader) Transmit(command []byte) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.card == 0 {
+		return nil, errors.New("pcsc: not connected")
+	}
+	return transmit(r.card, r.proto, command)
+}
+
+// OpenLogicalChannel opens a channel with MANAGE CHANNEL and selects AID on
+// it. The channel is closed again if the selection fails.
+func (r *Reader) OpenLogicalChannel(AID []byte) (byte, error) {
+	response, err := r.Transmit([]byte{0x00, 0x70, 0x00, 0x00, 0x01})
+	if err != nil {
+		return 0, err
+	}
+	channel, err := parseManageChannel(response)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err = r.Transmit(append([]byte{channelCLA(channel), 0xA4, 0x04, 0x00, byte(len(AID))}, AID...))
+	if err == nil && (len(response) < 2 || response[len(response)-2] != 0x90 && response[len(response)-2] != 0x61) {
+		err = fmt.Errorf("pcsc: SELECT failed: %X", response)
+	}
+	if err != nil {
+		r.CloseLogicalChannel(channel)
+		return 0, err
+	}
+	return channel, nil
+}
+
+// parseManageChannel reads the channel number from a MANAGE CHANNEL open
+// response: the channel byte followed by 9000. Channels beyond 19 do not
+// exist; a card claiming one is answered with an error, not trusted.
+func parseManageChannel(response []byte) (byte, error) {
+	if len(response) < 2 || response[len(response)-2] != 0x90 || response[len(response)-1] != 0x00 {
+		return 0, fmt.Errorf("pcsc: MANAGE CHANNEL open failed: %X", response)
+	}
+	if len(response) != 3 || response[0] < 1 || response[0] > 19 {
+		return 0, fmt.Errorf("pcsc: MANAGE CHANNEL open returned no valid channel: %X", response)
+	}
+	return response[0], nil
+}
+
+func (r *Reader) CloseLogicalChannel(channel byte) error {
+	response, err := r.Transmit([]byte{channelCLA(channel), 0x70, 0x80, channel, 0x00})
+	if err != nil {
+		return err
+	}
+	if len(response) != 2 || response[0] != 0x90 || response[1] != 0x00 {
+		return fmt.Errorf("pcsc: MANAGE CHANNEL close failed: %X", response)
+	}
+	return nil
+}
+
+// channelCLA is the interindustry class byte of channel: channels 0 to 3 in
+// the low bits, 4 to 19 in the further interindustry form.
+func channelCLA(channel byte) byte {
+	if channel < 4 {
+		return channel
+	}
+	return 0x40 | (channel-4)&0x0F
+}
@@ -0,0 +1,174 @@
+//go:build linux && (amd64 || arm64)
+
+package pcsc
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/ebitengine/purego"
+)
+
+// pcsc-lite constants; DWORD and LONG are C longs on Linux.
+const (
+	scardScopeSystem     = 2
+	scardShareExclusive  = 1
+	scardProtocolT0      = 1
+	scardProtocolT1      = 2
+	scardLeaveCard       = 0
+	scardMaxBufferSize   = 65538
+	scardSuccess         = 0
+	scardENoService      = 0x8010001D
+	scardENoReaders      = 0x8010002E
+	scardEServiceStopped = 0x8010001E
+)
+
+var libraryNames = []string{"libpcsclite.so.1", "libpcsclite.so"}
+
+var (
+	loadOnce sync.Once
+	loadErr  error
+
+	scardEstablishContext func(scope uintptr, reserved1, reserved2 unsafe.Pointer, context *uintptr) uintptr
+	scardReleaseContext   func(context uintptr) uintptr
+	scardListReaders      func(context uintptr, groups unsafe.Pointer, readers *byte, size *uintptr) uintptr
+	scardConnect          func(context uintptr, reader *byte, share, protocols uintptr, card *uintptr, active *uintptr) uintptr
+	scardDisconnect       func(card uintptr, disposition uintptr) uintptr
+	scardTransmit         func(card uintptr, sendPci *ioRequest, send *byte, sendLen uintptr, recvPci *ioRequest, recv *byte, recvLen *uintptr) uintptr
+)
+
+// ioRequest is SCARD_IO_REQUEST.
+type ioRequest struct {
+	protocol uintptr
+	length   uintptr
+}
+
+func load() error {
+	loadOnce.Do(func() {
+		var lib uintptr
+		for _, name := range libraryNames {
+			if lib, loadErr = purego.Dlopen(name, purego.RTLD_NOW|purego.RTLD_GLOBAL); loadErr == nil {
+				break
+			}
+		}
+		if loadErr != nil {
+			loadErr = fmt.Errorf("%w: cannot load libpcsclite: %v", ErrNoService, loadErr)
+			return
+		}
+		purego.RegisterLibFunc(&scardEstablishContext, lib, "SCardEstablishContext")
+		purego.RegisterLibFunc(&scardReleaseContext, lib, "SCardReleaseContext")
+		purego.RegisterLibFunc(&scardListReaders, lib, "SCardListReaders")
+		purego.RegisterLibFunc(&scardConnect, lib, "SCardConnect")
+		purego.RegisterLibFunc(&scardDisconnect, lib, "SCardDisconnect")
+		purego.RegisterLibFunc(&scardTransmit, lib, "SCardTransmit")
+	})
+	return loadErr
+}
+
+func establishContext() (uintptr, error) {
+	if err := load(); err != nil {
+		return 0, err
+	}
+	var context uintptr
+	if err := scardError("SCardEstablishContext", scardEstablishContext(scardScopeSystem, nil, nil, &context)); err != nil {
+		return 0, err
+	}
+	return context, nil
+}
+
+func releaseContext(context uintptr) {
+	scardReleaseContext(context)
+}
+
+func listReaders(context uintptr) ([]string, error) {
+	var size uintptr
+	rv := scardListReaders(context, nil, nil, &size)
+	if uint32(rv) == scardENoReaders {
+		return nil, nil
+	}
+	if err := scardError("SCardListReaders", rv); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if err := scardError("SCardListReaders", scardListReaders(context, nil, &buf[0], &size)); err != nil {
+		return nil, err
+	}
+
+	// a multi-string: names separated by NUL, ended by an empty one
+	var readers []string
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 {
+			readers = append(readers, string(name))
+		}
+	}
+	return readers, nil
+}
+
+func connect(context uintptr, reader string) (card uintptr, proto uintptr, err error) {
+	name := append([]byte(reader), 0)
+	err = scardError("SCardConnect", scardConnect(context, &name[0], scardShareExclusive, scardProtocolT0|scardProtocolT1, &card, &proto))
+	return card, proto, err
+}
+
+func disconnect(card uintptr) error {
+	return scardError("SCardDisconnect", scardDisconnect(card, scardLeaveCard))
+}
+
+func transmit(card uintptr, proto uintptr, command []byte) ([]byte, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("pcsc: empty command")
+	}
+	pci := ioRequest{protocol: proto, length: unsafe.Sizeof(ioRequest{})}
+	response := make([]byte, scardMaxBufferSize)
+	size := uintptr(len(response))
+	if err := scardError("SCardTransmit", scardTransmit(card, &pci, &command[0], uintptr(len(command)), nil, &response[0], &size)); err != nil {
+		return nil, err
+	}
+	return response[:size], nil
+}
+
+// scardError turns a pcsc-lite return code into an error, nil on success.
+func scardError(call string, rv uintptr) error {
+	code := uint32(rv)
+	switch code {
+	case scardSuccess:
+		return nil
+	case scardENoService, scardEServiceStopped:
+		return fmt.Errorf("%w: %s: is pcscd running?", ErrNoService, call)
+	default:
+		if text, ok := scardErrors[code]; ok {
+			if scardGone[code] {
+				return fmt.Errorf("%w: pcsc: %s failed: %s", driver.ErrDeviceGone, call, text)
+			}
+			return fmt.Errorf("pcsc: %s failed: %s", call, text)
+		}
+		return fmt.Errorf("pcsc: %s failed: 0x%08X", call, code)
+	}
+}
+
+// scardErrors describes the codes a card or reader commonly fails with.
+var scardErrors = map[uint32]string{
+	0x80100008: "insufficient buffer",
+	0x80100009: "unknown reader",
+	0x8010000A: "timeout",
+	0x8010000B: "sharing violation, the card is in use",
+	0x8010000C: "no smart card in the reader",
+	0x8010000F: "protocol mismatch",
+	0x80100017: "reader unavailable",
+	0x80100066: "card unresponsive",
+	0x80100067: "card unpowered",
+	0x80100068: "card reset",
+	0x80100069: "card removed",
+}
+
+// scardGone marks the codes for a card or reader that went away, which no
+// retry on the same handle fixes.
+var scardGone = map[uint32]bool{
+	0x80100009: true,
+	0x8010000C: true,
+	0x80100017: true,
+	0x80100069: true,
+}
@@ -0,0 +1,29 @@
+//go:build !(linux && (amd64 || arm64))
+
+package pcsc
+
+import "fmt"
+
+// The PC/SC bindings cover Linux on amd64 and arm64 only.
+
+func establishContext() (uintptr, error) {
+	return 0, fmt.Errorf("%w: not supported on this platform", ErrNoService)
+}
+
+func releaseContext(context uintptr) {}
+
+func listReaders(context uintptr) ([]string, error) {
+	return nil, ErrNoService
+}
+
+func connect(context uintptr, reader string) (uintptr, uintptr, error) {
+	return 0, 0, ErrNoService
+}
+
+func disconnect(card uintptr) error {
+	return ErrNoService
+}
+
+func transmit(card uintptr, proto uintptr, command []byte) ([]byte, error) {
+	return nil, ErrNoService
+}
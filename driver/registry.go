@@ -0,0 +1,132 @@
+// Package driver keeps the card drivers the server can open, by protocol
+// name. Drivers register a factory in their init, so the server opens any
+// driver linked into it without knowing about it.
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// ErrUnsupportedProtocol is returned by Open for a protocol no driver is
+// registered as.
+var ErrUnsupportedProtocol = errors.New("unsupported protocol")
+
+// ErrSlotOutOfRange is returned by ByteSlot for a slot its driver cannot
+// address.
+var ErrSlotOutOfRange = errors.New("slot out of range")
+
+// Factory opens the card at device, or in slot for modems with several.
+type Factory func(device string, slot uint16) (apdu.SmartCardChannel, error)
+
+// Check reports what a driver needs and the host lacks, such as a missing
+// library or no device node of its kind, and nil when nothing is known to
+// be missing.
+type Check func() error
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Factory)
+	// slotDrivers are the drivers registered with RegisterSlotDriver.
+	slotDrivers = make(map[string]bool)
+	checks      = make(map[string]Check)
+)
+
+// RegisterDriver makes a driver available under name, the protocol clients
+// ask for on connect. It panics if name is taken, like a duplicate packet
+// tag, since that is always a programming error.
+func RegisterDriver(name string, factory Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if name == "" || factory == nil {
+		panic("driver: RegisterDriver needs a name and a factory")
+	}
+	if _, dup := drivers[name]; dup {
+		panic(fmt.Sprintf("driver: RegisterDriver called twice for %s", name))
+	}
+	drivers[name] = factory
+}
+
+// RegisterSlotDriver is RegisterDriver for modems with several SIM slots,
+// numbered from 1, whose factory opens the slot it is given. Drivers
+// registered with RegisterDriver ignore the slot.
+func RegisterSlotDriver(name string, factory Factory) {
+	RegisterDriver(name, factory)
+
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	slotDrivers[name] = true
+}
+
+// RegisterCheck attaches check to the driver registered as name, for
+// Available to run. It panics if no such driver is registered.
+func RegisterCheck(name string, check Check) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if _, ok := drivers[name]; !ok || check == nil {
+		panic(fmt.Sprintf("driver: RegisterCheck needs a registered driver and a check, got %s", name))
+	}
+	checks[name] = check
+}
+
+// Available runs the check of the driver registered as name. Drivers
+// without one are taken to work; passing does not mean a given device
+// will open.
+func Available(name string) error {
+	driversMu.RLock()
+	_, ok := drivers[name]
+	check := checks[name]
+	driversMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedProtocol, name)
+	}
+	if check == nil {
+		return nil
+	}
+	return check()
+}
+
+// UsesSlot reports whether the driver registered as name opens a slot.
+func UsesSlot(name string) bool {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	return slotDrivers[name]
+}
+
+// Drivers returns the registered protocol names, sorted.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Open opens device with the driver registered as name.
+func Open(name string, device string, slot uint16) (apdu.SmartCardChannel, error) {
+	driversMu.RLock()
+	factory, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s, available: %s", ErrUnsupportedProtocol, name, strings.Join(Drivers(), ", "))
+	}
+	return factory(device, slot)
+}
+
+// ByteSlot converts slot for a driver that numbers its slots with a byte,
+// as the upstream modem drivers do.
+func ByteSlot(slot uint16) (uint8, error) {
+	if slot > math.MaxUint8 {
+		return 0, fmt.Errorf("%w: %d, the driver takes at most %d", ErrSlotOutOfRange, slot, math.MaxUint8)
+	}
+	return uint8(slot), nil
+}
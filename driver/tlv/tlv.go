@@ -0,0 +1,181 @@
+// Package tlv parses the BER-TLV encoding eUICC responses use, as in
+// SGP.22: tags of up to four bytes and definite lengths of up to four
+// length bytes. Constructed values are parsed into their children.
+package tlv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Tag is a BER tag with its bytes big-endian in the low end, as written in
+// the specifications: 0x5A, 0xBF3E, 0x9F70.
+type Tag uint32
+
+func (t Tag) String() string {
+	return fmt.Sprintf("%X", uint32(t))
+}
+
+// constructed reports whether the value of t holds further TLVs, bit 6 of
+// its first byte.
+func (t Tag) constructed() bool {
+	first := uint32(t)
+	for first > 0xFF {
+		first >>= 8
+	}
+	return first&0x20 != 0
+}
+
+var (
+	ErrTruncated        = errors.New("tlv: truncated")
+	ErrIndefiniteLength = errors.New("tlv: indefinite length not supported")
+	ErrTagTooLong       = errors.New("tlv: tag longer than 4 bytes")
+	ErrLengthTooLong    = errors.New("tlv: length longer than 4 bytes")
+)
+
+// TLV is one data object. Value holds the raw value bytes, shared with the
+// parsed input; Children holds the objects inside a constructed value.
+type TLV struct {
+	Tag      Tag
+	Value    []byte
+	Children []TLV
+}
+
+// Constructed reports whether the object holds further objects.
+func (t *TLV) Constructed() bool {
+	return t.Tag.constructed()
+}
+
+// Find returns the first object with tag among the descendants of t, depth
+// first, or nil.
+func (t *TLV) Find(tag Tag) *TLV {
+	return Find(t.Children, tag)
+}
+
+// FindAll returns every object with tag among the descendants of t, depth
+// first.
+func (t *TLV) FindAll(tag Tag) []*TLV {
+	return FindAll(t.Children, tag)
+}
+
+// Parse decodes a sequence of data objects filling all of data.
+func Parse(data []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(data) > 0 {
+		t, rest, err := parseOne(data)
+		if err != nil {
+			return nil, err
+		}
+		tlvs = append(tlvs, t)
+		data = rest
+	}
+	return tlvs, nil
+}
+
+func parseOne(data []byte) (TLV, []byte, error) {
+	tag, n, err := parseTag(data)
+	if err != nil {
+		return TLV{}, nil, err
+	}
+	data = data[n:]
+
+	length, n, err := parseLength(data)
+	if err != nil {
+		return TLV{}, nil, fmt.Errorf("tag %s: %w", tag, err)
+	}
+	data = data[n:]
+	if length > len(data) {
+		return TLV{}, nil, fmt.Errorf("tag %s: value of %d bytes, %d left: %w", tag, length, len(data), ErrTruncated)
+	}
+
+	t := TLV{Tag: tag, Value: data[:length:length]}
+	if tag.constructed() {
+		if t.Children, err = Parse(t.Value); err != nil {
+			return TLV{}, nil, fmt.Errorf("in %s: %w", tag, err)
+		}
+	}
+	return t, data[length:], nil
+}
+
+// parseTag reads a tag and returns it with the number of bytes it took. A
+// first byte with the low five bits set continues in the following bytes
+// for as long as their top bit is set.
+func parseTag(data []byte) (Tag, int, error) {
+	if len(data) == 0 {
+		return 0, 0, ErrTruncated
+	}
+	tag := uint32(data[0])
+	if data[0]&0x1F != 0x1F {
+		return Tag(tag), 1, nil
+	}
+	for n := 1; ; n++ {
+		if n == len(data) {
+			return 0, 0, ErrTruncated
+		}
+		if n == 4 {
+			return 0, 0, ErrTagTooLong
+		}
+		tag = tag<<8 | uint32(data[n])
+		if data[n]&0x80 == 0 {
+			return Tag(tag), n + 1, nil
+		}
+	}
+}
+
+// parseLength reads a definite length, short form below 0x80 or 81 to 84
+// followed by that many bytes, and returns it with the bytes it took.
+func parseLength(data []byte) (int, int, error) {
+	if len(data) == 0 {
+		return 0, 0, ErrTruncated
+	}
+	first := data[0]
+	switch {
+	case first < 0x80:
+		return int(first), 1, nil
+	case first == 0x80:
+		return 0, 0, ErrIndefiniteLength
+	case first > 0x84:
+		return 0, 0, ErrLengthTooLong
+	}
+
+	n := int(first & 0x7F)
+	if len(data) < 1+n {
+		return 0, 0, ErrTruncated
+	}
+	var length uint32
+	for _, b := range data[1 : 1+n] {
+		length = length<<8 | uint32(b)
+	}
+	if uint64(length) > uint64(^uint(0)>>1) {
+		return 0, 0, ErrLengthTooLong
+	}
+	return int(length), 1 + n, nil
+}
+
+// Find returns the first object with tag in tlvs or their descendants,
+// depth first, or nil.
+func Find(tlvs []TLV, tag Tag) *TLV {
+	for i := range tlvs {
+		if tlvs[i].Tag == tag {
+			return &tlvs[i]
+		}
+		if found := Find(tlvs[i].Children, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// FindAll returns every object with tag in tlvs or their descendants, depth
+// first. The search does not descend into a match.
+func FindAll(tlvs []TLV, tag Tag) []*TLV {
+	var found []*TLV
+	for i := range tlvs {
+		if tlvs[i].Tag == tag {
+			found = append(found, &tlvs[i])
+			continue
+		}
+		found = append(found, FindAll(tlvs[i].Children, tag)...)
+	}
+	return found
+}
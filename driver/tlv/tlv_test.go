@@ -0,0 +1,164 @@
+package tlv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// getEIDResponse is the answer to GetEuiccDataRequest for the EID, status
+// word removed.
+const getEIDResponse = "BF3E 12 5A 10 89049032123451234512345678901235"
+
+// profilesInfoResponse is a ProfileInfoListResponse with an enabled and a
+// disabled profile; the list and the response take long-form lengths.
+const profilesInfoResponse = "BF2D 81 85 A0 81 82" +
+	" E3 3F" +
+	" 5A 0A 989400012345678901F0" +
+	" 4F 10 A0000005591010FFFFFFFF8900001000" +
+	" 9F70 01 01" +
+	" 90 04 576F726B" +
+	" 91 07 43617272696572" +
+	" 92 09 50726F66696C652041" +
+	" 95 01 02" +
+	" E3 3F" +
+	" 5A 0A 989400012345678902F0" +
+	" 4F 10 A0000005591010FFFFFFFF8900001100" +
+	" 9F70 01 00" +
+	" 90 04 486F6D65" +
+	" 91 07 43617272696572" +
+	" 92 09 50726F66696C652042" +
+	" 95 01 02"
+
+func TestParseGetEID(t *testing.T) {
+	tlvs, err := Parse(mustHex(t, getEIDResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tlvs) != 1 || tlvs[0].Tag != 0xBF3E || !tlvs[0].Constructed() {
+		t.Fatalf("parsed %+v, want one constructed BF3E", tlvs)
+	}
+	eid := tlvs[0].Find(0x5A)
+	if eid == nil {
+		t.Fatal("EID not found")
+	}
+	if want := mustHex(t, "89049032123451234512345678901235"); !bytes.Equal(eid.Value, want) {
+		t.Fatalf("EID %X, want %X", eid.Value, want)
+	}
+}
+
+func TestParseProfilesInfo(t *testing.T) {
+	tlvs, err := Parse(mustHex(t, profilesInfoResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := Find(tlvs, 0xA0)
+	if list == nil || len(list.Value) != 0x82 {
+		t.Fatalf("profile list %+v, want 130 bytes", list)
+	}
+
+	profiles := list.FindAll(0xE3)
+	if len(profiles) != 2 {
+		t.Fatalf("%d profiles, want 2", len(profiles))
+	}
+	for i, want := range []struct {
+		iccid string
+		state byte
+		name  string
+	}{
+		{"989400012345678901F0", 1, "Profile A"},
+		{"989400012345678902F0", 0, "Profile B"},
+	} {
+		p := profiles[i]
+		if iccid := p.Find(0x5A); iccid == nil || hex.EncodeToString(iccid.Value) != strings.ToLower(want.iccid) {
+			t.Errorf("profile %d ICCID %+v, want %s", i, iccid, want.iccid)
+		}
+		// 9F70 is a two-byte tag
+		if state := p.Find(0x9F70); state == nil || !bytes.Equal(state.Value, []byte{want.state}) {
+			t.Errorf("profile %d state %+v, want %d", i, state, want.state)
+		}
+		if name := p.Find(0x92); name == nil || string(name.Value) != want.name {
+			t.Errorf("profile %d name %+v, want %q", i, name, want.name)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		input string
+		tag   Tag
+	}{
+		{"5A 00", 0x5A},
+		{"9F70 00", 0x9F70},
+		{"BF2D 00", 0xBF2D},
+		{"BF8101 00", 0xBF8101},
+		{"DF818201 00", 0xDF818201},
+	}
+	for _, tt := range tests {
+		tlvs, err := Parse(mustHex(t, tt.input))
+		if err != nil {
+			t.Errorf("%s: %v", tt.input, err)
+			continue
+		}
+		if tlvs[0].Tag != tt.tag {
+			t.Errorf("%s: tag %s, want %s", tt.input, tlvs[0].Tag, tt.tag)
+		}
+	}
+}
+
+func TestParseLongFormLengths(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		length int
+	}{
+		{"04 81 80", 0x80},
+		{"04 82 0100", 0x100},
+		{"04 83 000102", 0x102},
+		{"04 84 00000103", 0x103},
+	} {
+		value := bytes.Repeat([]byte{0xAB}, tt.length)
+		tlvs, err := Parse(append(mustHex(t, tt.header), value...))
+		if err != nil {
+			t.Errorf("%s: %v", tt.header, err)
+			continue
+		}
+		if !bytes.Equal(tlvs[0].Value, value) {
+			t.Errorf("%s: value of %d bytes, want %d", tt.header, len(tlvs[0].Value), tt.length)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   error
+	}{
+		{"tag cut after first byte", "BF", ErrTruncated},
+		{"tag cut mid continuation", "BF81", ErrTruncated},
+		{"missing length", "5A", ErrTruncated},
+		{"long length cut", "5A 82 01", ErrTruncated},
+		{"value cut", "5A 05 0102", ErrTruncated},
+		{"EID cut", getEIDResponse[:len(getEIDResponse)-2], ErrTruncated},
+		{"child overruns parent", "BF3E 03 5A 05 01", ErrTruncated},
+		{"tag too long", "BF81818101 00", ErrTagTooLong},
+		{"length too long", "5A 85 0000000001 00", ErrLengthTooLong},
+		{"indefinite length", "BF3E 80 5A0100 0000", ErrIndefiniteLength},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(mustHex(t, tt.input)); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
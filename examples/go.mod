@@ -8,3 +8,13 @@ require (
 	github.com/avwarez/euicc-go v0.0.0
 	github.com/damonto/euicc-go v1.1.0
 )
+
+require (
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/pion/dtls/v3 v3.0.11 // indirect
+	github.com/pion/logging v0.2.4 // indirect
+	github.com/pion/transport/v4 v4.0.1 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/net v0.43.0 // indirect
+	golang.org/x/sys v0.39.0 // indirect
+)
@@ -0,0 +1,46 @@
+// Command healthcheck probes a server with CmdHealth for monitoring scripts
+// and load balancers: it prints the answer and exits 0 when the server is
+// up and, if -device is given, the device is free, and 1 otherwise.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+func main() {
+	server := flag.String("server", "127.0.0.1:8080", "Server address")
+	device := flag.String("device", "", "Device path on the server to check, empty for none")
+	proto := flag.String("proto", "qmi", "Driver protocol of -device")
+	slot := flag.Uint("slot", 1, "SIM slot of -device, 0 for drivers without slots")
+	timeout := flag.Duration("timeout", 2*time.Second, "Time to wait for the answer")
+	flag.Parse()
+
+	if *device == "" {
+		*proto = ""
+	}
+	ch, err := localnet.NewUDP(*server, *device, *proto, uint16(*slot), 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create client: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	health, err := ch.(*localnet.NetContext).HealthContext(ctx)
+	if err != nil {
+		fmt.Printf("unhealthy: %v\n", err)
+		os.Exit(1)
+	}
+	if health.DeviceAvailable != nil && !*health.DeviceAvailable {
+		fmt.Printf("%s, device %s busy or not allowed\n", health.Status, health.Device)
+		os.Exit(1)
+	}
+	fmt.Println(health.Status)
+}
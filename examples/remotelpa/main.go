@@ -0,0 +1,44 @@
+// Command remotelpa reads the EID and profiles of a remote eUICC through
+// localnet.NewRemoteLPA. Against a server allowing the mock driver, point
+// -device at a script such as the one in the README to try it without a
+// modem.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+func main() {
+	server := flag.String("server", "127.0.0.1:8080", "Server address")
+	device := flag.String("device", "/dev/cdc-wdm0", "Device path on the server")
+	proto := flag.String("proto", "qmi", "Driver protocol")
+	slot := flag.Uint("slot", 1, "SIM slot")
+	flag.Parse()
+
+	client, err := localnet.NewRemoteLPA(*server, *device, *proto, uint16(*slot))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create LPA client: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	eid, err := client.EID()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read EID: %v\n", err)
+		return
+	}
+	fmt.Printf("EID: %X\n", eid)
+
+	profiles, err := client.ListProfile(nil, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list profiles: %v\n", err)
+		return
+	}
+	for _, profile := range profiles {
+		fmt.Printf("Profile: %s, ICCID: %s\n", profile.ProfileName, profile.ICCID)
+	}
+}
@@ -2,6 +2,27 @@ module github.com/avwarez/euicc-go
 
 go 1.24.0
 
-require golang.org/x/sys v0.39.0 // indirect
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/pion/logging v0.2.4 // indirect
+	github.com/pion/transport/v4 v4.0.1 // indirect
+	github.com/prometheus/client_model v0.6.2 // indirect
+	github.com/prometheus/common v0.66.1 // indirect
+	github.com/prometheus/procfs v0.16.1 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/sys v0.39.0 // indirect
+	google.golang.org/protobuf v1.36.8 // indirect
+)
 
-require github.com/damonto/euicc-go v1.1.0
+require (
+	github.com/damonto/euicc-go v1.1.0
+	github.com/ebitengine/purego v0.9.0
+	github.com/klauspost/compress v1.18.0
+	github.com/pion/dtls/v3 v3.0.11
+	github.com/prometheus/client_golang v1.23.2
+	golang.org/x/net v0.43.0
+	gopkg.in/yaml.v3 v3.0.1
+)
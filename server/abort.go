@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// errCommandAborted answers a command the client aborted with CmdAbort.
+var errCommandAborted = errors.New("command aborted")
+
+// startCommand lets CmdAbort interrupt the card I/O about to start and
+// returns the channel closed when it does; callers hold the device lock.
+func (s *Session) startCommand() <-chan struct{} {
+	aborted := make(chan struct{})
+	interrupter, _ := s.Channel.(driver.Interrupter)
+
+	s.abortMu.Lock()
+	s.abort = func() {
+		close(aborted)
+		if interrupter != nil {
+			interrupter.Interrupt()
+		}
+	}
+	s.abortMu.Unlock()
+	return aborted
+}
+
+// endCommand disarms CmdAbort once the card I/O has returned.
+func (s *Session) endCommand() {
+	s.abortMu.Lock()
+	s.abort = nil
+	s.abortMu.Unlock()
+}
+
+// abortCommand interrupts the card I/O in progress and reports whether there
+// was any. It needs no device lock, the command holds it.
+func (s *Session) abortCommand() bool {
+	s.abortMu.Lock()
+	abort := s.abort
+	s.abort = nil
+	s.abortMu.Unlock()
+
+	if abort == nil {
+		return false
+	}
+	abort()
+	return true
+}
+
+// handleAbort cuts short the command the session is running. The command
+// answers with errCommandAborted; its driver call stops as well where the
+// driver implements driver.Interrupter, and otherwise runs on in the
+// background like a timed-out one. Nothing to abort is not an error, the
+// command may just have finished.
+func handleAbort(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	sessionsMu.RLock()
+	session, err := lookupSession(pcRcv, remoteAddr)
+	sessionsMu.RUnlock()
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if !session.abortCommand() {
+		slog.Debug("no command to abort", "client", remoteAddr, "device", session.Device)
+	}
+	return localnet.NewPacketCmd(localnet.CmdResponse)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// allowedNets restricts which source networks may reach the server at all;
+// empty allows every address.
+var allowedNets []*net.IPNet
+
+// allowedProtos and allowedDevices restrict what clients may open. Entries
+// are exact names or filepath.Match globs such as /dev/cdc-wdm*; an empty
+// list allows everything.
+var (
+	allowedProtos  []string
+	allowedDevices []string
+)
+
+func matchesAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAllowed rejects a protocol or device outside the allow-lists before
+// any driver touches it. QRTR takes no device path, so only its protocol is
+// checked.
+func checkAllowed(proto string, device string, remoteAddr net.Addr) error {
+	if !matchesAny(allowedProtos, proto) {
+		slog.Warn("rejecting protocol not allowed", "client", remoteAddr, "protocol", proto)
+		return withCode(localnet.ErrCodeUnsupportedProto, fmt.Errorf("protocol not allowed: %s", proto))
+	}
+	if proto != "qrtr" && !matchesAny(allowedDevices, device) {
+		slog.Warn("rejecting device not allowed", "client", remoteAddr, "device", device)
+		return withCode(localnet.ErrCodeForbidden, fmt.Errorf("device not allowed: %s", device))
+	}
+	return nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowCIDR %q %w", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// addrAllowed reports whether a client at addr may reach the server. Unix
+// socket peers have no IP address and are left to the file permissions.
+func addrAllowed(addr net.Addr) bool {
+	if len(allowedNets) == 0 {
+		return true
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		return true
+	}
+	for _, ipNet := range allowedNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropBlocked accounts for a datagram or connection from outside
+// -allowCIDR; it is logged at debug level only, so a flood of them cannot
+// flood the log.
+func dropBlocked(remoteAddr net.Addr) {
+	slog.Debug("dropping client outside allowCIDR", "client", remoteAddr)
+	blocked.Inc()
+}
+
+// allowedOnly wraps listener so that connections from outside -allowCIDR
+// are closed as soon as they are accepted, before a single byte is read.
+func allowedOnly(listener net.Listener) net.Listener {
+	if len(allowedNets) == 0 {
+		return listener
+	}
+	return cidrListener{listener}
+}
+
+type cidrListener struct {
+	net.Listener
+}
+
+func (l cidrListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || addrAllowed(conn.RemoteAddr()) {
+			return conn, err
+		}
+		dropBlocked(conn.RemoteAddr())
+		conn.Close()
+	}
+}
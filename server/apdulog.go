@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apduLogDir receives one transcript file per session, empty disables
+// transcripts. A file growing past apduLogMaxSize is rotated to a single
+// ".1" backup, and only the apduLogKeep most recent transcripts are kept.
+var (
+	apduLogDir     string
+	apduLogMaxSize int64
+	apduLogKeep    int
+	apduLogRedact  redactSet
+)
+
+// redactSet holds the INS bytes whose command and response data are left
+// out of transcripts, such as VERIFY carrying a PIN.
+type redactSet struct {
+	all bool
+	ins map[byte]bool
+}
+
+// parseRedactSet reads a list of hex INS bytes, "*" standing for every
+// command.
+func parseRedactSet(list []string) (redactSet, error) {
+	set := redactSet{ins: make(map[byte]bool)}
+	for _, item := range list {
+		if item == "*" {
+			set.all = true
+			continue
+		}
+		ins, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(item), "0x"), 16, 8)
+		if err != nil {
+			return set, fmt.Errorf("invalid apduLogRedact INS, expected a hex byte: %s", item)
+		}
+		set.ins[byte(ins)] = true
+	}
+	return set, nil
+}
+
+func (r redactSet) covers(apdu []byte) bool {
+	return r.all || len(apdu) > 1 && r.ins[apdu[1]]
+}
+
+// transcript writes the APDUs of one session. Its methods do nothing on a
+// nil transcript, so sessions without one need no checks. Callers hold the
+// device lock of the session, which serializes writes.
+type transcript struct {
+	path string
+	file *os.File
+	size int64
+}
+
+// openTranscript starts the transcript of session, or returns nil when
+// transcripts are disabled or the file cannot be created.
+func openTranscript(session *Session) *transcript {
+	if apduLogDir == "" {
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%.8s.log", session.StartedAt.UTC().Format("20060102T150405Z"), session.ID)
+	t := &transcript{path: filepath.Join(apduLogDir, name)}
+	if err := t.open(); err != nil {
+		slog.Error("failed to open apdu transcript", "path", t.path, "error", err)
+		return nil
+	}
+	pruneTranscripts()
+
+	t.note("session %s device=%s proto=%s slot=%d client=%s", session.ID, session.Device, session.Proto, session.Slot, session.RemoteAddr)
+	return t
+}
+
+func (t *transcript) open() error {
+	file, err := os.OpenFile(t.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	t.file, t.size = file, info.Size()
+	return nil
+}
+
+// command records an APDU sent to the card.
+func (t *transcript) command(apdu []byte) {
+	if t == nil {
+		return
+	}
+	if apduLogRedact.covers(apdu) && len(apdu) > 4 {
+		t.write("> %X [%d bytes redacted]", apdu[:4], len(apdu)-4)
+		return
+	}
+	t.write("> %X", apdu)
+}
+
+// response records the answer of the card to command, or the error that
+// took its place.
+func (t *transcript) response(command []byte, response []byte, err error, elapsed time.Duration) {
+	if t == nil {
+		return
+	}
+	took := elapsed.Round(time.Microsecond)
+	switch {
+	case err != nil:
+		t.write("! %v (%s)", err, took)
+	case len(response) < 2:
+		t.write("< %X (%s)", response, took)
+	case len(response) == 2:
+		t.write("< SW=%X (%s)", response, took)
+	case apduLogRedact.covers(command):
+		t.write("< [%d bytes redacted] SW=%X (%s)", len(response)-2, response[len(response)-2:], took)
+	default:
+		t.write("< %X SW=%X (%s)", response[:len(response)-2], response[len(response)-2:], took)
+	}
+}
+
+// note records a session event other than an APDU exchange.
+func (t *transcript) note(format string, args ...any) {
+	if t == nil {
+		return
+	}
+	t.write("# "+format, args...)
+}
+
+func (t *transcript) close() {
+	if t == nil || t.file == nil {
+		return
+	}
+	t.note("session ended")
+	t.file.Close()
+	t.file = nil
+}
+
+func (t *transcript) write(format string, args ...any) {
+	if t.file == nil {
+		return
+	}
+	if apduLogMaxSize > 0 && t.size >= apduLogMaxSize {
+		t.rotate()
+		if t.file == nil {
+			return
+		}
+	}
+
+	line := time.Now().UTC().Format("2006-01-02T15:04:05.000Z") + " " + fmt.Sprintf(format, args...) + "\n"
+	n, err := t.file.WriteString(line)
+	t.size += int64(n)
+	if err != nil {
+		slog.Error("failed to write apdu transcript, closing it", "path", t.path, "error", err)
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// rotate moves the full transcript to its ".1" backup, replacing the
+// previous one, and starts over.
+func (t *transcript) rotate() {
+	t.file.Close()
+	t.file = nil
+	if err := os.Rename(t.path, t.path+".1"); err != nil {
+		slog.Error("failed to rotate apdu transcript", "path", t.path, "error", err)
+		return
+	}
+	if err := t.open(); err != nil {
+		slog.Error("failed to reopen apdu transcript", "path", t.path, "error", err)
+	}
+}
+
+// pruneTranscripts removes the oldest transcripts beyond apduLogKeep, with
+// their backups. File names start with the session start time, so they sort
+// oldest first.
+func pruneTranscripts() {
+	if apduLogKeep <= 0 {
+		return
+	}
+	logs, err := filepath.Glob(filepath.Join(apduLogDir, "*.log"))
+	if err != nil || len(logs) <= apduLogKeep {
+		return
+	}
+	slices.Sort(logs)
+	for _, path := range logs[:len(logs)-apduLogKeep] {
+		if err = os.Remove(path); err != nil {
+			slog.Warn("failed to remove old apdu transcript", "path", path, "error", err)
+		}
+		os.Remove(path + ".1")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+var allowedTokens []string
+
+// loadAuthTokens collects the tokens accepted on connect from the -authToken
+// flag and from -authTokenFile, one token per line; # starts a comment.
+func loadAuthTokens(token string, file string) ([]string, error) {
+	var tokens []string
+	if token != "" {
+		tokens = append(tokens, token)
+	}
+
+	if file == "" {
+		return tokens, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening token file %s %w", file, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading token file %s %w", file, err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens found in %s", file)
+	}
+	return tokens, nil
+}
+
+func authTokenAllowed(token string) bool {
+	if len(allowedTokens) == 0 {
+		return true
+	}
+
+	allowed := false
+	for _, t := range allowedTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// claimedBy reports whether a connect comes from the client holding session:
+// it presents the session token or connects from the same address. A shared
+// connect token does not count, or every client holding it could take over
+// the others' sessions.
+func claimedBy(session *Session, pcConn localnet.IPacketConnect, remoteAddr net.Addr) bool {
+	if token := pcConn.GetSessionToken(); token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.ID)) == 1 {
+		return true
+	}
+	return addressesEqual(session.RemoteAddr, remoteAddr)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+func TestSharedAuthTokenDoesNotReclaim(t *testing.T) {
+	saved := allowedTokens
+	t.Cleanup(func() { allowedTokens = saved })
+	allowedTokens = []string{"shared"}
+	t.Cleanup(cleanupAllSessions)
+
+	connect := func(remoteAddr int, sessionToken string) localnet.IPacketCmd {
+		pcRcv := localnet.NewPacketConnect("/dev/mock-shared", "mockrec", 0, "shared")
+		pcRcv.SetSessionToken(sessionToken)
+		return handleCommand(pcRcv, udpAddr(remoteAddr), nil)
+	}
+	owner := connect(1, "")
+	if owner.GetErr() != "" {
+		t.Fatalf("connect: %s", owner.GetErr())
+	}
+
+	// another client with the same auth token finds the device busy
+	if pcSnd := connect(2, ""); pcSnd.GetErr() == "" {
+		t.Fatal("second client took over the session with the shared auth token")
+	}
+	sessionsMu.RLock()
+	session := sessionForDevice("/dev/mock-shared")
+	sessionsMu.RUnlock()
+	if session == nil || !addressesEqual(session.RemoteAddr, udpAddr(1)) {
+		t.Fatalf("session moved to %v", session)
+	}
+
+	// the owner reclaims it from a new address with its session token
+	pcSnd := connect(3, owner.GetSessionToken())
+	if pcSnd.GetErr() != "" {
+		t.Fatalf("reclaim: %s", pcSnd.GetErr())
+	}
+	if resp, ok := pcSnd.(localnet.IPacketConnectResp); !ok || !resp.GetResumed() {
+		t.Fatalf("reclaim answered %v, want a resumed session", pcSnd)
+	}
+}
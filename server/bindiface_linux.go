@@ -0,0 +1,28 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenConfig returns the ListenConfig for the main listener. With an
+// interface set, SO_BINDTODEVICE restricts the socket to packets arriving
+// on it and routes its replies out of it, whatever the routing table says.
+func listenConfig(iface string) (*net.ListenConfig, error) {
+	if iface == "" {
+		return &net.ListenConfig{}, nil
+	}
+	return &net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+			}); err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// listenConfig returns the ListenConfig for the main listener. Binding to an
+// interface relies on SO_BINDTODEVICE, which only Linux offers.
+func listenConfig(iface string) (*net.ListenConfig, error) {
+	if iface != "" {
+		return nil, errors.New("bindInterface is only supported on linux")
+	}
+	return &net.ListenConfig{}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// handleCapabilities lists the features this server offers. Like status it
+// needs no session, only a valid auth token.
+func handleCapabilities(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	pcConn, ok := pcRcv.(localnet.IPacketConnect)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type for capabilities")
+	}
+
+	if !authTokenAllowed(pcConn.GetAuthToken()) {
+		slog.Warn("rejecting capabilities with invalid auth token", "client", remoteAddr)
+		return localnet.NewPacketErr(localnet.ErrCodeUnauthorized, "invalid auth token")
+	}
+
+	body, err := json.Marshal(localnet.ServerCapabilities{
+		ProtocolVersion: localnet.CurrentProtocolVersion,
+		Features:        serverFeatures(),
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return localnet.NewPacketBody(localnet.CmdResponse, body)
+}
+
+// serverFeatures lists the features of this server as configured; adminKick
+// needs -adminToken, events -eventInterval, autoGetResponse
+// -autoGetResponse, concurrentSessions -allowConcurrent and
+// connectChallenge -connectChallenge.
+func serverFeatures() []string {
+	features := []string{
+		localnet.FeatureFragmentation,
+		localnet.FeatureBinaryCodec,
+		localnet.FeatureBatch,
+		localnet.FeatureStatus,
+		localnet.FeatureSlots,
+		localnet.FeatureReset,
+		localnet.FeatureEID,
+		localnet.FeatureTransmitOn,
+		localnet.FeatureAbort,
+		localnet.FeatureSelect,
+		localnet.FeatureStoreData,
+		localnet.FeatureHealth,
+		localnet.FeatureProfiles,
+		localnet.FeatureSwitchSlot,
+		localnet.FeatureKeepChannelAlive,
+		localnet.FeatureRepeatLast,
+		localnet.FeatureConnectAID,
+		localnet.FeatureListProtocols,
+		localnet.FeatureWideSlots,
+		localnet.FeatureZstd,
+		localnet.FeatureProfileState,
+		localnet.FeatureSelfTest,
+	}
+	if adminToken != "" {
+		features = append(features, localnet.FeatureAdminKick)
+	}
+	if eventInterval > 0 {
+		features = append(features, localnet.FeatureEvents)
+	}
+	if autoGetResponse {
+		features = append(features, localnet.FeatureAutoGetResponse)
+	}
+	if allowConcurrent {
+		features = append(features, localnet.FeatureConcurrent)
+	}
+	if connectChallenge {
+		features = append(features, localnet.FeatureConnectChallenge)
+	}
+	return features
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// cardKeepAlive, when positive, makes the server send STATUS to the card of
+// every session whose card has been left alone that long, for cards that
+// deactivate when idle. It is off by default, as the extra traffic upsets
+// some cards.
+var cardKeepAlive time.Duration
+
+// handleKeepChannelAlive sends STATUS on a channel the session has open, so
+// that the card sees traffic, and answers with the status word. Unlike
+// ping it reaches the card.
+func handleKeepChannelAlive(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
+	}
+	defer func() { unlock() }()
+
+	pktChannel, ok := pcRcv.(localnet.IPacketChannelBody)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type for keep channel alive")
+	}
+
+	command := statusAPDU(pktChannel.GetChannel())
+	if err = checkTransmitChannel(session, pktChannel.GetChannel(), command); err != nil {
+		return errorReply(err)
+	}
+
+	var sw uint16
+	if terr := callCard(session, timeoutFor(pcRcv), &unlock, func() {
+		sw, err = pingCard(session, command)
+	}); terr != nil {
+		return errorReply(terr)
+	}
+	if err != nil {
+		slog.Warn("keep channel alive failed", "device", session.Device, "channel", pktChannel.GetChannel(), "error", err)
+		return errorReply(endIfGone(session, err))
+	}
+
+	session.touch()
+
+	slog.Debug("channel kept alive", "device", session.Device, "channel", pktChannel.GetChannel(), "sw", fmt.Sprintf("%04X", sw))
+
+	return localnet.NewPacketBody(localnet.CmdResponse, []byte{byte(sw >> 8), byte(sw)})
+}
+
+// statusAPDU builds the ETSI TS 102 221 STATUS command returning no data,
+// 80 F2 00 0C 00, with the channel in the class byte.
+func statusAPDU(channel byte) []byte {
+	return []byte{channelCLA(0x80, channel), 0xF2, 0x00, 0x0C, 0x00}
+}
+
+// pingCard sends command and returns the status word the card answered
+// with. Any status word will do: it shows the card is awake.
+func pingCard(session *Session, command []byte) (uint16, error) {
+	started := time.Now()
+	session.transcript.command(command)
+	response, err := session.Channel.Transmit(command)
+	session.transcript.response(command, response, err, time.Since(started))
+	if err != nil {
+		return 0, err
+	}
+	_, sw, err := localnet.SplitStatusWord(response)
+	return sw, err
+}
+
+// watchCardKeepAlive pings the cards of sessions idle on the card for
+// cardKeepAlive, checking twice per interval. Pinging does not count as
+// session activity, so a client that went away still sees its session
+// expire.
+func watchCardKeepAlive(ctx context.Context) {
+	ticker := time.NewTicker(cardKeepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sessionsMu.RLock()
+			for _, session := range sessions {
+				if session.cardIdle() >= cardKeepAlive && session.keepingAlive.CompareAndSwap(false, true) {
+					go keepCardAlive(session)
+				}
+			}
+			sessionsMu.RUnlock()
+		}
+	}
+}
+
+// keepCardAlive sends STATUS on the channel the session opened last, or the
+// basic channel, unless the session ended or used the card while waiting
+// for the device lock.
+func keepCardAlive(session *Session) {
+	defer session.keepingAlive.Store(false)
+
+	if !inFlight.enter() {
+		return
+	}
+	defer inFlight.leave()
+
+	unlock := deviceLocks.lock(session.Device)
+	defer func() { unlock() }()
+
+	sessionsMu.RLock()
+	current := sessions[session.ID] == session
+	var channel byte
+	if n := len(session.LogicalChannels); n > 0 {
+		channel = session.LogicalChannels[n-1]
+	}
+	sessionsMu.RUnlock()
+	if !current || session.cardIdle() < cardKeepAlive {
+		return
+	}
+
+	timeout := commandTimeout
+	if timeout == 0 {
+		timeout = cardKeepAlive
+	}
+	var sw uint16
+	var err error
+	if terr := callCard(session, timeout, &unlock, func() {
+		sw, err = pingCard(session, statusAPDU(channel))
+	}); terr != nil {
+		slog.Warn("card keepalive failed", "device", session.Device, "error", terr)
+		return
+	}
+	if err != nil {
+		slog.Warn("card keepalive failed", "device", session.Device, "channel", channel, "error", err)
+		endIfGone(session, err)
+		return
+	}
+	slog.Debug("card kept alive", "device", session.Device, "channel", channel, "sw", fmt.Sprintf("%04X", sw))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+const (
+	// challengeTimeout is how long a client has to answer a connect
+	// challenge; one round trip is enough.
+	challengeTimeout = 5 * time.Second
+	// maxPendingChallenges bounds the challenges held at once, so spoofed
+	// connects cannot grow the table without limit; the oldest goes first.
+	maxPendingChallenges = 4096
+)
+
+// connectChallenge is set by -connectChallenge. DTLS proves the address
+// with its own cookie exchange, so main leaves it off there.
+var connectChallenge bool
+
+type pendingChallenge struct {
+	addr    string
+	nonce   []byte
+	connect localnet.IPacketCmd
+	expires time.Time
+}
+
+// pendingChallenges holds one challenge per client address, oldest first;
+// challengesByAddr indexes it.
+var (
+	challengesMu      sync.Mutex
+	pendingChallenges = list.New()
+	challengesByAddr  = map[string]*list.Element{}
+)
+
+// challengeRequired reports whether a connect from remoteAddr must prove
+// the address before it starts a session. Only plain UDP can be spoofed
+// blindly; streams complete a handshake with the client first.
+func challengeRequired(remoteAddr net.Addr) bool {
+	_, udp := remoteAddr.(*net.UDPAddr)
+	return connectChallenge && udp
+}
+
+// issueChallenge keeps pcConn aside and answers it with a nonce instead of
+// a session. A client at the address it claims receives the nonce and
+// proves it with a PacketConnectProof, which handleConnectProof checks
+// before the connect goes ahead. Clients older than ProtocolVersion6 cannot
+// answer and are refused.
+func issueChallenge(pcConn localnet.IPacketConnect, remoteAddr net.Addr) localnet.IPacketCmd {
+	if pcConn.GetProtocolVersion() < localnet.ProtocolVersion6 {
+		slog.Warn("rejecting connect from client too old for the connect challenge", "client", remoteAddr, "version", pcConn.GetProtocolVersion())
+		return localnet.NewPacketErr(localnet.ErrCodeUnauthorized, "connect challenge required, client too old")
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return errorReply(err)
+	}
+
+	challengesMu.Lock()
+	defer challengesMu.Unlock()
+
+	now := time.Now()
+	for front := pendingChallenges.Front(); front != nil; front = pendingChallenges.Front() {
+		if pending := front.Value.(*pendingChallenge); pendingChallenges.Len() < maxPendingChallenges && now.Before(pending.expires) {
+			break
+		}
+		dropChallenge(front)
+	}
+	// a repeated connect replaces the challenge before it
+	addr := remoteAddr.String()
+	if elem, ok := challengesByAddr[addr]; ok {
+		dropChallenge(elem)
+	}
+	challengesByAddr[addr] = pendingChallenges.PushBack(&pendingChallenge{addr, nonce, pcConn, now.Add(challengeTimeout)})
+
+	slog.Debug("connect challenged", "client", remoteAddr)
+	return localnet.NewPacketChallenge(nonce)
+}
+
+// dropChallenge removes elem; callers hold challengesMu.
+func dropChallenge(elem *list.Element) {
+	pendingChallenges.Remove(elem)
+	delete(challengesByAddr, elem.Value.(*pendingChallenge).addr)
+}
+
+// handleConnectProof runs the connect a challenge was issued for once the
+// client proves it received the nonce. The challenge stays valid until it
+// expires, so a proof resent after its reply was lost connects again and
+// gets back the session the first one started.
+func handleConnectProof(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	pcProof, ok := pcRcv.(localnet.IPacketConnectProof)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type for connect proof")
+	}
+
+	challengesMu.Lock()
+	var pending *pendingChallenge
+	if elem, ok := challengesByAddr[remoteAddr.String()]; ok {
+		pending = elem.Value.(*pendingChallenge)
+	}
+	challengesMu.Unlock()
+
+	if pending == nil || time.Now().After(pending.expires) {
+		slog.Warn("rejecting connect proof without a pending challenge", "client", remoteAddr)
+		return localnet.NewPacketErr(localnet.ErrCodeUnauthorized, "no pending connect challenge")
+	}
+
+	pcConn := pending.connect.(localnet.IPacketConnect)
+	proof := localnet.ChallengeProof(pending.nonce, pcConn.GetAuthToken())
+	if subtle.ConstantTimeCompare(pcProof.GetNonce(), pending.nonce) != 1 || !hmac.Equal(pcProof.GetProof(), proof) {
+		slog.Warn("rejecting connect proof that does not match the challenge", "client", remoteAddr)
+		return localnet.NewPacketErr(localnet.ErrCodeUnauthorized, "connect proof does not match the challenge")
+	}
+
+	slog.Debug("connect challenge answered", "client", remoteAddr)
+	return openSession(pcConn, remoteAddr, true)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// defaultMaxChannels matches the channels 1 to 3 every card offers besides
+// the basic channel; cards with more are rarer.
+const defaultMaxChannels = 3
+
+// maxChannels caps the logical channels one session may hold open, so that
+// a client leaking channels cannot exhaust the card and closing a session
+// stays bounded; 0 for no limit beyond the card's.
+var maxChannels = defaultMaxChannels
+
+// checkChannelLimit refuses another channel for a session holding
+// maxChannels already; callers hold the device lock.
+func checkChannelLimit(session *Session) error {
+	if maxChannels <= 0 {
+		return nil
+	}
+	sessionsMu.RLock()
+	open := len(session.LogicalChannels)
+	sessionsMu.RUnlock()
+	if open >= maxChannels {
+		return fmt.Errorf("%w: session has %d open, the limit is %d; close one first", localnet.ErrTooManyChannels, open, maxChannels)
+	}
+	return nil
+}
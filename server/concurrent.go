@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// allowConcurrent lets several sessions hold one device at the same time,
+// for a trusted client multiplexing access itself. They share the driver
+// connection and the device lock still runs their card operations one at a
+// time, but nothing keeps one session's APDUs from changing card state
+// another relies on.
+var allowConcurrent bool
+
+// insManageChannel is MANAGE CHANNEL, which with -allowConcurrent only runs
+// through opch and clch so the server knows which session owns a channel.
+const insManageChannel = 0x70
+
+// sharedCard counts the sessions using one driver connection; callers hold
+// the device lock.
+type sharedCard struct {
+	users int
+}
+
+// shared reports whether other sessions use the session's card; callers
+// hold the device lock.
+func (s *Session) shared() bool {
+	return s.card != nil && s.card.users > 1
+}
+
+// resumableBy reports whether pcConn may take over session. With
+// -allowConcurrent every client presents the same auth token and may share
+// an address, so only the session token picks a token-bound session out.
+func resumableBy(session *Session, pcConn localnet.IPacketConnect, remoteAddr net.Addr) bool {
+	if !allowConcurrent || !session.tokenBound() {
+		return claimedBy(session, pcConn, remoteAddr)
+	}
+	token := pcConn.GetSessionToken()
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.ID)) == 1
+}
+
+// sharedSession returns a live session on device whose card a new session
+// may join, nil if there is none; callers hold the device lock. A session
+// on another protocol or slot makes the device busy.
+func sharedSession(device string, proto string, slot uint16) (*Session, error) {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+
+	session := sessionForDevice(device)
+	if session == nil {
+		return nil, nil
+	}
+	if session.Proto != proto || session.Slot != slot {
+		return nil, withCode(localnet.ErrCodeBusy, fmt.Errorf("device busy, shared on %s slot %d", session.Proto, session.Slot))
+	}
+	return session, nil
+}
+
+// errSharedCard refuses a command that would pull the card from under the
+// other sessions sharing it.
+var errSharedCard = withCode(localnet.ErrCodeBusy, errors.New("device shared with other sessions"))
+
+// checkSharedAPDU rejects, with -allowConcurrent, an APDU addressing a
+// logical channel the session did not open, or a MANAGE CHANNEL that would
+// open or close one behind the server's back.
+func checkSharedAPDU(session *Session, apdu []byte) error {
+	if !allowConcurrent {
+		return nil
+	}
+	if len(apdu) < 2 {
+		return localnet.ErrAPDUTooShort
+	}
+	if apdu[1] == insManageChannel {
+		return fmt.Errorf("%w: MANAGE CHANNEL must use opch and clch while sessions share devices", localnet.ErrInvalidChannel)
+	}
+	return checkOwnChannel(session, localnet.ChannelOfCLA(apdu[0]))
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+	"gopkg.in/yaml.v3"
+)
+
+// minBufferSize leaves room for a fragment header and a useful chunk.
+const minBufferSize = 512
+
+// Config holds the server settings. Keys in a -config file are named after
+// the flags; JSON files work too, being valid YAML.
+type Config struct {
+	BindAddr             string   `yaml:"bindAddr"`
+	BindPort             int      `yaml:"bindPort"`
+	IPVersion            string   `yaml:"ipVersion"`
+	BindInterface        string   `yaml:"bindInterface"`
+	BufferSize           int      `yaml:"bufferSize"`
+	UDPReadBuffer        int      `yaml:"udpReadBuffer"`
+	UDPWriteBuffer       int      `yaml:"udpWriteBuffer"`
+	DSCP                 int      `yaml:"dscp"`
+	MaxAPDUSize          int      `yaml:"maxAPDUSize"`
+	Timeout              int      `yaml:"timeout"`
+	MinTimeout           int      `yaml:"minTimeout"`
+	MaxTimeout           int      `yaml:"maxTimeout"`
+	MaxSessionDuration   int      `yaml:"maxSessionDuration"`
+	CommandTimeout       int      `yaml:"commandTimeout"`
+	ConnectTimeout       int      `yaml:"connectTimeout"`
+	OpenRetries          int      `yaml:"openRetries"`
+	MaxChannels          int      `yaml:"maxChannels"`
+	AutoGetResponse      bool     `yaml:"autoGetResponse"`
+	AllowConcurrent      bool     `yaml:"allowConcurrent"`
+	EventInterval        int      `yaml:"eventInterval"`
+	CardKeepAlive        int      `yaml:"cardKeepAlive"`
+	DrainTimeout         int      `yaml:"drainTimeout"`
+	SessionFile          string   `yaml:"sessionFile"`
+	SessionGrace         int      `yaml:"sessionGrace"`
+	ReadDeadline         int      `yaml:"readDeadline"`
+	Transport            string   `yaml:"transport"`
+	TLSCert              string   `yaml:"tlsCert"`
+	TLSKey               string   `yaml:"tlsKey"`
+	PSK                  string   `yaml:"psk"`
+	PSKHint              string   `yaml:"pskHint"`
+	AuthToken            string   `yaml:"authToken"`
+	AuthTokens           []string `yaml:"authTokens"`
+	AuthTokenFile        string   `yaml:"authTokenFile"`
+	ConnectChallenge     bool     `yaml:"connectChallenge"`
+	AdminToken           string   `yaml:"adminToken"`
+	AllowProtos          []string `yaml:"allowProtos"`
+	AllowDevices         []string `yaml:"allowDevices"`
+	AllowCIDR            []string `yaml:"allowCIDR"`
+	Compression          int      `yaml:"compression"`
+	CompressionThreshold int      `yaml:"compressionThreshold"`
+	MaxDecompressedSize  int      `yaml:"maxDecompressedSize"`
+	ResponseCache        int      `yaml:"responseCache"`
+	MetricsAddr          string   `yaml:"metricsAddr"`
+	Socket               string   `yaml:"socket"`
+	SocketMode           string   `yaml:"socketMode"`
+	WSAddr               string   `yaml:"wsAddr"`
+	WSOrigins            []string `yaml:"wsOrigins"`
+	RateLimit            float64  `yaml:"rateLimit"`
+	RateBurst            int      `yaml:"rateBurst"`
+	APDULog              string   `yaml:"apduLog"`
+	APDULogRedact        []string `yaml:"apduLogRedact"`
+	APDULogMaxSize       int      `yaml:"apduLogMaxSize"`
+	APDULogKeep          int      `yaml:"apduLogKeep"`
+	LogLevel             string   `yaml:"logLevel"`
+	LogFormat            string   `yaml:"logFormat"`
+	LogBodies            bool     `yaml:"logBodies"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		BindAddr:             "0.0.0.0",
+		BindPort:             8080,
+		IPVersion:            "dual",
+		BufferSize:           2048,
+		MaxAPDUSize:          localnet.DefaultMaxAPDUSize,
+		Timeout:              60,
+		MinTimeout:           5,
+		MaxTimeout:           600,
+		DrainTimeout:         30,
+		ConnectTimeout:       30,
+		OpenRetries:          2,
+		MaxChannels:          defaultMaxChannels,
+		SessionGrace:         60,
+		Transport:            "udp",
+		Compression:          localnet.DefaultCompressionLevel,
+		CompressionThreshold: localnet.DefaultCompressionThreshold,
+		MaxDecompressedSize:  localnet.DefaultMaxDecompressedSize,
+		ResponseCache:        defaultResponseCacheSize,
+		SocketMode:           "0660",
+		RateBurst:            20,
+		APDULogMaxSize:       1024,
+		APDULogKeep:          100,
+		LogLevel:             "info",
+		LogFormat:            "text",
+		LogBodies:            true,
+	}
+}
+
+// registerFlags binds the command line flags to the fields of c, so that
+// their defaults are the ones c holds.
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.BindAddr, "bindAddr", c.BindAddr, "Binding address")
+	fs.IntVar(&c.BindPort, "bindPort", c.BindPort, "Binding port")
+	fs.StringVar(&c.IPVersion, "ipVersion", c.IPVersion, "IP version to listen on: dual, 4 or 6")
+	fs.StringVar(&c.BindInterface, "bindInterface", c.BindInterface, "Network interface to receive and reply on, Linux only")
+	fs.IntVar(&c.BufferSize, "bufferSize", c.BufferSize, "Buffer size in byte")
+	fs.IntVar(&c.UDPReadBuffer, "udpReadBuffer", c.UDPReadBuffer, "Kernel receive buffer of the UDP socket in bytes, 0 for the OS default")
+	fs.IntVar(&c.UDPWriteBuffer, "udpWriteBuffer", c.UDPWriteBuffer, "Kernel send buffer of the UDP socket in bytes, 0 for the OS default")
+	fs.IntVar(&c.DSCP, "dscp", c.DSCP, "DSCP marking the UDP datagrams sent, 0 to 63, 0 leaves them unmarked")
+	fs.IntVar(&c.MaxAPDUSize, "maxAPDUSize", c.MaxAPDUSize, "Largest APDU accepted in bytes")
+	fs.IntVar(&c.Timeout, "timeout", c.Timeout, "Session timeout in seconds")
+	fs.IntVar(&c.MinTimeout, "minTimeout", c.MinTimeout, "Shortest session timeout in seconds a client may request")
+	fs.IntVar(&c.MaxTimeout, "maxTimeout", c.MaxTimeout, "Longest session timeout in seconds a client may request")
+	fs.IntVar(&c.MaxSessionDuration, "maxSessionDuration", c.MaxSessionDuration, "Seconds a session may last however busy, 0 for no limit")
+	fs.IntVar(&c.CommandTimeout, "commandTimeout", c.CommandTimeout, "Seconds a single card operation may take, 0 for no limit")
+	fs.IntVar(&c.ConnectTimeout, "connectTimeout", c.ConnectTimeout, "Seconds opening and connecting a device may take on conn, 0 for no limit")
+	fs.IntVar(&c.OpenRetries, "openRetries", c.OpenRetries, "Times opch retries opening a channel the card refused with 6A80 or 6A81, 0 disables")
+	fs.IntVar(&c.MaxChannels, "maxChannels", c.MaxChannels, "Logical channels one session may hold open, 0 for no limit")
+	fs.BoolVar(&c.AutoGetResponse, "autoGetResponse", c.AutoGetResponse, "Follow a 61xx status word with GET RESPONSE and return the whole response on tran")
+	fs.BoolVar(&c.AllowConcurrent, "allowConcurrent", c.AllowConcurrent, "Let several sessions share one device, for a single trusted client; their APDUs interleave")
+	fs.IntVar(&c.DrainTimeout, "drainTimeout", c.DrainTimeout, "Seconds shutdown waits for commands in flight to finish")
+	fs.StringVar(&c.SessionFile, "sessionFile", c.SessionFile, "File saving open sessions at shutdown for their clients to resume after a restart, empty disables")
+	fs.IntVar(&c.SessionGrace, "sessionGrace", c.SessionGrace, "Seconds after a restart during which saved sessions can be resumed")
+	fs.IntVar(&c.ReadDeadline, "readDeadline", c.ReadDeadline, "Milliseconds after which an idle UDP read wakes up, 0 to block until shutdown")
+	fs.IntVar(&c.EventInterval, "eventInterval", c.EventInterval, "Seconds between polls for card insertion and removal, 0 disables events")
+	fs.IntVar(&c.CardKeepAlive, "cardKeepAlive", c.CardKeepAlive, "Seconds a session's card may stay idle before the server sends it STATUS, 0 disables")
+	fs.StringVar(&c.TLSCert, "tlsCert", c.TLSCert, "DTLS certificate file (enables DTLS)")
+	fs.StringVar(&c.TLSKey, "tlsKey", c.TLSKey, "DTLS private key file")
+	fs.StringVar(&c.PSK, "psk", c.PSK, "DTLS pre-shared key in hex (enables DTLS)")
+	fs.StringVar(&c.PSKHint, "pskHint", c.PSKHint, "DTLS PSK identity hint")
+	fs.StringVar(&c.Transport, "transport", c.Transport, "Comma separated transports to listen on, sharing bindPort: udp, tcp, or stdio to serve the parent process over stdin and stdout")
+	fs.IntVar(&c.Compression, "compression", c.Compression, "Gzip or zstd level 0-9, or -1 to disable compression")
+	fs.StringVar(&c.AuthToken, "authToken", c.AuthToken, "Token clients must present on connect")
+	fs.StringVar(&c.AuthTokenFile, "authTokenFile", c.AuthTokenFile, "File of accepted connect tokens, one per line")
+	fs.BoolVar(&c.ConnectChallenge, "connectChallenge", c.ConnectChallenge, "Make UDP clients prove their address by answering a nonce before a connect starts a session")
+	fs.StringVar(&c.AdminToken, "adminToken", c.AdminToken, "Token admin commands must present; without it they are refused")
+	fs.IntVar(&c.ResponseCache, "responseCache", c.ResponseCache, "Responses kept per session to answer retransmitted requests, 0 disables")
+	fs.StringVar(&c.MetricsAddr, "metricsAddr", c.MetricsAddr, "Address serving Prometheus metrics on /metrics, empty disables")
+	fs.StringVar(&c.Socket, "socket", c.Socket, "Also listen on this unix socket path")
+	fs.StringVar(&c.SocketMode, "socketMode", c.SocketMode, "Permissions of the unix socket file, in octal")
+	fs.StringVar(&c.WSAddr, "wsAddr", c.WSAddr, "Address serving a WebSocket endpoint on /ws, empty disables")
+	fs.Var((*listFlag)(&c.WSOrigins), "wsOrigins", "Comma separated origins or globs browsers may connect from over WebSocket, empty allows all")
+	fs.Var((*listFlag)(&c.AllowProtos), "allowProtos", "Comma separated protocols or globs clients may open, empty allows all")
+	fs.Var((*listFlag)(&c.AllowDevices), "allowDevices", "Comma separated device paths or globs clients may open, empty allows all")
+	fs.Var((*listFlag)(&c.AllowCIDR), "allowCIDR", "Comma separated networks in CIDR notation clients may connect from, empty allows all")
+	fs.IntVar(&c.CompressionThreshold, "compressionThreshold", c.CompressionThreshold, "Packets smaller than this many bytes are sent uncompressed")
+	fs.IntVar(&c.MaxDecompressedSize, "maxDecompressedSize", c.MaxDecompressedSize, "Bytes a compressed packet may expand to before it is rejected")
+	fs.Float64Var(&c.RateLimit, "rateLimit", c.RateLimit, "Commands per second allowed per client host, 0 disables")
+	fs.IntVar(&c.RateBurst, "rateBurst", c.RateBurst, "Commands a client host may send at once before -rateLimit applies")
+	fs.StringVar(&c.APDULog, "apduLog", c.APDULog, "Directory receiving an APDU transcript per session, empty disables")
+	fs.Var((*listFlag)(&c.APDULogRedact), "apduLogRedact", "Comma separated hex INS bytes whose data transcripts leave out, * for all")
+	fs.IntVar(&c.APDULogMaxSize, "apduLogMaxSize", c.APDULogMaxSize, "Size in KB at which a transcript is rotated, 0 for no limit")
+	fs.IntVar(&c.APDULogKeep, "apduLogKeep", c.APDULogKeep, "Transcripts kept in the apduLog directory, 0 keeps all")
+	fs.StringVar(&c.LogLevel, "logLevel", c.LogLevel, "Log level: debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "logFormat", c.LogFormat, "Log format: text or json")
+	fs.BoolVar(&c.LogBodies, "logBodies", c.LogBodies, "Show packet bodies, APDUs included, in hex in debug logs")
+}
+
+// listFlag is a comma separated flag value.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// parseConfig reads the command line. With -config the file is loaded over
+// the defaults and the flags given explicitly are applied on top of it.
+func parseConfig(fs *flag.FlagSet, args []string) (Config, error) {
+	cfg := defaultConfig()
+	cfg.registerFlags(fs)
+	configFlag := fs.String("config", "", "YAML or JSON config file; flags override its values")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configFlag != "" {
+		set := map[string]string{}
+		fs.Visit(func(f *flag.Flag) {
+			set[f.Name] = f.Value.String()
+		})
+
+		if err := cfg.load(*configFlag); err != nil {
+			return cfg, err
+		}
+
+		for name, value := range set {
+			if err := fs.Set(name, value); err != nil {
+				return cfg, err
+			}
+		}
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (c *Config) load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("error opening config file %s %w", file, err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err = decoder.Decode(c); err != nil {
+		return fmt.Errorf("error reading config file %s %w", file, err)
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	if _, _, err := c.bindIP(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.checkBindInterface(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.BindPort < 0 || c.BindPort > 65535 {
+		errs = append(errs, fmt.Errorf("bindPort out of range: %d", c.BindPort))
+	}
+	if c.BufferSize < minBufferSize || c.BufferSize > 65507 {
+		errs = append(errs, fmt.Errorf("bufferSize must be between %d and 65507: %d", minBufferSize, c.BufferSize))
+	}
+	if c.UDPReadBuffer < 0 || c.UDPWriteBuffer < 0 {
+		errs = append(errs, fmt.Errorf("udpReadBuffer and udpWriteBuffer must not be negative: %d, %d", c.UDPReadBuffer, c.UDPWriteBuffer))
+	}
+	if (c.UDPReadBuffer > 0 || c.UDPWriteBuffer > 0) && (c.TLSCert != "" || c.TLSKey != "" || c.PSK != "") {
+		errs = append(errs, errors.New("udpReadBuffer and udpWriteBuffer are not supported with dtls"))
+	}
+	if c.DSCP < 0 || c.DSCP > localnet.MaxDSCP {
+		errs = append(errs, fmt.Errorf("dscp must be between 0 and %d: %d", localnet.MaxDSCP, c.DSCP))
+	}
+	if c.DSCP > 0 && (c.TLSCert != "" || c.TLSKey != "" || c.PSK != "") {
+		errs = append(errs, errors.New("dscp is not supported with dtls"))
+	}
+	if c.MaxAPDUSize < localnet.MinAPDUSize {
+		errs = append(errs, fmt.Errorf("maxAPDUSize must be at least %d: %d", localnet.MinAPDUSize, c.MaxAPDUSize))
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("timeout must be positive: %d", c.Timeout))
+	}
+	if c.MinTimeout <= 0 || c.MinTimeout > c.MaxTimeout {
+		errs = append(errs, fmt.Errorf("minTimeout must be positive and at most maxTimeout: %d", c.MinTimeout))
+	}
+	if c.Timeout > 0 && (c.Timeout < c.MinTimeout || c.Timeout > c.MaxTimeout) {
+		errs = append(errs, fmt.Errorf("timeout must be between minTimeout and maxTimeout: %d", c.Timeout))
+	}
+	if c.MaxSessionDuration < 0 {
+		errs = append(errs, fmt.Errorf("maxSessionDuration must not be negative: %d", c.MaxSessionDuration))
+	}
+	if c.CommandTimeout < 0 {
+		errs = append(errs, fmt.Errorf("commandTimeout must not be negative: %d", c.CommandTimeout))
+	}
+	if c.ConnectTimeout < 0 {
+		errs = append(errs, fmt.Errorf("connectTimeout must not be negative: %d", c.ConnectTimeout))
+	}
+	if c.OpenRetries < 0 {
+		errs = append(errs, fmt.Errorf("openRetries must not be negative: %d", c.OpenRetries))
+	}
+	if c.MaxChannels < 0 || c.MaxChannels > localnet.MaxLogicalChannel {
+		errs = append(errs, fmt.Errorf("maxChannels must be between 0 and %d: %d", localnet.MaxLogicalChannel, c.MaxChannels))
+	}
+	if c.DrainTimeout < 0 {
+		errs = append(errs, fmt.Errorf("drainTimeout must not be negative: %d", c.DrainTimeout))
+	}
+	if c.SessionGrace < 0 {
+		errs = append(errs, fmt.Errorf("sessionGrace must not be negative: %d", c.SessionGrace))
+	}
+	if c.ReadDeadline < 0 {
+		errs = append(errs, fmt.Errorf("readDeadline must not be negative: %d", c.ReadDeadline))
+	}
+	if c.EventInterval < 0 {
+		errs = append(errs, fmt.Errorf("eventInterval must not be negative: %d", c.EventInterval))
+	}
+	if c.CardKeepAlive < 0 {
+		errs = append(errs, fmt.Errorf("cardKeepAlive must not be negative: %d", c.CardKeepAlive))
+	}
+	transports := c.transports()
+	if len(transports) == 0 {
+		errs = append(errs, errors.New("transport must name at least one of udp, tcp and stdio"))
+	}
+	for _, transport := range transports {
+		if transport != "udp" && transport != "tcp" && transport != "stdio" {
+			errs = append(errs, fmt.Errorf("unsupported transport: %s", transport))
+		}
+	}
+	if slices.Contains(transports, "tcp") && (c.TLSCert != "" || c.TLSKey != "" || c.PSK != "") {
+		errs = append(errs, errors.New("dtls cannot be combined with the tcp transport, which is unencrypted"))
+	}
+	if c.MaxDecompressedSize < c.MaxAPDUSize {
+		errs = append(errs, fmt.Errorf("maxDecompressedSize must be at least maxAPDUSize: %d", c.MaxDecompressedSize))
+	}
+	if c.ResponseCache < 0 {
+		errs = append(errs, fmt.Errorf("responseCache must not be negative: %d", c.ResponseCache))
+	}
+	for _, pattern := range slices.Concat(c.AllowProtos, c.AllowDevices, c.WSOrigins) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid pattern %q %w", pattern, err))
+		}
+	}
+	if _, err := parseCIDRs(c.AllowCIDR); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.socketFileMode(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rateLimit must not be negative: %g", c.RateLimit))
+	}
+	if c.RateLimit > 0 && c.RateBurst < 1 {
+		errs = append(errs, fmt.Errorf("rateBurst must be at least 1: %d", c.RateBurst))
+	}
+	if _, err := parseRedactSet(c.APDULogRedact); err != nil {
+		errs = append(errs, err)
+	}
+	if c.APDULogMaxSize < 0 {
+		errs = append(errs, fmt.Errorf("apduLogMaxSize must not be negative: %d", c.APDULogMaxSize))
+	}
+	if c.APDULogKeep < 0 {
+		errs = append(errs, fmt.Errorf("apduLogKeep must not be negative: %d", c.APDULogKeep))
+	}
+	if _, err := c.logLevel(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("unsupported logFormat, expected text or json: %s", c.LogFormat))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) logLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("invalid logLevel, expected debug, info, warn or error: %s", c.LogLevel)
+	}
+	return level, nil
+}
+
+// bindIP parses BindAddr, which takes IPv6 literals with or without
+// brackets and with a zone, such as [fe80::1%eth0].
+func (c *Config) bindIP() (net.IP, string, error) {
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(c.BindAddr, "["), "]"))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid bindAddr: %s", c.BindAddr)
+	}
+
+	switch {
+	case c.IPVersion == "4" && !addr.Unmap().Is4():
+		return nil, "", fmt.Errorf("bindAddr %s is not an IPv4 address, as ipVersion 4 requires", c.BindAddr)
+	case c.IPVersion == "6" && !addr.Is6():
+		return nil, "", fmt.Errorf("bindAddr %s is not an IPv6 address, as ipVersion 6 requires", c.BindAddr)
+	case c.IPVersion != "dual" && c.IPVersion != "4" && c.IPVersion != "6":
+		return nil, "", fmt.Errorf("unsupported ipVersion, expected dual, 4 or 6: %s", c.IPVersion)
+	}
+	return net.IP(addr.WithZone("").AsSlice()), addr.Zone(), nil
+}
+
+// checkBindInterface makes sure BindInterface exists and, unless BindAddr
+// is a wildcard, carries BindAddr: a socket pinned to one interface never
+// sees packets for an address of another.
+func (c *Config) checkBindInterface() error {
+	if c.BindInterface == "" {
+		return nil
+	}
+	if c.TLSCert != "" || c.TLSKey != "" || c.PSK != "" {
+		return errors.New("bindInterface is not supported with dtls")
+	}
+	iface, err := net.InterfaceByName(c.BindInterface)
+	if err != nil {
+		return fmt.Errorf("invalid bindInterface %s %w", c.BindInterface, err)
+	}
+	ip, _, err := c.bindIP()
+	if err != nil || ip.IsUnspecified() {
+		return nil
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return fmt.Errorf("failed to list addresses of %s %w", c.BindInterface, err)
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("bindAddr %s is not an address of bindInterface %s", c.BindAddr, c.BindInterface)
+}
+
+// transports lists the transports named by Transport, each once.
+func (c *Config) transports() []string {
+	var transports []string
+	for _, transport := range strings.Split(c.Transport, ",") {
+		if transport = strings.TrimSpace(transport); transport != "" && !slices.Contains(transports, transport) {
+			transports = append(transports, transport)
+		}
+	}
+	return transports
+}
+
+// network returns the network name for base, "udp" or "tcp", restricted to
+// the configured IP version. A wildcard address on the dual networks
+// accepts IPv4 and IPv6 clients alike.
+func (c *Config) network(base string) string {
+	switch c.IPVersion {
+	case "4", "6":
+		return base + c.IPVersion
+	}
+	return base
+}
+
+func (c *Config) socketFileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(c.SocketMode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid socketMode, expected octal: %s", c.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
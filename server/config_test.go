@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestConfigNetwork(t *testing.T) {
+	for _, tt := range []struct {
+		ipVersion string
+		udp, tcp  string
+	}{
+		{"dual", "udp", "tcp"},
+		{"4", "udp4", "tcp4"},
+		{"6", "udp6", "tcp6"},
+	} {
+		cfg := Config{IPVersion: tt.ipVersion}
+		if got := cfg.network("udp"); got != tt.udp {
+			t.Errorf("ipVersion %s: network(udp) = %s, want %s", tt.ipVersion, got, tt.udp)
+		}
+		if got := cfg.network("tcp"); got != tt.tcp {
+			t.Errorf("ipVersion %s: network(tcp) = %s, want %s", tt.ipVersion, got, tt.tcp)
+		}
+	}
+}
+
+func TestConfigBindIP(t *testing.T) {
+	tests := []struct {
+		bindAddr  string
+		ipVersion string
+		ip        string
+		zone      string
+		ok        bool
+	}{
+		{"0.0.0.0", "dual", "0.0.0.0", "", true},
+		{"::", "dual", "::", "", true},
+		{"[::1]", "6", "::1", "", true},
+		{"fe80::1%eth0", "6", "fe80::1", "eth0", true},
+		{"192.0.2.1", "4", "192.0.2.1", "", true},
+		{"::ffff:192.0.2.1", "4", "192.0.2.1", "", true},
+		{"192.0.2.1", "6", "", "", false},
+		{"::1", "4", "", "", false},
+		{"::1", "5", "", "", false},
+		{"localhost", "dual", "", "", false},
+	}
+	for _, tt := range tests {
+		cfg := Config{BindAddr: tt.bindAddr, IPVersion: tt.ipVersion}
+		ip, zone, err := cfg.bindIP()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s with ipVersion %s: error %v, want ok %v", tt.bindAddr, tt.ipVersion, err, tt.ok)
+			continue
+		}
+		if tt.ok && (ip.String() != tt.ip || zone != tt.zone) {
+			t.Errorf("%s with ipVersion %s: got %s zone %q, want %s zone %q", tt.bindAddr, tt.ipVersion, ip, zone, tt.ip, tt.zone)
+		}
+	}
+}
+
+func TestParseConfigIPVersion(t *testing.T) {
+	cfg, err := parseConfig(flag.NewFlagSet("server", flag.ContinueOnError), []string{"-bindAddr", "::1", "-ipVersion", "6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.network("udp") != "udp6" {
+		t.Fatalf("network(udp) = %s, want udp6", cfg.network("udp"))
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// connectChannel opens a logical channel on aid for a PacketConnectAID;
+// callers hold the device lock. With reuse, a session that already has a
+// channel open on aid, as a resumed or restored one may, hands that back
+// instead of opening another.
+func connectChannel(session *Session, aid []byte, reuse bool) (byte, error) {
+	if reuse {
+		sessionsMu.RLock()
+		for _, channel := range slices.Backward(session.LogicalChannels) {
+			if bytes.Equal(session.channelAIDs[channel], aid) {
+				sessionsMu.RUnlock()
+				return channel, nil
+			}
+		}
+		sessionsMu.RUnlock()
+	}
+
+	channel, err := openLogicalChannel(session, aid)
+	if err != nil {
+		return localnet.InvalidChannel, fmt.Errorf("open logical channel on connect: %w", err)
+	}
+	if err = localnet.CheckChannel(channel); err != nil {
+		slog.Error("driver opened an invalid logical channel", "device", session.Device, "channel", channel)
+		return localnet.InvalidChannel, withCode(localnet.ErrCodeInternal, fmt.Errorf("driver returned %w", err))
+	}
+	session.transcript.note("opened logical channel %d aid=%X on connect", channel, aid)
+	session.addLogicalChannel(channel, aid)
+
+	slog.Debug("logical channel opened", "channel", channel, "aid", fmt.Sprintf("%X", aid))
+	return channel, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"container/list"
+	"net"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+const defaultResponseCacheSize = 16
+
+var responseCacheSize = defaultResponseCacheSize
+
+// responseCache remembers the most recent responses of a session by request
+// ID, so a request retransmitted after a lost reply is answered without
+// running it on the card a second time.
+type responseCache struct {
+	size    int
+	order   *list.List
+	entries map[uint64]*list.Element
+}
+
+type cachedResponse struct {
+	requestID uint64
+	response  localnet.IPacketCmd
+}
+
+func newResponseCache(size int) *responseCache {
+	return &responseCache{size: size, order: list.New(), entries: make(map[uint64]*list.Element)}
+}
+
+func (c *responseCache) get(requestID uint64) (localnet.IPacketCmd, bool) {
+	elem, ok := c.entries[requestID]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cachedResponse).response, true
+}
+
+func (c *responseCache) put(requestID uint64, response localnet.IPacketCmd) {
+	if c.size <= 0 {
+		return
+	}
+	if elem, ok := c.entries[requestID]; ok {
+		elem.Value.(*cachedResponse).response = response
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[requestID] = c.order.PushFront(&cachedResponse{requestID, response})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).requestID)
+	}
+}
+
+// replayable reports whether the response to pcRcv belongs in its session's
+// cache. Connect and disconnect create and destroy the session itself.
+func replayable(pcRcv localnet.IPacketCmd) bool {
+	if pcRcv.GetRequestID() == 0 {
+		return false
+	}
+	switch pcRcv.GetCmd() {
+	case localnet.CmdConnect, localnet.CmdConnectProof, localnet.CmdDisconnect, localnet.CmdListSlots, localnet.CmdStatus, localnet.CmdCapabilities, localnet.CmdAbort, localnet.CmdAdminKick, localnet.CmdHealth, localnet.CmdListProtocols:
+		return false
+	}
+	return true
+}
+
+func cachedReply(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	if !replayable(pcRcv) {
+		return nil
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	session, err := lookupSession(pcRcv, remoteAddr)
+	if err != nil {
+		return nil
+	}
+	response, _ := session.responses.get(pcRcv.GetRequestID())
+	return response
+}
+
+func cacheReply(pcRcv localnet.IPacketCmd, remoteAddr net.Addr, pcSnd localnet.IPacketCmd) {
+	if !replayable(pcRcv) {
+		return
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	if session, err := lookupSession(pcRcv, remoteAddr); err == nil {
+		session.responses.put(pcRcv.GetRequestID(), pcSnd)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// drainTimeout is how long shutdown waits for commands in flight, so that a
+// transmit is not cut off halfway through a profile operation.
+var drainTimeout time.Duration
+
+// inFlight counts the commands being handled and refuses new ones once
+// draining started.
+var inFlight drainer
+
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	active   int
+	idle     chan struct{}
+}
+
+// enter registers a command, or reports false when the server is draining.
+func (d *drainer) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.active++
+	return true
+}
+
+// hold registers card I/O that outlives its command, as a timed out one
+// does; callers are inside a command, so draining cannot have finished.
+func (d *drainer) hold() {
+	d.mu.Lock()
+	d.active++
+	d.mu.Unlock()
+}
+
+func (d *drainer) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.active--
+	if d.active == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// drain refuses new commands and waits up to timeout for the running ones
+// to finish. It reports whether they did.
+func (d *drainer) drain(timeout time.Duration) bool {
+	started := time.Now()
+
+	d.mu.Lock()
+	d.draining = true
+	active := d.active
+	var idle chan struct{}
+	if active > 0 {
+		d.idle = make(chan struct{})
+		idle = d.idle
+	}
+	d.mu.Unlock()
+
+	if idle == nil {
+		slog.Info("drain complete", "duration", time.Since(started))
+		return true
+	}
+
+	slog.Info("draining commands in flight", "commands", active, "timeout", timeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-idle:
+		slog.Info("drain complete", "duration", time.Since(started))
+		return true
+	case <-timer.C:
+		d.mu.Lock()
+		active = d.active
+		d.mu.Unlock()
+		slog.Warn("drain timed out", "duration", time.Since(started), "commands", active)
+		return false
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/avwarez/euicc-go/driver"
+	_ "github.com/avwarez/euicc-go/driver/mock"
+	_ "github.com/avwarez/euicc-go/driver/pcsc"
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver/at"
+	"github.com/damonto/euicc-go/driver/mbim"
+	"github.com/damonto/euicc-go/driver/qmi"
+)
+
+// The modem drivers live upstream and cannot register themselves, so the
+// server does it for them. Other drivers register in their own init and only
+// need to be imported. AT commands reach the card of the active slot only.
+// The upstream drivers number slots with a byte.
+func init() {
+	driver.RegisterDriver("at", func(device string, slot uint16) (apdu.SmartCardChannel, error) {
+		return at.New(device)
+	})
+	driver.RegisterSlotDriver("mbim", func(device string, slot uint16) (apdu.SmartCardChannel, error) {
+		s, err := driver.ByteSlot(slot)
+		if err != nil {
+			return nil, err
+		}
+		return mbim.New(device, s)
+	})
+	driver.RegisterSlotDriver("qmi", func(device string, slot uint16) (apdu.SmartCardChannel, error) {
+		s, err := driver.ByteSlot(slot)
+		if err != nil {
+			return nil, err
+		}
+		return qmi.New(device, s)
+	})
+	driver.RegisterSlotDriver("qrtr", func(device string, slot uint16) (apdu.SmartCardChannel, error) {
+		s, err := driver.ByteSlot(slot)
+		if err != nil {
+			return nil, err
+		}
+		return qmi.NewQRTR(s)
+	})
+
+	driver.RegisterCheck("at", deviceNodes("/dev/ttyUSB*", "/dev/ttyACM*"))
+	driver.RegisterCheck("mbim", deviceNodes("/dev/cdc-wdm*"))
+	driver.RegisterCheck("qmi", deviceNodes("/dev/cdc-wdm*"))
+	driver.RegisterCheck("qrtr", qrtrSupported)
+}
+
+// deviceNodes returns a check failing unless a device node matches one of
+// patterns, the kind of node the driver opens.
+func deviceNodes(patterns ...string) driver.Check {
+	return func() error {
+		for _, pattern := range patterns {
+			if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+				return nil
+			}
+		}
+		return fmt.Errorf("no device matching %s", strings.Join(patterns, " or "))
+	}
+}
+
+// afQIPCRTR is AF_QIPCRTR, the address family of the Qualcomm IPC router.
+const afQIPCRTR = 42
+
+// qrtrSupported fails unless the kernel can open a QRTR socket, which needs
+// CONFIG_QRTR and a Qualcomm modem wired to the SoC.
+func qrtrSupported() error {
+	fd, err := syscall.Socket(afQIPCRTR, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return fmt.Errorf("qrtr socket unavailable: %w", err)
+	}
+	syscall.Close(fd)
+	return nil
+}
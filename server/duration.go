@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// maxSessionDuration, when positive, ends a session that has lasted this
+// long however busy it is, so one client cannot hold a shared modem forever.
+var maxSessionDuration time.Duration
+
+// errSessionOverdue answers the commands of a session ended for lasting
+// longer than maxSessionDuration.
+var errSessionOverdue = errors.New("session exceeded maximum duration")
+
+// overdueSessions remembers when sessions were ended for their age, keyed
+// by overdueKey, so that the client's next command is told why rather than
+// getting "invalid session token". Entries are kept for maxSessionTimeout,
+// the longest a client may stay idle. Guarded by sessionsMu.
+var overdueSessions = make(map[string]time.Time)
+
+func (s *Session) overdue() bool {
+	return maxSessionDuration > 0 && time.Since(s.StartedAt) > maxSessionDuration
+}
+
+// overdueKey identifies a session the way its client's later packets do: by
+// session token, or by address for legacy clients.
+func overdueKey(token string, remoteAddr net.Addr) string {
+	if token != "" {
+		return token
+	}
+	return "addr:" + addrKey(remoteAddr)
+}
+
+func sessionKey(session *Session) string {
+	if session.tokenBound() {
+		return overdueKey(session.ID, session.RemoteAddr)
+	}
+	return overdueKey("", session.RemoteAddr)
+}
+
+// markOverdue records that session was ended for its age; callers hold
+// sessionsMu for writing.
+func markOverdue(session *Session) {
+	overdueSessions[sessionKey(session)] = time.Now()
+}
+
+// wasOverdue reports whether the session a packet names was ended for its
+// age; callers hold sessionsMu.
+func wasOverdue(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) bool {
+	_, ok := overdueSessions[overdueKey(pcRcv.GetSessionToken(), remoteAddr)]
+	return ok
+}
+
+// pruneOverdue forgets overdue sessions whose client has had time enough
+// to come back.
+func pruneOverdue() {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for key, at := range overdueSessions {
+		if time.Since(at) > maxSessionTimeout {
+			delete(overdueSessions, key)
+		}
+	}
+}
+
+// dropOverdue ends every session older than maxSessionDuration.
+func dropOverdue() {
+	dropped := dropSessions((*Session).overdue)
+
+	sessionsMu.Lock()
+	for _, session := range dropped {
+		markOverdue(session)
+	}
+	sessionsMu.Unlock()
+
+	for _, session := range dropped {
+		slog.Warn("ended session at maximum duration",
+			"client", session.RemoteAddr,
+			"device", session.Device,
+			"duration", time.Since(session.StartedAt))
+	}
+	pruneOverdue()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+	"github.com/avwarez/euicc-go/driver/tlv"
+)
+
+// getEIDData is the GetEUICCData request for the EID: tag list 5A.
+var getEIDData = []byte{0xBF, 0x3E, 0x03, 0x5C, 0x01, 0x5A}
+
+func handleGetEID(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
+	}
+	defer func() { unlock() }()
+
+	var eid []byte
+	if terr := callCard(session, timeoutFor(pcRcv), &unlock, func() {
+		eid, err = readEID(session)
+	}); terr != nil {
+		return errorReply(terr)
+	}
+	if err != nil {
+		slog.Warn("get eid failed", "device", session.Device, "error", err)
+		return errorReply(endIfGone(session, err))
+	}
+
+	session.touch()
+
+	slog.Debug("eid read", "device", session.Device, "eid", fmt.Sprintf("%X", eid))
+
+	return localnet.NewPacketBody(localnet.CmdResponse, eid)
+}
+
+// readEID asks the ISD-R for the EID.
+func readEID(session *Session) ([]byte, error) {
+	data, err := isdrStoreData(session, "get eid", getEIDData)
+	if err != nil {
+		return nil, fmt.Errorf("GetEUICCData failed: %w", err)
+	}
+	return parseEID(data)
+}
+
+// isdrStoreData selects the ISD-R on a channel of its own, sends request in
+// a single STORE DATA and returns the response data; what names the request
+// in logs. Cards refusing another channel get the request on the session's
+// latest open channel instead, which an LPA has usually opened on the
+// ISD-R.
+func isdrStoreData(session *Session, what string, request []byte) ([]byte, error) {
+	channel, err := session.Channel.OpenLogicalChannel(localnet.ISDRAID)
+	switch {
+	case err == nil:
+		session.transcript.note("opened logical channel %d aid=%X for %s", channel, localnet.ISDRAID, what)
+		defer func() {
+			if err := session.Channel.CloseLogicalChannel(channel); err != nil {
+				slog.Warn("failed to close isd-r channel", "channel", channel, "for", what, "error", err)
+			}
+			session.transcript.note("closed logical channel %d", channel)
+		}()
+	case session.lastLogicalChannel() != localnet.InvalidChannel:
+		slog.Debug("cannot open isd-r channel, using the session's", "for", what, "error", err)
+		channel = session.lastLogicalChannel()
+	default:
+		return nil, fmt.Errorf("cannot select ISD-R: %w", err)
+	}
+
+	command := append([]byte{channelCLA(0x80, channel), 0xE2, 0x91, 0x00, byte(len(request))}, request...)
+	return transmitCollect(session, command)
+}
+
+// transmitCollect sends command and gathers the response data over any
+// GET RESPONSE the card asks for. It fails unless the card ends with 9000.
+func transmitCollect(session *Session, command []byte) ([]byte, error) {
+	var data []byte
+	for range 16 {
+		started := time.Now()
+		session.transcript.command(command)
+		response, err := session.Channel.Transmit(command)
+		session.transcript.response(command, response, err, time.Since(started))
+		if err != nil {
+			return nil, err
+		}
+		more, sw, err := localnet.SplitStatusWord(response)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, more...)
+
+		switch {
+		case sw == localnet.SWSuccess:
+			return data, nil
+		case sw&0xFF00 == localnet.SWMoreData:
+			command = []byte{command[0] &^ 0x80, 0xC0, 0x00, 0x00, byte(sw)}
+		default:
+			return nil, fmt.Errorf("card returned status %04X", sw)
+		}
+	}
+	return nil, errors.New("card still has data after 16 GET RESPONSE")
+}
+
+// parseEID extracts the EID from a GetEUICCData response:
+// BF3E 12 5A 10 <EID>.
+func parseEID(data []byte) ([]byte, error) {
+	tlvs, err := tlv.Parse(data)
+	if err != nil || len(tlvs) != 1 || tlvs[0].Tag != 0xBF3E {
+		return nil, fmt.Errorf("malformed GetEUICCData response: %X", data)
+	}
+	eid := tlvs[0].Find(0x5A)
+	if eid == nil || len(eid.Value) != localnet.EIDLength {
+		return nil, fmt.Errorf("no EID in GetEUICCData response: %X", data)
+	}
+	return eid.Value, nil
+}
+
+// channelCLA encodes a logical channel into an interindustry class byte:
+// channels 0 to 3 in the low bits, 4 to 19 in the further interindustry
+// form.
+func channelCLA(cla byte, channel byte) byte {
+	if channel < 4 {
+		return cla | channel
+	}
+	return cla | 0x40 | (channel-4)&0x0F
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// codedError is an error answered with code rather than the one errorCode
+// would derive.
+type codedError struct {
+	code localnet.ErrCode
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+func withCode(code localnet.ErrCode, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+// errorCode classifies err for the client.
+func errorCode(err error) localnet.ErrCode {
+	var ce *codedError
+	switch {
+	case errors.As(err, &ce):
+		return ce.code
+	case errors.Is(err, errCommandAborted):
+		return localnet.ErrCodeAborted
+	case errors.Is(err, errCommandTimedOut), errors.Is(err, errConnectTimedOut):
+		return localnet.ErrCodeTimeout
+	case errors.Is(err, errSessionOverdue):
+		return localnet.ErrCodeExpired
+	case driver.IsDeviceGone(err):
+		return localnet.ErrCodeDeviceGone
+	case errors.Is(err, driver.ErrUnsupportedProtocol):
+		return localnet.ErrCodeUnsupportedProto
+	case errors.Is(err, localnet.ErrInvalidAID),
+		errors.Is(err, localnet.ErrInvalidChannel),
+		errors.Is(err, localnet.ErrTooManyChannels),
+		errors.Is(err, localnet.ErrAPDUTooShort),
+		errors.Is(err, localnet.ErrAPDUTooLarge),
+		errors.Is(err, localnet.ErrPayloadTooLarge),
+		errors.Is(err, driver.ErrSlotOutOfRange):
+		return localnet.ErrCodeInvalidRequest
+	}
+	return localnet.ErrCodeInternal
+}
+
+// errorReply answers a command with err.
+func errorReply(err error) localnet.IPacketCmd {
+	return localnet.NewPacketErr(errorCode(err), err.Error())
+}
+
+// peerVersion returns the protocol version the sender of pcRcv speaks, taken
+// from the packet for connects and session-less commands, and from its
+// session otherwise. Senders without a session count as legacy.
+func peerVersion(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) uint16 {
+	if pcConn, ok := pcRcv.(localnet.IPacketConnect); ok {
+		return pcConn.GetProtocolVersion()
+	}
+	// only clients that answer connect challenges send proofs
+	if _, ok := pcRcv.(localnet.IPacketConnectProof); ok {
+		return localnet.ProtocolVersion6
+	}
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	if session, err := lookupSession(pcRcv, remoteAddr); err == nil {
+		return session.ProtocolVersion
+	}
+	return localnet.ProtocolVersionLegacy
+}
+
+// downgradeError strips the code from an error reply to a client older than
+// ProtocolVersion4, which could not decode a PacketErr.
+func downgradeError(pcSnd localnet.IPacketCmd, version uint16) localnet.IPacketCmd {
+	if _, ok := pcSnd.(localnet.IPacketErr); !ok || version >= localnet.ProtocolVersion4 {
+		return pcSnd
+	}
+	return localnet.NewPacketCmdErr(pcSnd.GetCmd(), pcSnd.GetErr())
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// eventInterval is how often watched devices are polled for card insertion
+// and removal, 0 to disable events.
+var eventInterval time.Duration
+
+// subscriber receives the events of one device until expires, which each
+// renewal of the subscription pushes back. Subscribers are keyed by address,
+// since UDP clients have no connection to tie them to.
+type subscriber struct {
+	remoteAddr net.Addr
+	proto      string
+	device     string
+	push       func(localnet.IPacketCmd) error
+	expires    time.Time
+}
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   = make(map[string]*subscriber)
+)
+
+func handleSubscribe(pcRcv localnet.IPacketCmd, remoteAddr net.Addr, push func(localnet.IPacketCmd) error) localnet.IPacketCmd {
+	if eventInterval == 0 {
+		return localnet.NewPacketErr(localnet.ErrCodeUnavailable, "events disabled on this server")
+	}
+
+	pcConn, ok := pcRcv.(localnet.IPacketConnect)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type for subscribe")
+	}
+
+	if !authTokenAllowed(pcConn.GetAuthToken()) {
+		slog.Warn("rejecting subscribe with invalid auth token", "client", remoteAddr)
+		return localnet.NewPacketErr(localnet.ErrCodeUnauthorized, "invalid auth token")
+	}
+
+	if err := checkAllowed(pcConn.GetProto(), pcConn.GetDevice(), remoteAddr); err != nil {
+		return errorReply(err)
+	}
+
+	if pcConn.GetProto() != "qmi" && pcConn.GetProto() != "qrtr" {
+		return localnet.NewPacketErr(localnet.ErrCodeUnsupportedProto, fmt.Sprintf("events not supported for protocol: %s", pcConn.GetProto()))
+	}
+
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	key := addrKey(remoteAddr)
+	if _, ok := subscribers[key]; !ok {
+		slog.Info("client subscribed to events", "client", remoteAddr, "device", pcConn.GetDevice())
+	}
+	subscribers[key] = &subscriber{
+		remoteAddr: remoteAddr,
+		proto:      pcConn.GetProto(),
+		device:     pcConn.GetDevice(),
+		push:       push,
+		expires:    time.Now().Add(localnet.EventSubscriptionTTL),
+	}
+
+	return localnet.NewPacketCmd(localnet.CmdResponse)
+}
+
+// dropSubscriber ends the subscription of a stream connection that went
+// away.
+func dropSubscriber(remoteAddr net.Addr) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	delete(subscribers, addrKey(remoteAddr))
+}
+
+// watchEvents polls the slots of every device with a subscriber and pushes
+// an event for each card that came or went since the previous poll. The
+// drivers offer no slot change notification, so polling it is.
+func watchEvents(ctx context.Context) {
+	ticker := time.NewTicker(eventInterval)
+	defer ticker.Stop()
+
+	last := make(map[string][]localnet.SlotInfo)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pollEvents(last)
+		}
+	}
+}
+
+func pollEvents(last map[string][]localnet.SlotInfo) {
+	type watched struct {
+		proto       string
+		device      string
+		subscribers []*subscriber
+	}
+
+	subscribersMu.Lock()
+	devices := make(map[string]*watched)
+	for key, sub := range subscribers {
+		if time.Now().After(sub.expires) {
+			slog.Info("event subscription expired", "client", sub.remoteAddr)
+			delete(subscribers, key)
+			continue
+		}
+		device := deviceKey(sub.proto, sub.device)
+		if devices[device] == nil {
+			devices[device] = &watched{proto: sub.proto, device: sub.device}
+		}
+		devices[device].subscribers = append(devices[device].subscribers, sub)
+	}
+	subscribersMu.Unlock()
+
+	for device := range last {
+		if devices[device] == nil {
+			delete(last, device)
+		}
+	}
+
+	for device, w := range devices {
+		unlock := deviceLocks.lock(device)
+		slots, err := listSlots(w.proto, w.device)
+		unlock()
+		if err != nil {
+			slog.Debug("event poll failed", "device", device, "error", err)
+			continue
+		}
+
+		previous, seen := last[device]
+		last[device] = slots
+		if !seen {
+			continue
+		}
+
+		now := time.Now()
+		for _, change := range slotChanges(previous, slots) {
+			slog.Info("card state changed", "device", device, "slot", change.Slot, "inserted", change.CardPresent)
+			event := localnet.NewPacketEvent(change.Slot, change.CardPresent, now)
+			for _, sub := range w.subscribers {
+				if err = sub.push(event); err != nil {
+					slog.Warn("failed to push event", "client", sub.remoteAddr, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// slotChanges returns the slots of current whose card presence differs from
+// previous; a slot that appeared counts as changed when it holds a card.
+func slotChanges(previous []localnet.SlotInfo, current []localnet.SlotInfo) []localnet.SlotInfo {
+	present := make(map[uint16]bool, len(previous))
+	for _, slot := range previous {
+		present[slot.Slot] = slot.CardPresent
+	}
+
+	var changes []localnet.SlotInfo
+	for _, slot := range current {
+		if present[slot.Slot] != slot.CardPresent {
+			changes = append(changes, slot)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// autoGetResponse makes transmits follow a 61xx status word with GET
+// RESPONSE on the server, so the client gets the data of the whole chain
+// with the final status word. It is off by default, as clients chaining
+// themselves expect the raw 61xx.
+var autoGetResponse bool
+
+// maxGetResponses bounds the GET RESPONSE chain of one transmit, as
+// TransmitFull does on the client.
+const maxGetResponses = 256
+
+// transmitCard sends command to the session's card, with its transcript
+// entries; callers hold the device lock. With -autoGetResponse it also
+// fetches the data the card holds back.
+func transmitCard(session *Session, command []byte) ([]byte, error) {
+	response, err := transmitLogged(session, command)
+	if err != nil || !autoGetResponse {
+		return response, err
+	}
+
+	var data []byte
+	for i := 0; ; i++ {
+		more, sw, serr := localnet.SplitStatusWord(response)
+		if serr != nil || sw&0xFF00 != localnet.SWMoreData {
+			return append(data, response...), nil
+		}
+		if i == maxGetResponses {
+			return nil, fmt.Errorf("card still has data after %d GET RESPONSE", maxGetResponses)
+		}
+		data = append(data, more...)
+
+		// GET RESPONSE goes to the channel of the command, in the
+		// interindustry class
+		getResponse := []byte{command[0] &^ 0x80, 0xC0, 0x00, 0x00, byte(sw)}
+		if response, err = transmitLogged(session, getResponse); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// transmitLogged sends one APDU and records it in the transcript.
+func transmitLogged(session *Session, command []byte) ([]byte, error) {
+	started := time.Now()
+	session.transcript.command(command)
+	response, err := session.Channel.Transmit(command)
+	session.transcript.response(command, response, err, time.Since(started))
+	return response, err
+}
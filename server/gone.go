@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/avwarez/euicc-go/driver"
+)
+
+// endIfGone closes the session when err says its device went away for good,
+// an unplugged modem say, and returns the error to answer with. The answer
+// starts with "device gone", which clients report as ErrDeviceGone instead
+// of retrying a channel that will never work again. Other errors are
+// returned unchanged. Callers hold the device lock.
+func endIfGone(session *Session, err error) error {
+	if !driver.IsDeviceGone(err) {
+		return err
+	}
+	slog.Error("device gone, closing session", "client", session.RemoteAddr, "device", session.Device, "error", err)
+
+	sessionsMu.Lock()
+	if sessions[session.ID] == session {
+		detachSession(session)
+	}
+	// nothing is left to close them on
+	session.LogicalChannels = nil
+	session.channelAIDs = nil
+	sessionsMu.Unlock()
+	releaseChannel(session)
+
+	return fmt.Errorf("device gone, session closed: %w", err)
+}
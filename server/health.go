@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// handleHealth answers a liveness probe. It needs neither session nor
+// token, never waits for a device lock, so a long transmit on another
+// session cannot delay it, and never touches a card. A probe naming a
+// device also learns whether a client could connect to it now.
+func handleHealth(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	health := localnet.Health{Status: localnet.HealthOK}
+
+	if pcConn, ok := pcRcv.(localnet.IPacketConnect); ok && pcConn.GetDevice() != "" {
+		health.Device = pcConn.GetDevice()
+		available := deviceAvailable(pcConn.GetProto(), pcConn.GetDevice())
+		health.DeviceAvailable = &available
+	}
+
+	body, err := json.Marshal(health)
+	if err != nil {
+		return errorReply(err)
+	}
+	return localnet.NewPacketBody(localnet.CmdResponse, body)
+}
+
+// deviceAvailable reports whether a connect to device would be let through
+// the allow-lists and find no live session holding it, or, with
+// -allowConcurrent, sessions it may share. It only consults the
+// session table, and unlike checkAllowed does not log refusals, which
+// monitoring would repeat every few seconds.
+func deviceAvailable(proto string, device string) bool {
+	if !matchesAny(allowedProtos, proto) || proto != "qrtr" && !matchesAny(allowedDevices, device) {
+		return false
+	}
+
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	session := sessionForDevice(deviceKey(proto, device))
+	return session == nil || allowConcurrent || session.expired() || session.overdue()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// adminToken is the only token accepted for admin commands. Without it they
+// are refused, since the client tokens would let any client kick another.
+var adminToken string
+
+func adminTokenAllowed(token string) bool {
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(adminToken), []byte(token)) == 1
+}
+
+// handleAdminKick ends the session holding a device, for an operator to
+// free a modem from a stuck client without restarting the server. The
+// packet names the device like a connect; without one the only session is
+// kicked. A command the session is running is aborted first so the device
+// lock comes free. The answer carries the kicked client's address, or the
+// addresses separated by commas when sessions shared the device.
+func handleAdminKick(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	pcConn, ok := pcRcv.(localnet.IPacketConnect)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type for kick")
+	}
+
+	if adminToken == "" {
+		slog.Warn("rejecting kick, admin commands need -adminToken", "client", remoteAddr)
+		return localnet.NewPacketErr(localnet.ErrCodeUnauthorized, "admin commands disabled, server has no admin token")
+	}
+	if !adminTokenAllowed(pcConn.GetAuthToken()) {
+		slog.Warn("rejecting kick with invalid admin token", "client", remoteAddr)
+		return localnet.NewPacketErr(localnet.ErrCodeUnauthorized, "invalid admin token")
+	}
+
+	sessionsMu.RLock()
+	targets, err := kickTargets(pcConn.GetProto(), pcConn.GetDevice())
+	sessionsMu.RUnlock()
+	if err != nil {
+		return errorReply(err)
+	}
+
+	for _, target := range targets {
+		target.abortCommand()
+	}
+	kicked := dropSessions(func(session *Session) bool { return slices.Contains(targets, session) })
+	if len(kicked) == 0 {
+		return localnet.NewPacketErr(localnet.ErrCodeNotFound, "session already ended")
+	}
+
+	addrs := make([]string, 0, len(kicked))
+	for _, target := range kicked {
+		slog.Warn("admin kicked session",
+			"admin", remoteAddr,
+			"client", target.RemoteAddr,
+			"device", target.Device,
+			"duration", time.Since(target.StartedAt))
+		addrs = append(addrs, target.RemoteAddr.String())
+	}
+	return localnet.NewPacketBody(localnet.CmdResponse, []byte(strings.Join(addrs, ",")))
+}
+
+// kickTargets finds the sessions on device, several only with
+// -allowConcurrent, or the only session when device is empty; callers hold
+// sessionsMu.
+func kickTargets(proto string, device string) ([]*Session, error) {
+	if device != "" {
+		if found := sessionsForDevice(deviceKey(proto, device)); len(found) > 0 {
+			return found, nil
+		}
+		return nil, withCode(localnet.ErrCodeNotFound, errors.New("no session on device "+device))
+	}
+
+	switch len(sessions) {
+	case 0:
+		return nil, withCode(localnet.ErrCodeNotFound, errors.New("no active session"))
+	case 1:
+		for _, session := range sessions {
+			return []*Session{session}, nil
+		}
+	}
+	return nil, withCode(localnet.ErrCodeInvalidRequest, errors.New("several sessions open, name the device"))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// kick sends an admin kick for device presenting token.
+func kick(device string, token string) localnet.IPacketCmd {
+	return handleCommand(localnet.NewPacketAdminKick(device, "mockrec", token), udpAddr(2), nil)
+}
+
+func errCode(t *testing.T, pc localnet.IPacketCmd) localnet.ErrCode {
+	t.Helper()
+	pcErr, ok := pc.(localnet.IPacketErr)
+	if !ok {
+		t.Fatalf("got %v, want an error", pc)
+	}
+	return pcErr.GetCode()
+}
+
+func TestKickRefusedWithoutAdminToken(t *testing.T) {
+	saved, savedTokens := adminToken, allowedTokens
+	t.Cleanup(func() { adminToken, allowedTokens = saved, savedTokens })
+	adminToken = ""
+
+	connectMock(t, "/dev/mock-kick", udpAddr(1))
+	// neither an empty token nor a connect token stands in for the admin one
+	allowedTokens = []string{"client"}
+	for _, token := range []string{"", "client"} {
+		if code := errCode(t, kick("/dev/mock-kick", token)); code != localnet.ErrCodeUnauthorized {
+			t.Fatalf("kick with %q: code %s, want %s", token, code, localnet.ErrCodeUnauthorized)
+		}
+	}
+
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	if sessionForDevice(deviceKey("mockrec", "/dev/mock-kick")) == nil {
+		t.Fatal("session kicked without an admin token")
+	}
+}
+
+func TestKickWithAdminToken(t *testing.T) {
+	saved, savedTokens := adminToken, allowedTokens
+	t.Cleanup(func() { adminToken, allowedTokens = saved, savedTokens })
+	adminToken = "admin"
+
+	connectMock(t, "/dev/mock-kick", udpAddr(1))
+	allowedTokens = []string{"client"}
+	if code := errCode(t, kick("/dev/mock-kick", "client")); code != localnet.ErrCodeUnauthorized {
+		t.Fatalf("kick with the connect token: code %s, want %s", code, localnet.ErrCodeUnauthorized)
+	}
+
+	pcSnd := kick("/dev/mock-kick", "admin")
+	if pcSnd.GetErr() != "" {
+		t.Fatal(pcSnd.GetErr())
+	}
+	if got, want := string(pcSnd.(localnet.IPacketBody).GetBody()), udpAddr(1).String(); got != want {
+		t.Fatalf("kicked %q, want %q", got, want)
+	}
+	if !slices.Contains(serverFeatures(), localnet.FeatureAdminKick) {
+		t.Fatal("adminKick not advertised with -adminToken")
+	}
+}
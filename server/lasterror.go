@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// recordError keeps pcSnd as the last error of the session pcRcv ran in,
+// for CmdStatus, when it is one. Only the most recent error is kept.
+// Commands outside a session have nowhere to keep theirs.
+func recordError(pcRcv localnet.IPacketCmd, remoteAddr net.Addr, pcSnd localnet.IPacketCmd) {
+	if pcSnd == nil || pcSnd.GetErr() == "" {
+		return
+	}
+
+	sessionsMu.RLock()
+	session, err := lookupSession(pcRcv, remoteAddr)
+	sessionsMu.RUnlock()
+	if err != nil {
+		return
+	}
+
+	session.lastError.Store(&localnet.SessionError{
+		Cmd:       pcRcv.GetCmd(),
+		Error:     pcSnd.GetErr(),
+		RequestID: pcRcv.GetRequestID(),
+		At:        time.Now(),
+	})
+}
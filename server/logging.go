@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// logBodies lets debug logs show packet bodies, APDUs included, in hex.
+var logBodies = true
+
+// setupLogging installs the handler for -logFormat at -logLevel. The text
+// format keeps the standard log output.
+func setupLogging(level slog.Level, format string) {
+	switch format {
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level, ReplaceAttr: addrString})))
+	default:
+		slog.SetLogLoggerLevel(level)
+	}
+}
+
+// addrString logs client addresses as host:port rather than as the fields of
+// their struct.
+func addrString(groups []string, a slog.Attr) slog.Attr {
+	if addr, ok := a.Value.Any().(net.Addr); ok {
+		a.Value = slog.StringValue(addr.String())
+	}
+	return a
+}
+
+// loggedPacket logs a packet with its String method, leaving out the body
+// unless logBodies is set.
+type loggedPacket struct {
+	localnet.IPacketCmd
+}
+
+func (p loggedPacket) LogValue() slog.Value {
+	if pktBody, ok := p.IPacketCmd.(localnet.IPacketBody); ok && !logBodies {
+		return slog.StringValue(fmt.Sprintf("Cmd: %s, Body(size): %4d", p.GetCmd(), len(pktBody.GetBody())))
+	}
+	return slog.StringValue(fmt.Sprint(p.IPacketCmd))
+}
@@ -2,146 +2,315 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"slices"
 	"sync"
 	"syscall"
 	"time"
 
 	"log/slog"
 
+	"github.com/avwarez/euicc-go/driver"
 	"github.com/avwarez/euicc-go/driver/localnet"
-	"github.com/damonto/euicc-go/driver/at"
-	"github.com/damonto/euicc-go/driver/mbim"
-	"github.com/damonto/euicc-go/driver/qmi"
-	"github.com/damonto/euicc-go/lpa"
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/pion/dtls/v3"
 )
 
-type Session struct {
-	RemoteAddr     *net.UDPAddr
-	LogicalChannel byte
-	StartedAt      time.Time
-	LastActivity   time.Time
-}
-
 var (
-	channelMu      sync.RWMutex
-	options        lpa.Options
-	activeSession  *Session
 	sessionTimeout = 60 * time.Second
+	bufferSize     = 2048
+	maxAPDUSize    = localnet.DefaultMaxAPDUSize
 )
 
 func main() {
-	slog.SetLogLoggerLevel(slog.LevelDebug)
-
-	bindAddrFlag := flag.String("bindAddr", "0.0.0.0", "Binding address")
-	bindPortFlag := flag.Int("bindPort", 8080, "Binding port")
-	bufferSizeFlag := flag.Int("bufferSize", 2048, "Buffer size in byte")
-	timeoutFlag := flag.Int("timeout", 60, "Session timeout in seconds")
-	flag.Parse()
+	cfg, err := parseConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return
+	}
 
-	sessionTimeout = time.Duration(*timeoutFlag) * time.Second
-	options.AdminProtocolVersion = "2"
+	level, _ := cfg.logLevel()
+	setupLogging(level, cfg.LogFormat)
+	logBodies = cfg.LogBodies
 
-	addr := net.UDPAddr{
-		Port: *bindPortFlag,
-		IP:   net.ParseIP(*bindAddrFlag),
+	if err := localnet.SetCompression(cfg.Compression); err != nil {
+		slog.Error("invalid compression", "error", err)
+		return
 	}
+	localnet.SetCompressionThreshold(cfg.CompressionThreshold)
+	localnet.SetMaxDecompressedSize(cfg.MaxDecompressedSize)
 
-	conn, err := net.ListenUDP("udp", &addr)
+	tokens, err := loadAuthTokens(cfg.AuthToken, cfg.AuthTokenFile)
 	if err != nil {
-		slog.Error("failed to start server", "error", err)
+		slog.Error("failed to load auth tokens", "error", err)
 		return
 	}
-	defer conn.Close()
+	allowedTokens = append(tokens, cfg.AuthTokens...)
+	adminToken = cfg.AdminToken
+	allowedProtos = cfg.AllowProtos
+	allowedDevices = cfg.AllowDevices
+	allowedNets, _ = parseCIDRs(cfg.AllowCIDR)
+
+	sessionTimeout = time.Duration(cfg.Timeout) * time.Second
+	minSessionTimeout = time.Duration(cfg.MinTimeout) * time.Second
+	maxSessionTimeout = time.Duration(cfg.MaxTimeout) * time.Second
+	maxSessionDuration = time.Duration(cfg.MaxSessionDuration) * time.Second
+	commandTimeout = time.Duration(cfg.CommandTimeout) * time.Second
+	connectTimeout = time.Duration(cfg.ConnectTimeout) * time.Second
+	cardKeepAlive = time.Duration(cfg.CardKeepAlive) * time.Second
+	openRetries = cfg.OpenRetries
+	maxChannels = cfg.MaxChannels
+	autoGetResponse = cfg.AutoGetResponse
+	allowConcurrent = cfg.AllowConcurrent
+	eventInterval = time.Duration(cfg.EventInterval) * time.Second
+	drainTimeout = time.Duration(cfg.DrainTimeout) * time.Second
+	readDeadline = time.Duration(cfg.ReadDeadline) * time.Millisecond
+	bufferSize = cfg.BufferSize
+	maxAPDUSize = cfg.MaxAPDUSize
+	responseCacheSize = cfg.ResponseCache
+	if cfg.RateLimit > 0 {
+		limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+	apduLogDir = cfg.APDULog
+	apduLogRedact, _ = parseRedactSet(cfg.APDULogRedact)
+	apduLogMaxSize = int64(cfg.APDULogMaxSize) * 1024
+	apduLogKeep = cfg.APDULogKeep
+	if apduLogDir != "" {
+		if err := os.MkdirAll(apduLogDir, 0700); err != nil {
+			slog.Error("failed to create apdu log directory", "error", err)
+			return
+		}
+		slog.Info("apdu transcripts enabled", "dir", apduLogDir)
+	}
+
+	sessionFile = cfg.SessionFile
+	restoreGrace = time.Duration(cfg.SessionGrace) * time.Second
+	if err := loadSessions(); err != nil {
+		slog.Warn("failed to load saved sessions", "error", err)
+	}
+
+	ip, zone, _ := cfg.bindIP()
+	addr := net.UDPAddr{
+		Port: cfg.BindPort,
+		IP:   ip,
+		Zone: zone,
+	}
+
+	var dtlsConfig *dtls.Config
+	if cfg.TLSCert != "" || cfg.TLSKey != "" || cfg.PSK != "" {
+		psk, err := hex.DecodeString(cfg.PSK)
+		if err != nil {
+			slog.Error("invalid psk, expected hex", "error", err)
+			return
+		}
+		dtlsConfig, err = localnet.NewDTLSServerConfig(cfg.TLSCert, cfg.TLSKey, psk, cfg.PSKHint)
+		if err != nil {
+			slog.Error("failed to configure dtls", "error", err)
+			return
+		}
+	}
+	if cfg.ConnectChallenge && dtlsConfig != nil {
+		slog.Info("dtls already verifies client addresses, ignoring -connectChallenge")
+	}
+	connectChallenge = cfg.ConnectChallenge && dtlsConfig == nil
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// stopped is closed once the sessions are cleaned up, so the signal
+	// handler does not outlive main
+	stopped := make(chan struct{})
+	defer close(stopped)
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
 
 	go func() {
-		sig := <-sigChan
-		slog.Info("shutdown signal received", "signal", sig)
-		cancel()
-		conn.Close()
+		select {
+		case sig := <-sigChan:
+			slog.Info("shutdown signal received", "signal", sig)
+			inFlight.drain(drainTimeout)
+			cancel()
+		case <-ctx.Done():
+		}
+
+		select {
+		case sig := <-sigChan:
+			slog.Warn("second signal received, exiting without cleanup", "signal", sig)
+			os.Exit(1)
+		case <-stopped:
+		}
 	}()
 
-	go sessionCleanup(ctx)
+	// the housekeeping tasks touch the session table, so they are waited
+	// for before the sessions are saved and cleaned up
+	var background sync.WaitGroup
+	spawn := func(fn func(context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			fn(ctx)
+		}()
+	}
 
-	slog.Info("server started", "address", addr.String(), "timeout", sessionTimeout)
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	spawn(sessionCleanup)
 
-	for {
-		select {
-		case <-ctx.Done():
-			slog.Info("shutting down gracefully")
-			cleanupActiveSession()
-			return
-		default:
-		}
+	if limiter != nil {
+		spawn(limiter.prune)
+	}
 
-		buffer := make([]byte, *bufferSizeFlag)
+	if eventInterval > 0 {
+		spawn(watchEvents)
+	}
+
+	if cardKeepAlive > 0 {
+		spawn(watchCardKeepAlive)
+	}
+
+	if cfg.MetricsAddr != "" {
+		spawn(func(ctx context.Context) { serveMetrics(ctx, cfg.MetricsAddr) })
+	}
+
+	if cfg.WSAddr != "" {
+		wsOrigins = cfg.WSOrigins
+		spawn(func(ctx context.Context) { serveWebSocket(ctx, cfg.WSAddr) })
+	}
 
-		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+	// every listener feeds the one session table; when one stops for good
+	// the others are stopped too and the server shuts down
+	var serving sync.WaitGroup
+	serve := func(fn func()) {
+		serving.Add(1)
+		go func() {
+			defer serving.Done()
+			defer cancel()
+			fn()
+		}()
+	}
+	// a listener failing to start returns early; nothing outlives main
+	defer func() {
+		cancel()
+		serving.Wait()
+		background.Wait()
+	}()
+
+	if cfg.Socket != "" {
+		mode, _ := cfg.socketFileMode()
+		listener, err := listenUnix(cfg.Socket, mode)
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			slog.Error("failed to listen on unix socket", "error", err)
+			return
+		}
+		defer listener.Close()
+		slog.Info("unix socket listening", "path", cfg.Socket, "mode", cfg.SocketMode)
+		serve(func() { serveStream(ctx, listener) })
+	}
 
-				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-				continue
+	lc, err := listenConfig(cfg.BindInterface)
+	if err != nil {
+		slog.Error("failed to start server", "error", err)
+		return
+	}
+
+	for _, transport := range cfg.transports() {
+		switch {
+		case transport == "stdio":
+			slog.Info("server started", "timeout", sessionTimeout, "transport", "stdio")
+			serve(func() { serveStdio(ctx) })
+		case transport == "tcp":
+			listener, err := lc.Listen(ctx, cfg.network("tcp"), addr.String())
+			if err != nil {
+				slog.Error("failed to start server", "error", err)
+				return
 			}
-			select {
-			case <-ctx.Done():
+			slog.Info("server started", "address", listener.Addr().String(), "timeout", sessionTimeout, "transport", "tcp", "interface", cfg.BindInterface)
+			serve(func() { serveStream(ctx, allowedOnly(listener)) })
+		case dtlsConfig != nil:
+			listener, err := dtls.Listen(cfg.network("udp"), &addr, dtlsConfig)
+			if err != nil {
+				slog.Error("failed to start server", "error", err)
 				return
-			default:
-				slog.Error("error reading from socket", "error", err)
-				continue
 			}
+			slog.Info("server started", "address", addr.String(), "timeout", sessionTimeout, "dtls", true)
+			serve(func() { serveDTLS(ctx, allowedOnly(listener)) })
+		default:
+			conn, err := lc.ListenPacket(ctx, cfg.network("udp"), addr.String())
+			if err != nil {
+				slog.Error("failed to start server", "error", err)
+				return
+			}
+			udpConn := conn.(*net.UDPConn)
+			if err := setSocketBuffers(udpConn, cfg.UDPReadBuffer, cfg.UDPWriteBuffer); err != nil {
+				udpConn.Close()
+				slog.Error("failed to start server", "error", err)
+				return
+			}
+			if cfg.DSCP > 0 {
+				if err := localnet.SetDSCP(udpConn, cfg.DSCP); err != nil {
+					slog.Warn("cannot set dscp, sending unmarked", "dscp", cfg.DSCP, "error", err)
+				}
+			}
+			slog.Info("server started", "address", addr.String(), "timeout", sessionTimeout, "transport", "udp", "interface", cfg.BindInterface)
+			serve(func() { serveUDP(ctx, udpConn) })
 		}
+	}
 
-		pcRcv, err := localnet.Decode(buffer[:n])
-		if err != nil {
-			slog.Error("error decoding packet", "error", err)
-			sendError(conn, remoteAddr, "invalid packet format")
-			continue
-		}
-
-		slog.Debug("packet received", "packet", pcRcv, "from", remoteAddr)
+	serving.Wait()
+	cancel()
+	background.Wait()
 
-		pcSnd := handleCommand(pcRcv, remoteAddr)
+	slog.Info("shutting down gracefully")
+	saveSessions()
+	cleanupAllSessions()
+	slog.Info("shutdown complete")
+}
 
-		if pcSnd == nil {
-			pcSnd = localnet.NewPacketCmd(localnet.CmdResponse)
-		}
+func handleCommand(pcRcv localnet.IPacketCmd, remoteAddr net.Addr, push func(localnet.IPacketCmd) error) localnet.IPacketCmd {
+	requestsServed.Add(1)
 
-		byteArrayResponse, err := localnet.Encode(pcSnd)
-		if err != nil {
-			slog.Error("error encoding response", "error", err)
-			continue
-		}
+	if !inFlight.enter() {
+		return localnet.NewPacketErr(localnet.ErrCodeUnavailable, "server shutting down")
+	}
+	defer inFlight.leave()
 
-		_, err = conn.WriteToUDP(byteArrayResponse, remoteAddr)
-		if err != nil {
-			slog.Error("error sending response", "error", err)
-			continue
-		}
+	// disconnects and aborts free the device, so they are never refused
+	if cmd := pcRcv.GetCmd(); cmd != localnet.CmdDisconnect && cmd != localnet.CmdAbort && !limiter.allow(remoteAddr) {
+		slog.Debug("rate limited", "cmd", pcRcv.GetCmd(), "from", remoteAddr)
+		rateLimited.Inc()
+		return localnet.NewPacketErr(localnet.ErrCodeRateLimited, "rate limited")
+	}
 
-		slog.Debug("response sent", "to", remoteAddr)
+	if pcSnd := cachedReply(pcRcv, remoteAddr); pcSnd != nil {
+		slog.Debug("replaying cached response", "requestID", pcRcv.GetRequestID(), "from", remoteAddr)
+		observeCommand(pcRcv.GetCmd(), pcSnd, true)
+		return pcSnd
 	}
+
+	pcSnd := runCommand(pcRcv, remoteAddr, push)
+	cacheReply(pcRcv, remoteAddr, pcSnd)
+	recordError(pcRcv, remoteAddr, pcSnd)
+	rememberReply(pcRcv, remoteAddr, pcSnd)
+	observeCommand(pcRcv.GetCmd(), pcSnd, false)
+	return pcSnd
 }
 
-func handleCommand(pcRcv localnet.IPacketCmd, remoteAddr *net.UDPAddr) localnet.IPacketCmd {
+func runCommand(pcRcv localnet.IPacketCmd, remoteAddr net.Addr, push func(localnet.IPacketCmd) error) localnet.IPacketCmd {
 	switch pcRcv.GetCmd() {
 
 	case localnet.CmdConnect:
 		return handleConnect(pcRcv, remoteAddr)
 
+	case localnet.CmdConnectProof:
+		return handleConnectProof(pcRcv, remoteAddr)
+
 	case localnet.CmdDisconnect:
-		return handleDisconnect(remoteAddr)
+		return handleDisconnect(pcRcv, remoteAddr)
 
 	case localnet.CmdOpenLogical:
 		return handleOpenLogical(pcRcv, remoteAddr)
@@ -149,200 +318,466 @@ func handleCommand(pcRcv localnet.IPacketCmd, remoteAddr *net.UDPAddr) localnet.
 	case localnet.CmdCloseLogical:
 		return handleCloseLogical(pcRcv, remoteAddr)
 
-	case localnet.CmdTransmit:
+	case localnet.CmdTransmit, localnet.CmdTransmitOn:
 		return handleTransmit(pcRcv, remoteAddr)
 
+	case localnet.CmdListSlots:
+		return handleListSlots(pcRcv, remoteAddr)
+
+	case localnet.CmdReset:
+		return handleReset(pcRcv, remoteAddr)
+
+	case localnet.CmdPing:
+		return handlePing(pcRcv, remoteAddr)
+
+	case localnet.CmdTransmitBatch:
+		return handleTransmitBatch(pcRcv, remoteAddr)
+
+	case localnet.CmdStatus:
+		return handleStatus(pcRcv, remoteAddr)
+
+	case localnet.CmdSubscribe:
+		return handleSubscribe(pcRcv, remoteAddr, push)
+
+	case localnet.CmdGetEID:
+		return handleGetEID(pcRcv, remoteAddr)
+
+	case localnet.CmdGetProfilesInfo:
+		return handleGetProfilesInfo(pcRcv, remoteAddr)
+
+	case localnet.CmdSwitchSlot:
+		return handleSwitchSlot(pcRcv, remoteAddr)
+
+	case localnet.CmdKeepChannelAlive:
+		return handleKeepChannelAlive(pcRcv, remoteAddr)
+
+	case localnet.CmdRepeatLast:
+		return handleRepeatLast(pcRcv, remoteAddr)
+
+	case localnet.CmdListProtocols:
+		return handleListProtocols(pcRcv, remoteAddr)
+
+	case localnet.CmdEnableProfile, localnet.CmdDisableProfile:
+		return handleSetProfileState(pcRcv, remoteAddr)
+
+	case localnet.CmdSelfTest:
+		return handleSelfTest(pcRcv, remoteAddr)
+
+	case localnet.CmdCapabilities:
+		return handleCapabilities(pcRcv, remoteAddr)
+
+	case localnet.CmdAbort:
+		return handleAbort(pcRcv, remoteAddr)
+
+	case localnet.CmdSelect:
+		return handleSelect(pcRcv, remoteAddr)
+
+	case localnet.CmdAdminKick:
+		return handleAdminKick(pcRcv, remoteAddr)
+
+	case localnet.CmdStoreData:
+		return handleStoreData(pcRcv, remoteAddr)
+
+	case localnet.CmdHealth:
+		return handleHealth(pcRcv, remoteAddr)
+
 	default:
 		slog.Warn("unknown command", "command", pcRcv.GetCmd())
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, "unknown command")
+		return localnet.NewPacketErr(localnet.ErrCodeUnknownCommand, "unknown command")
 	}
 }
 
-func handleConnect(pcRcv localnet.IPacketCmd, remoteAddr *net.UDPAddr) localnet.IPacketCmd {
-	channelMu.Lock()
-	defer channelMu.Unlock()
+func handleConnect(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	return openSession(pcRcv, remoteAddr, false)
+}
+
+// openSession starts or resumes the session a connect asks for. Under
+// -connectChallenge a UDP connect is only challenged until proven is set,
+// once handleConnectProof checked the client's answer.
+func openSession(pcRcv localnet.IPacketCmd, remoteAddr net.Addr, proven bool) localnet.IPacketCmd {
+	pcConn, ok := pcRcv.(localnet.IPacketConnect)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type for connect")
+	}
+
+	if !authTokenAllowed(pcConn.GetAuthToken()) {
+		slog.Warn("rejecting connect with invalid auth token", "client", remoteAddr)
+		return localnet.NewPacketErr(localnet.ErrCodeUnauthorized, "invalid auth token")
+	}
+
+	if err := checkAllowed(pcConn.GetProto(), pcConn.GetDevice(), remoteAddr); err != nil {
+		return errorReply(err)
+	}
+
+	slot, err := connectSlot(pcConn.GetProto(), pcConn.GetSlot())
+	if err != nil {
+		slog.Warn("rejecting connect slot", "client", remoteAddr, "protocol", pcConn.GetProto(), "slot", pcConn.GetSlot())
+		return errorReply(err)
+	}
+
+	version, err := localnet.NegotiateVersion(localnet.CurrentProtocolVersion, pcConn.GetProtocolVersion())
+	if err != nil {
+		slog.Warn("rejecting client protocol version", "client", remoteAddr, "version", pcConn.GetProtocolVersion())
+		return errorReply(withCode(localnet.ErrCodeInvalidRequest, err))
+	}
+
+	timeout, err := requestedSessionTimeout(pcConn.GetRequestedTimeout())
+	if err != nil {
+		slog.Warn("rejecting requested session timeout", "client", remoteAddr, "timeout", pcConn.GetRequestedTimeout())
+		return errorReply(err)
+	}
+
+	if !proven && challengeRequired(remoteAddr) {
+		return issueChallenge(pcConn, remoteAddr)
+	}
 
-	if activeSession != nil {
-		if time.Since(activeSession.LastActivity) < sessionTimeout {
-			return localnet.NewPacketCmdErr(
-				localnet.CmdResponse,
-				fmt.Sprintf("device busy, in use by %s", activeSession.RemoteAddr),
-			)
+	var aid []byte
+	if pcAID, ok := pcRcv.(localnet.IPacketConnectAID); ok {
+		aid = pcAID.GetAID()
+		// a PacketConnectSlot may connect a wide slot without an AID
+		if _, wide := pcRcv.(localnet.IPacketConnectSlot); wide && len(aid) == 0 {
+			aid = nil
+		} else if err = localnet.CheckAID(aid); err != nil {
+			return errorReply(err)
 		}
-		slog.Warn("forcing cleanup of expired session", "client", activeSession.RemoteAddr)
-		forceCleanup()
 	}
 
-	pcConn, ok := pcRcv.(localnet.IPacketConnect)
-	if !ok {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, "invalid packet type for connect")
-	}
-
-	var err error
-	switch pcConn.GetProto() {
-	case "at":
-		options.Channel, err = at.New(pcConn.GetDevice())
-	case "mbim":
-		options.Channel, err = mbim.New(pcConn.GetDevice(), pcConn.GetSlot())
-	case "qmi":
-		options.Channel, err = qmi.New(pcConn.GetDevice(), pcConn.GetSlot())
-	case "qrtr":
-		options.Channel, err = qmi.NewQRTR(pcConn.GetSlot())
-	default:
-		return localnet.NewPacketCmdErr(
-			localnet.CmdResponse,
-			fmt.Sprintf("unsupported protocol: %s", pcConn.GetProto()),
-		)
+	device := deviceKey(pcConn.GetProto(), pcConn.GetDevice())
+	unlock := deviceLocks.lock(device)
+	defer func() { unlock() }()
+
+	own, stale, err := claimDevice(device, pcConn, slot, remoteAddr)
+	for _, session := range stale {
+		releaseChannel(session)
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+	if own != nil {
+		opened := localnet.InvalidChannel
+		if aid != nil {
+			if opened, err = connectChannel(own, aid, true); err != nil {
+				return errorReply(endIfGone(own, err))
+			}
+		}
+		return resumeSession(own, pcConn, remoteAddr, version, timeout, opened)
 	}
 
+	saved, err := claimRestorable(device, pcConn, slot)
 	if err != nil {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
+		return errorReply(err)
+	}
+
+	sessionsMu.RLock()
+	busy := version < localnet.ProtocolVersion2 && legacySessionFor(remoteAddr) != nil
+	sessionsMu.RUnlock()
+	if busy {
+		return localnet.NewPacketErr(localnet.ErrCodeBusy, "client already has an active session")
 	}
 
-	err = options.Channel.Connect()
+	id, err := newSessionToken()
 	if err != nil {
-		options.Channel = nil
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
+		return errorReply(err)
 	}
 
-	activeSession = &Session{
-		RemoteAddr:     remoteAddr,
-		LogicalChannel: localnet.InvalidChannel,
-		StartedAt:      time.Now(),
-		LastActivity:   time.Now(),
+	peer, err := sharedSession(device, pcConn.GetProto(), slot)
+	if err != nil {
+		return errorReply(err)
+	}
+	var channel apdu.SmartCardChannel
+	card := &sharedCard{}
+	if peer != nil {
+		channel, card = peer.Channel, peer.card
+	} else if channel, err = openDriver(pcConn.GetProto(), pcConn.GetDevice(), slot, shorterTimeout(connectTimeout, pcRcv), &unlock); err != nil {
+		return errorReply(err)
+	}
+	card.users++
+	restored := saved != nil && reopenChannels(channel, saved)
+
+	session := &Session{
+		ID:              id,
+		RemoteAddr:      remoteAddr,
+		Device:          device,
+		Proto:           pcConn.GetProto(),
+		Slot:            slot,
+		Channel:         channel,
+		card:            card,
+		ProtocolVersion: version,
+		responses:       newResponseCache(responseCacheSize),
+		StartedAt:       time.Now(),
+		LastActivity:    time.Now(),
+		Timeout:         timeout,
+	}
+	if restored {
+		// the client goes on with the token and channels it had before the
+		// restart; resuming does not restart the maxSessionDuration clock
+		session.ID = saved.ID
+		session.StartedAt = saved.StartedAt
+		session.LogicalChannels, session.channelAIDs = restoredChannels(saved)
+	}
+	session.transcript = openTranscript(session)
+	if restored {
+		session.transcript.note("session restored after restart, previously held by %s", saved.RemoteAddr)
+	}
+
+	opened := localnet.InvalidChannel
+	if aid != nil {
+		if opened, err = connectChannel(session, aid, restored); err != nil {
+			slog.Warn("connect failed to open logical channel, closing session", "client", remoteAddr, "device", pcConn.GetDevice(), "aid", fmt.Sprintf("%X", aid), "error", err)
+			releaseChannel(session)
+			return errorReply(err)
+		}
 	}
 
+	sessionsMu.Lock()
+	sessions[session.ID] = session
+	delete(overdueSessions, sessionKey(session))
+	sessionsChanged()
+	count := len(sessions)
+	sessionsMu.Unlock()
+
 	slog.Info("session started",
 		"client", remoteAddr.String(),
 		"protocol", pcConn.GetProto(),
-		"device", pcConn.GetDevice())
+		"device", pcConn.GetDevice(),
+		"version", version,
+		"timeout", timeout,
+		"restored", restored,
+		"sessions", count)
 
-	return localnet.NewPacketCmd(localnet.CmdResponse)
+	return connectResponse(session, restored, opened)
 }
 
-func handleDisconnect(remoteAddr *net.UDPAddr) localnet.IPacketCmd {
-	channelMu.Lock()
-	defer channelMu.Unlock()
-
-	if activeSession == nil {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, "no active session")
+// claimDevice checks who holds device; callers hold its device lock. It
+// returns the client's own session when it can be resumed on slot, and
+// detaches expired or replaced sessions, which the caller releases even
+// when the device turns out busy. With -allowConcurrent sessions of other
+// clients are left to share the device.
+func claimDevice(device string, pcConn localnet.IPacketConnect, slot uint16, remoteAddr net.Addr) (own *Session, stale []*Session, err error) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	for _, session := range sessionsForDevice(device) {
+		switch {
+		case session.expired():
+			slog.Warn("forcing cleanup of expired session", "client", session.RemoteAddr, "device", device)
+			detachSession(session)
+			stale = append(stale, session)
+		case session.overdue():
+			slog.Warn("forcing cleanup of session at maximum duration", "client", session.RemoteAddr, "device", device)
+			detachSession(session)
+			markOverdue(session)
+			stale = append(stale, session)
+		case !resumableBy(session, pcConn, remoteAddr):
+			if !allowConcurrent {
+				err = withCode(localnet.ErrCodeBusy, fmt.Errorf("device busy, in use by %s", session.RemoteAddr))
+			}
+		case session.Proto == pcConn.GetProto() && session.Slot == slot:
+			own = session
+		default:
+			slog.Info("client replaced its session", "client", remoteAddr, "device", device)
+			detachSession(session)
+			stale = append(stale, session)
+		}
 	}
-
-	if !addressesEqual(activeSession.RemoteAddr, remoteAddr) {
-		return localnet.NewPacketCmdErr(
-			localnet.CmdResponse,
-			fmt.Sprintf("unauthorized: session belongs to %s", activeSession.RemoteAddr),
-		)
+	if err != nil {
+		return nil, stale, err
 	}
+	return own, stale, nil
+}
 
-	if options.Channel != nil && activeSession.LogicalChannel != localnet.InvalidChannel {
-		if err := options.Channel.CloseLogicalChannel(activeSession.LogicalChannel); err != nil {
-			slog.Warn("failed to close logical channel", "error", err)
-		}
+// resumeSession hands a live session back to the client that owns it, after
+// it lost its connection, instead of reporting the device busy. The card
+// connection and any open logical channel are kept. Callers hold the device
+// lock.
+func resumeSession(session *Session, pcConn localnet.IPacketConnect, remoteAddr net.Addr, version uint16, timeout time.Duration, opened byte) localnet.IPacketCmd {
+	slog.Info("session resumed",
+		"client", remoteAddr.String(),
+		"previous", session.RemoteAddr.String(),
+		"device", session.Device,
+		"version", version)
+	session.transcript.note("session resumed by %s", remoteAddr)
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	session.RemoteAddr = remoteAddr
+	session.ProtocolVersion = version
+	session.LastActivity = time.Now()
+	session.Timeout = timeout
+	// a new client numbers its requests from scratch
+	session.responses = newResponseCache(responseCacheSize)
+
+	return connectResponse(session, true, opened)
+}
+
+// connectResponse answers a connect; opened is the channel opened on the
+// AID of a PacketConnectAID, or localnet.InvalidChannel.
+func connectResponse(session *Session, resumed bool, opened byte) localnet.IPacketCmd {
+	if session.ProtocolVersion == localnet.ProtocolVersionLegacy {
+		return localnet.NewPacketCmd(localnet.CmdResponse)
+	}
+	pcResp := localnet.NewPacketConnectResp(session.ProtocolVersion, resumed)
+	switch {
+	case opened != localnet.InvalidChannel:
+		pcResp = localnet.NewPacketConnectChannel(session.ProtocolVersion, resumed, uint16(bufferSize), opened)
+	case session.ProtocolVersion >= localnet.ProtocolVersion5:
+		pcResp = localnet.NewPacketConnectInfo(session.ProtocolVersion, resumed, uint16(bufferSize))
 	}
+	if session.tokenBound() {
+		pcResp.SetSessionToken(session.ID)
+	}
+	return pcResp
+}
 
-	var err error
-	if options.Channel != nil {
-		err = options.Channel.Disconnect()
-		options.Channel = nil
+func handleDisconnect(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
 	}
+	defer unlock()
+
+	sessionsMu.Lock()
+	detachSession(session)
+	sessionsMu.Unlock()
 
-	slog.Info("session ended", "client", remoteAddr.String(), "duration", time.Since(activeSession.StartedAt))
-	activeSession = nil
+	err = releaseChannel(session)
+
+	slog.Info("session ended", "client", remoteAddr.String(), "device", session.Device, "duration", time.Since(session.StartedAt))
 
 	if err != nil {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
+		return errorReply(err)
 	}
 
 	return localnet.NewPacketCmd(localnet.CmdResponse)
 }
 
-func handleOpenLogical(pcRcv localnet.IPacketCmd, remoteAddr *net.UDPAddr) localnet.IPacketCmd {
-	channelMu.Lock()
-	defer channelMu.Unlock()
-
-	if err := checkSessionAuth(remoteAddr); err != nil {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
+func handleOpenLogical(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
 	}
+	defer func() { unlock() }()
 
 	pktBody, ok := pcRcv.(localnet.IPacketBody)
 	if !ok {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, "invalid packet type")
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type")
 	}
 
 	aid := pktBody.GetBody()
-	if len(aid) == 0 {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, "empty AID")
+	if err = localnet.CheckAID(aid); err != nil {
+		return errorReply(err)
 	}
 
-	channel, err := options.Channel.OpenLogicalChannel(aid)
+	var channel byte
+	if terr := callCard(session, timeoutFor(pcRcv), &unlock, func() {
+		channel, err = openLogicalChannel(session, aid)
+		if err == nil {
+			session.transcript.note("opened logical channel %d aid=%X", channel, aid)
+		}
+	}); terr != nil {
+		return errorReply(terr)
+	}
 	if err != nil {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
+		return errorReply(endIfGone(session, err))
+	}
+	if err = localnet.CheckChannel(channel); err != nil {
+		slog.Error("driver opened an invalid logical channel", "device", session.Device, "channel", channel)
+		return localnet.NewPacketErr(localnet.ErrCodeInternal, "driver returned "+err.Error())
 	}
 
-	activeSession.LogicalChannel = channel
-	activeSession.LastActivity = time.Now()
+	session.addLogicalChannel(channel, aid)
 
 	slog.Debug("logical channel opened", "channel", channel, "aid", fmt.Sprintf("%X", aid))
 
 	return localnet.NewPacketBody(localnet.CmdResponse, []byte{channel})
 }
 
-func handleCloseLogical(pcRcv localnet.IPacketCmd, remoteAddr *net.UDPAddr) localnet.IPacketCmd {
-	channelMu.Lock()
-	defer channelMu.Unlock()
-
-	if err := checkSessionAuth(remoteAddr); err != nil {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
+func handleCloseLogical(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
 	}
+	defer func() { unlock() }()
 
 	pktBody, ok := pcRcv.(localnet.IPacketBody)
 	if !ok || len(pktBody.GetBody()) == 0 {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, "invalid packet")
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet")
 	}
 
 	channel := pktBody.GetBody()[0]
+	if err = localnet.CheckChannel(channel); err != nil {
+		return errorReply(err)
+	}
+	if allowConcurrent {
+		// another session's channel is not ours to close
+		if err = checkOwnChannel(session, channel); err != nil {
+			return errorReply(err)
+		}
+	}
 
-	err := options.Channel.CloseLogicalChannel(channel)
+	if terr := callCard(session, timeoutFor(pcRcv), &unlock, func() {
+		session.closes.Add(1)
+		err = session.Channel.CloseLogicalChannel(channel)
+		if err == nil {
+			session.transcript.note("closed logical channel %d", channel)
+		}
+	}); terr != nil {
+		return errorReply(terr)
+	}
 	if err != nil {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
+		return errorReply(endIfGone(session, err))
 	}
 
-	if activeSession.LogicalChannel == channel {
-		activeSession.LogicalChannel = localnet.InvalidChannel
-	}
-	activeSession.LastActivity = time.Now()
+	session.removeLogicalChannel(channel)
 
 	slog.Debug("logical channel closed", "channel", channel)
 
 	return localnet.NewPacketCmd(localnet.CmdResponse)
 }
 
-func handleTransmit(pcRcv localnet.IPacketCmd, remoteAddr *net.UDPAddr) localnet.IPacketCmd {
-	channelMu.Lock()
-	defer channelMu.Unlock()
-
-	if err := checkSessionAuth(remoteAddr); err != nil {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
+func handleTransmit(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
 	}
+	defer func() { unlock() }()
 
 	pktBody, ok := pcRcv.(localnet.IPacketBody)
 	if !ok {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, "invalid packet type")
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type")
 	}
 
 	apdu := pktBody.GetBody()
-	if len(apdu) == 0 {
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, "empty APDU")
+	if err = localnet.CheckAPDUSize(apdu, maxAPDUSize); err != nil {
+		return errorReply(err)
+	}
+	if pktChannel, ok := pcRcv.(localnet.IPacketChannelBody); ok {
+		if err = checkTransmitChannel(session, pktChannel.GetChannel(), apdu); err != nil {
+			slog.Warn("rejecting transmit on wrong channel", "device", session.Device, "error", err)
+			return errorReply(err)
+		}
+	}
+	if err = checkSharedAPDU(session, apdu); err != nil {
+		slog.Warn("rejecting transmit on shared device", "device", session.Device, "error", err)
+		return errorReply(err)
 	}
 
-	response, err := options.Channel.Transmit(apdu)
+	var response []byte
+	started := time.Now()
+	if terr := callCard(session, timeoutFor(pcRcv), &unlock, func() {
+		session.transmits.Add(1)
+		response, err = transmitCard(session, apdu)
+	}); terr != nil {
+		return errorReply(terr)
+	}
+	transmitSeconds.Observe(time.Since(started).Seconds())
 	if err != nil {
 		slog.Error("transmit failed", "error", err)
-		return localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
+		return errorReply(endIfGone(session, err))
 	}
 
-	activeSession.LastActivity = time.Now()
+	session.touch()
 
 	slog.Debug("transmit completed",
 		"apduLen", len(apdu),
@@ -351,73 +786,141 @@ func handleTransmit(pcRcv localnet.IPacketCmd, remoteAddr *net.UDPAddr) localnet
 	return localnet.NewPacketBody(localnet.CmdResponse, response)
 }
 
-func checkSessionAuth(remoteAddr *net.UDPAddr) error {
-	if activeSession == nil {
-		return fmt.Errorf("no active session, connect first")
+// checkTransmitChannel rejects a trch whose channel the session never opened
+// or whose APDU addresses another channel. The basic channel is always open.
+func checkTransmitChannel(session *Session, channel byte, apdu []byte) error {
+	if err := checkOwnChannel(session, channel); err != nil {
+		return err
 	}
+	return localnet.CheckCLAChannel(channel, apdu)
+}
 
-	if !addressesEqual(activeSession.RemoteAddr, remoteAddr) {
-		return fmt.Errorf("unauthorized: session belongs to %s", activeSession.RemoteAddr)
+// checkOwnChannel rejects a logical channel the session never opened. The
+// basic channel is always open.
+func checkOwnChannel(session *Session, channel byte) error {
+	if channel != 0 && !slices.Contains(session.LogicalChannels, channel) {
+		return fmt.Errorf("%w: %d is not open", localnet.ErrInvalidChannel, channel)
 	}
+	return nil
+}
 
-	if time.Since(activeSession.LastActivity) > sessionTimeout {
-		slog.Warn("session expired during operation")
-		forceCleanup()
-		return fmt.Errorf("session expired")
+func handleTransmitBatch(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
 	}
+	defer func() { unlock() }()
 
-	return nil
-}
+	pktBatch, ok := pcRcv.(localnet.IPacketBatch)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type")
+	}
 
-func sessionCleanup(ctx context.Context) {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
+	apdus := pktBatch.GetAPDUs()
+	if len(apdus) == 0 || len(apdus) > localnet.MaxBatchSize {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, fmt.Sprintf("invalid batch size: %d", len(apdus)))
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			channelMu.Lock()
-			if activeSession != nil && time.Since(activeSession.LastActivity) > sessionTimeout {
-				slog.Info("cleaning up expired session",
-					"client", activeSession.RemoteAddr,
-					"idleTime", time.Since(activeSession.LastActivity))
-				forceCleanup()
-			}
-			channelMu.Unlock()
-		}
+	var pcSnd localnet.IPacketCmd
+	if terr := callCard(session, timeoutFor(pcRcv), &unlock, func() {
+		pcSnd = transmitBatch(session, apdus)
+	}); terr != nil {
+		return errorReply(terr)
 	}
+	return pcSnd
 }
 
-func forceCleanup() {
-	if activeSession != nil && options.Channel != nil {
+// transmitBatch runs apdus in order, stopping at the first failure.
+func transmitBatch(session *Session, apdus [][]byte) localnet.IPacketCmd {
+	responses := make([][]byte, 0, len(apdus))
+	for i, apdu := range apdus {
+		if err := localnet.CheckAPDUSize(apdu, maxAPDUSize); err != nil {
+			return localnet.NewPacketBatchResp(responses, int32(i), err.Error())
+		}
+		if err := checkSharedAPDU(session, apdu); err != nil {
+			return localnet.NewPacketBatchResp(responses, int32(i), err.Error())
+		}
 
-		if activeSession.LogicalChannel != localnet.InvalidChannel {
-			options.Channel.CloseLogicalChannel(activeSession.LogicalChannel)
+		started := time.Now()
+		session.transmits.Add(1)
+		response, err := transmitCard(session, apdu)
+		transmitSeconds.Observe(time.Since(started).Seconds())
+		if err != nil {
+			slog.Error("batch transmit failed", "index", i, "error", err)
+			return localnet.NewPacketBatchResp(responses, int32(i), endIfGone(session, err).Error())
 		}
-		options.Channel.Disconnect()
-		options.Channel = nil
+
+		responses = append(responses, response)
+		session.touch()
 	}
-	activeSession = nil
+
+	slog.Debug("batch completed", "apdus", len(apdus))
+
+	return localnet.NewPacketBatchResp(responses, -1, "")
 }
 
-func cleanupActiveSession() {
-	channelMu.Lock()
-	defer channelMu.Unlock()
-	forceCleanup()
+func handleListSlots(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	pcConn, ok := pcRcv.(localnet.IPacketConnect)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type for list slots")
+	}
+
+	if !authTokenAllowed(pcConn.GetAuthToken()) {
+		slog.Warn("rejecting list slots with invalid auth token", "client", remoteAddr)
+		return localnet.NewPacketErr(localnet.ErrCodeUnauthorized, "invalid auth token")
+	}
+
+	if err := checkAllowed(pcConn.GetProto(), pcConn.GetDevice(), remoteAddr); err != nil {
+		return errorReply(err)
+	}
+
+	unlock := deviceLocks.lock(deviceKey(pcConn.GetProto(), pcConn.GetDevice()))
+	defer unlock()
+
+	slots, err := listSlots(pcConn.GetProto(), pcConn.GetDevice())
+	if err != nil {
+		return errorReply(err)
+	}
+
+	slog.Debug("slots listed", "client", remoteAddr, "device", pcConn.GetDevice(), "slots", len(slots))
+
+	return localnet.NewPacketBody(localnet.CmdResponse, localnet.EncodeSlotInfos(slots))
 }
 
-func addressesEqual(a1, a2 *net.UDPAddr) bool {
-	if a1 == nil || a2 == nil {
-		return false
+func handleReset(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
+	}
+	defer unlock()
+
+	if session.shared() {
+		return errorReply(errSharedCard)
 	}
-	return a1.IP.Equal(a2.IP) && a1.Port == a2.Port
+	if err = resetSession(session); err != nil {
+		if driver.IsDeviceGone(err) {
+			return errorReply(endIfGone(session, err))
+		}
+		slog.Error("reset failed, closing session", "client", remoteAddr, "device", session.Device, "error", err)
+		sessionsMu.Lock()
+		detachSession(session)
+		sessionsMu.Unlock()
+		releaseChannel(session)
+		return localnet.NewPacketErr(localnet.ErrCodeInternal, fmt.Sprintf("reset failed, session closed: %s", err))
+	}
+	session.touch()
+
+	return localnet.NewPacketCmd(localnet.CmdResponse)
 }
 
-func sendError(conn *net.UDPConn, addr *net.UDPAddr, errMsg string) {
-	pcErr := localnet.NewPacketCmdErr(localnet.CmdResponse, errMsg)
-	if data, err := localnet.Encode(pcErr); err == nil {
-		conn.WriteToUDP(data, addr)
+// handlePing keeps the session alive. It leaves the card alone, so it does
+// not wait for a command holding the device.
+func handlePing(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, err := peekSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
 	}
+	session.touch()
+
+	return localnet.NewPacketCmd(localnet.CmdPong)
 }
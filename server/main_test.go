@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// freeUDPPort returns a local port nothing listens on right now.
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestMainStartsAndStops(t *testing.T) {
+	port := freeUDPPort(t)
+	args, commandLine := os.Args, flag.CommandLine
+	t.Cleanup(func() {
+		os.Args, flag.CommandLine = args, commandLine
+		// shutdown leaves the server refusing commands
+		inFlight = drainer{}
+	})
+	os.Args = []string{"server", "-bindAddr", "127.0.0.1", "-bindPort", strconv.Itoa(port), "-eventInterval", "1", "-logLevel", "warn"}
+	flag.CommandLine = flag.NewFlagSet("server", flag.ContinueOnError)
+
+	stopped := make(chan struct{})
+	go func() {
+		main()
+		close(stopped)
+	}()
+
+	ch, err := localnet.NewUDPConf("127.0.0.1:"+strconv.Itoa(port), "/dev/mock-main", "mockrec", 0, 0, localnet.NetConf{Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := ch.(*localnet.NetContext)
+	// the server is up once a connect gets through
+	deadline := time.Now().Add(5 * time.Second)
+	for err = nc.Connect(); err != nil; err = nc.Connect() {
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+	}
+
+	// the session is left open for the shutdown to clean up
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not stop")
+	}
+
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	if len(sessions) != 0 {
+		t.Fatalf("%d sessions left after shutdown", len(sessions))
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	commandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "euicc",
+		Name:      "commands_total",
+		Help:      "Commands handled, by command and result (ok, error or replayed).",
+	}, []string{"cmd", "result"})
+
+	transmitSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "euicc",
+		Name:      "transmit_duration_seconds",
+		Help:      "Time the card took to answer an APDU.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+
+	activeSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "euicc",
+		Name:      "active_sessions",
+		Help:      "Sessions currently open.",
+	})
+
+	sessionSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "euicc",
+		Name:      "session_duration_seconds",
+		Help:      "Lifetime of ended sessions.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	})
+
+	packetErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "euicc",
+		Name:      "packet_errors_total",
+		Help:      "Packets that could not be decoded or encoded.",
+	}, []string{"op"})
+
+	rateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "euicc",
+		Name:      "rate_limited_total",
+		Help:      "Commands refused by the per-client rate limit.",
+	})
+
+	blocked = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "euicc",
+		Name:      "blocked_total",
+		Help:      "Datagrams and connections dropped for coming from outside -allowCIDR.",
+	})
+
+	overloaded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "euicc",
+		Name:      "overloaded_total",
+		Help:      "UDP datagrams dropped because every worker or the client's queue was full.",
+	})
+)
+
+// observeCommand counts a handled command. Unknown commands share one label
+// so clients cannot inflate the series count.
+func observeCommand(cmd localnet.Cmd, pcSnd localnet.IPacketCmd, replayed bool) {
+	label, result := string(cmd), "ok"
+	switch {
+	case replayed:
+		result = "replayed"
+	case pcSnd.GetErr() == "unknown command":
+		label, result = "unknown", "error"
+	case pcSnd.GetErr() != "":
+		result = "error"
+	}
+	commandsTotal.WithLabelValues(label, result).Inc()
+}
+
+// sessionsChanged refreshes the session gauge; callers hold sessionsMu.
+func sessionsChanged() {
+	activeSessions.Set(float64(len(sessions)))
+}
+
+func sessionEnded(session *Session) {
+	sessionSeconds.Observe(time.Since(session.StartedAt).Seconds())
+}
+
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	slog.Info("metrics endpoint started", "address", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("metrics endpoint failed", "error", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// openRetries is how many times opch repeats an OPEN LOGICAL CHANNEL that
+// failed with a transient status word, waiting openRetryDelay in between.
+var openRetries = 2
+
+const openRetryDelay = 100 * time.Millisecond
+
+// openLogicalChannel opens a logical channel on aid, retrying the open
+// while the card answers it with a transient error; callers hold the
+// device lock. A session at its channel limit is refused before the card
+// is asked.
+func openLogicalChannel(session *Session, aid []byte) (byte, error) {
+	if err := checkChannelLimit(session); err != nil {
+		return localnet.InvalidChannel, err
+	}
+	session.opens.Add(1)
+	for attempt := 0; ; attempt++ {
+		channel, err := session.Channel.OpenLogicalChannel(aid)
+		if err == nil || attempt >= openRetries || !transientOpenError(err) {
+			return channel, err
+		}
+		slog.Debug("open logical channel failed, retrying", "device", session.Device, "aid", fmt.Sprintf("%X", aid), "attempt", attempt+1, "error", err)
+		session.transcript.note("open logical channel aid=%X failed, retrying: %s", aid, err)
+		time.Sleep(openRetryDelay)
+	}
+}
+
+// transientOpenError reports whether err ends in status word 6A80 or 6A81.
+// Some cards answer MANAGE CHANNEL or SELECT with these while still busy
+// with a previous command and succeed when asked again. Drivers put the
+// response in hex at the end of their error, as in "select AID: 6A81";
+// errors without one, and other status words such as 6A82 for an AID the
+// card does not have, are final.
+func transientOpenError(err error) bool {
+	fields := strings.Fields(err.Error())
+	if len(fields) == 0 {
+		return false
+	}
+	response, derr := hex.DecodeString(fields[len(fields)-1])
+	if derr != nil {
+		return false
+	}
+	_, sw, serr := localnet.SplitStatusWord(response)
+	return serr == nil && (sw == localnet.SWIncorrectData || sw == localnet.SWFunctionNotSupported)
+}
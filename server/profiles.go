@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+	"github.com/avwarez/euicc-go/driver/tlv"
+)
+
+// getProfilesInfoData is the ProfileInfoListRequest for the tags
+// localnet.Profile carries: ICCID 5A, state 9F70, nickname 90, service
+// provider name 91 and profile name 92.
+var getProfilesInfoData = []byte{0xBF, 0x2D, 0x08, 0x5C, 0x06, 0x5A, 0x9F, 0x70, 0x90, 0x91, 0x92}
+
+func handleGetProfilesInfo(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
+	}
+	defer func() { unlock() }()
+
+	var profiles []localnet.Profile
+	if terr := callCard(session, timeoutFor(pcRcv), &unlock, func() {
+		profiles, err = readProfiles(session)
+	}); terr != nil {
+		return errorReply(terr)
+	}
+	if err != nil {
+		slog.Warn("get profiles info failed", "device", session.Device, "error", err)
+		return errorReply(endIfGone(session, err))
+	}
+
+	session.touch()
+
+	body, err := json.Marshal(profiles)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	slog.Debug("profiles read", "device", session.Device, "profiles", len(profiles))
+
+	return localnet.NewPacketBody(localnet.CmdResponse, body)
+}
+
+// readProfiles asks the ISD-R for the installed profiles.
+func readProfiles(session *Session) ([]localnet.Profile, error) {
+	data, err := isdrStoreData(session, "get profiles info", getProfilesInfoData)
+	if err != nil {
+		return nil, fmt.Errorf("GetProfilesInfo failed: %w", err)
+	}
+	return parseProfiles(data)
+}
+
+// parseProfiles extracts the profiles from a ProfileInfoListResponse:
+// BF2D { A0 { E3 {...} ... } } or, on failure, BF2D { 81 <error> }. A card
+// without profiles answers with an empty A0, which gives an empty, non-nil
+// list.
+func parseProfiles(data []byte) ([]localnet.Profile, error) {
+	tlvs, err := tlv.Parse(data)
+	if err != nil || len(tlvs) != 1 || tlvs[0].Tag != 0xBF2D {
+		return nil, fmt.Errorf("malformed ProfileInfoListResponse: %X", data)
+	}
+
+	profiles := []localnet.Profile{}
+	for _, child := range tlvs[0].Children {
+		switch child.Tag {
+		case 0x81:
+			return nil, fmt.Errorf("GetProfilesInfo failed: card returned error %X", child.Value)
+		case 0xA0:
+			for _, info := range child.FindAll(0xE3) {
+				profiles = append(profiles, parseProfile(info))
+			}
+			return profiles, nil
+		}
+	}
+	return nil, fmt.Errorf("malformed ProfileInfoListResponse: %X", data)
+}
+
+func parseProfile(info *tlv.TLV) localnet.Profile {
+	var profile localnet.Profile
+	if iccid := info.Find(0x5A); iccid != nil {
+		profile.ICCID = decodeICCID(iccid.Value)
+	}
+	if state := info.Find(0x9F70); state != nil && len(state.Value) == 1 {
+		profile.State = localnet.ProfileState(state.Value[0])
+	}
+	if nickname := info.Find(0x90); nickname != nil {
+		profile.Nickname = string(nickname.Value)
+	}
+	if spn := info.Find(0x91); spn != nil {
+		profile.ServiceProviderName = string(spn.Value)
+	}
+	if name := info.Find(0x92); name != nil {
+		profile.Name = string(name.Value)
+	}
+	return profile
+}
+
+// decodeICCID turns the BCD ICCID of a profile, digits swapped in each byte
+// and padded with F, into its digits.
+func decodeICCID(value []byte) string {
+	swapped := make([]byte, len(value))
+	for i, b := range value {
+		swapped[i] = b<<4 | b>>4
+	}
+	return strings.TrimRight(strings.ToUpper(hex.EncodeToString(swapped)), "F")
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/avwarez/euicc-go/driver/localnet"
+	"github.com/avwarez/euicc-go/driver/tlv"
+)
+
+// A REFRESH resets the card, which takes the modem a few seconds. The
+// session's channel is reopened up to refreshAttempts times, refreshDelay
+// apart, until the ISD-R answers again.
+const (
+	refreshAttempts = 10
+	refreshDelay    = 500 * time.Millisecond
+)
+
+// profileRequest is an ES10c function changing profile state; its response
+// reuses the request tag.
+type profileRequest struct {
+	name string
+	tag  tlv.Tag
+}
+
+var profileRequests = map[localnet.Cmd]profileRequest{
+	localnet.CmdEnableProfile:  {"EnableProfile", 0xBF31},
+	localnet.CmdDisableProfile: {"DisableProfile", 0xBF32},
+}
+
+// handleSetProfileState enables or disables the profile whose ICCID is the
+// body and answers with the card's one-byte result. The request asks the
+// eUICC to REFRESH, so on success the session's channel is reopened once
+// the card is back and its logical channels are gone.
+func handleSetProfileState(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
+	}
+	defer func() { unlock() }()
+
+	pktBody, ok := pcRcv.(localnet.IPacketBody)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type")
+	}
+	iccid := string(pktBody.GetBody())
+	if err = localnet.CheckICCID(iccid); err != nil {
+		return errorReply(withCode(localnet.ErrCodeInvalidRequest, err))
+	}
+	if session.shared() {
+		return errorReply(errSharedCard)
+	}
+
+	request := profileRequests[pcRcv.GetCmd()]
+	var result localnet.ProfileResult
+	if terr := callCard(session, timeoutFor(pcRcv), &unlock, func() {
+		result, err = setProfileState(session, request, iccid)
+	}); terr != nil {
+		return errorReply(terr)
+	}
+	if err != nil {
+		slog.Warn("profile state change failed", "request", request.name, "device", session.Device, "iccid", iccid, "error", err)
+		return errorReply(endIfGone(session, err))
+	}
+	if result != localnet.ProfileResultOK {
+		slog.Info("card refused profile state change", "request", request.name, "device", session.Device, "iccid", iccid, "result", result)
+		session.touch()
+		return localnet.NewPacketBody(localnet.CmdResponse, []byte{byte(result)})
+	}
+	session.transcript.note("%s %s, waiting for the card to refresh", request.name, iccid)
+
+	if err = refreshCard(session); err != nil {
+		if driver.IsDeviceGone(err) {
+			return errorReply(endIfGone(session, err))
+		}
+		slog.Error("card did not come back after refresh, closing session", "client", remoteAddr, "device", session.Device, "error", err)
+		sessionsMu.Lock()
+		detachSession(session)
+		sessionsMu.Unlock()
+		releaseChannel(session)
+		return localnet.NewPacketErr(localnet.ErrCodeInternal, fmt.Sprintf("profile state changed but the card did not come back, session closed: %s", err))
+	}
+	session.touch()
+
+	slog.Info("profile state changed", "request", request.name, "client", remoteAddr, "device", session.Device, "iccid", iccid)
+	return localnet.NewPacketBody(localnet.CmdResponse, []byte{byte(localnet.ProfileResultOK)})
+}
+
+// setProfileState sends request for iccid with refreshFlag set:
+// BF3x { A0 { 5A <ICCID> } 81 01 FF }.
+func setProfileState(session *Session, request profileRequest, iccid string) (localnet.ProfileResult, error) {
+	encoded := encodeICCID(iccid)
+	identifier := append([]byte{0x5A, byte(len(encoded))}, encoded...)
+	content := append([]byte{0xA0, byte(len(identifier))}, identifier...)
+	content = append(content, 0x81, 0x01, 0xFF)
+	body := append([]byte{byte(request.tag >> 8), byte(request.tag), byte(len(content))}, content...)
+
+	data, err := isdrStoreData(session, strings.ToLower(request.name), body)
+	if err != nil {
+		return 0, fmt.Errorf("%s failed: %w", request.name, err)
+	}
+	return parseProfileResult(request, data)
+}
+
+// parseProfileResult extracts the result of an EnableProfileResponse or
+// DisableProfileResponse: BF3x 03 80 01 <result>.
+func parseProfileResult(request profileRequest, data []byte) (localnet.ProfileResult, error) {
+	tlvs, err := tlv.Parse(data)
+	if err != nil || len(tlvs) != 1 || tlvs[0].Tag != request.tag {
+		return 0, fmt.Errorf("malformed %s response: %X", request.name, data)
+	}
+	result := tlvs[0].Find(0x80)
+	if result == nil || len(result.Value) != 1 {
+		return 0, fmt.Errorf("no result in %s response: %X", request.name, data)
+	}
+	return localnet.ProfileResult(result.Value[0]), nil
+}
+
+// refreshCard follows a profile change: the eUICC has the modem reset it, so
+// the session's logical channels are gone and the driver may still hold
+// state from before. The channels are forgotten and the driver reopened
+// until the card selects the ISD-R again; callers hold the device lock.
+func refreshCard(session *Session) error {
+	sessionsMu.Lock()
+	session.LogicalChannels = nil
+	session.channelAIDs = nil
+	sessionsMu.Unlock()
+
+	var err error
+	for attempt := range refreshAttempts {
+		if attempt > 0 {
+			time.Sleep(refreshDelay)
+		}
+		if err = reopenChannel(session, "refresh"); err != nil {
+			if driver.IsDeviceGone(err) {
+				return err
+			}
+			slog.Debug("card not back after refresh", "device", session.Device, "attempt", attempt+1, "error", err)
+			continue
+		}
+		var channel byte
+		if channel, err = session.Channel.OpenLogicalChannel(localnet.ISDRAID); err != nil {
+			slog.Debug("isd-r not back after refresh", "device", session.Device, "attempt", attempt+1, "error", err)
+			continue
+		}
+		if err = session.Channel.CloseLogicalChannel(channel); err != nil {
+			slog.Warn("failed to close isd-r channel", "channel", channel, "for", "refresh", "error", err)
+		}
+		session.transcript.note("card back after refresh")
+		return nil
+	}
+	return err
+}
+
+// encodeICCID packs the digits of an ICCID into BCD, swapping the digits of
+// each byte and padding an odd count with F, as decodeICCID reverses.
+func encodeICCID(iccid string) []byte {
+	if len(iccid)%2 != 0 {
+		iccid += "F"
+	}
+	encoded := make([]byte, len(iccid)/2)
+	for i := range encoded {
+		encoded[i] = hexDigit(iccid[2*i+1])<<4 | hexDigit(iccid[2*i])
+	}
+	return encoded
+}
+
+func hexDigit(c byte) byte {
+	if c == 'F' {
+		return 0x0F
+	}
+	return c - '0'
+}
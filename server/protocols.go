@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// handleListProtocols lists the drivers clients may connect with, leaving
+// out those -allowProtos forbids, and whether each has what it needs on
+// this host. Like capabilities it needs no session, only a valid auth
+// token.
+func handleListProtocols(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	pcConn, ok := pcRcv.(localnet.IPacketConnect)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type for list protocols")
+	}
+
+	if !authTokenAllowed(pcConn.GetAuthToken()) {
+		slog.Warn("rejecting list protocols with invalid auth token", "client", remoteAddr)
+		return localnet.NewPacketErr(localnet.ErrCodeUnauthorized, "invalid auth token")
+	}
+
+	protocols := []localnet.ProtocolInfo{}
+	for _, name := range driver.Drivers() {
+		if !matchesAny(allowedProtos, name) {
+			continue
+		}
+		info := localnet.ProtocolInfo{Name: name, Slots: driver.UsesSlot(name), Available: true}
+		if err := driver.Available(name); err != nil {
+			info.Available = false
+			info.Reason = err.Error()
+		}
+		protocols = append(protocols, info)
+	}
+
+	body, err := json.Marshal(protocols)
+	if err != nil {
+		return errorReply(err)
+	}
+	slog.Debug("protocols listed", "client", remoteAddr, "protocols", len(protocols))
+	return localnet.NewPacketBody(localnet.CmdResponse, body)
+}
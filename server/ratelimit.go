@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// rateLimitPruneInterval is how often buckets that refilled completely are
+// dropped, so that clients which went away do not accumulate.
+const rateLimitPruneInterval = time.Minute
+
+// limiter throttles commands per client host, nil when -rateLimit is 0.
+var limiter *rateLimiter
+
+// rateLimiter is a token bucket per client host: each command takes a
+// token, tokens come back at rate per second up to burst.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from the bucket of remoteAddr and reports whether
+// there was one. A nil limiter allows everything.
+func (l *rateLimiter) allow(remoteAddr net.Addr) bool {
+	if l == nil {
+		return true
+	}
+
+	key := limiterKey(remoteAddr)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune drops full buckets until ctx is cancelled.
+func (l *rateLimiter) prune(ctx context.Context) {
+	ticker := time.NewTicker(rateLimitPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			l.mu.Lock()
+			for key, b := range l.buckets {
+				if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+					delete(l.buckets, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// limiterKey is the host of remoteAddr, so that a UDP client cannot dodge
+// its limit by switching source ports. Unix socket peers have no host and
+// are limited per connection.
+func limiterKey(remoteAddr net.Addr) string {
+	host, _, err := net.SplitHostPort(remoteAddr.String())
+	if err != nil {
+		return remoteAddr.String()
+	}
+	return host
+}
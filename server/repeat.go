@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// repeatable reports whether the reply to cmd is kept for CmdRepeatLast.
+// Commands that create or end the session, need none, or only keep it
+// alive, as a background Ping does, would bury the reply a client lost.
+func repeatable(cmd localnet.Cmd) bool {
+	switch cmd {
+	case localnet.CmdConnect, localnet.CmdConnectProof, localnet.CmdDisconnect, localnet.CmdListSlots, localnet.CmdStatus, localnet.CmdCapabilities, localnet.CmdAbort, localnet.CmdAdminKick, localnet.CmdHealth,
+		localnet.CmdPing, localnet.CmdSubscribe, localnet.CmdRepeatLast, localnet.CmdListProtocols:
+		return false
+	}
+	return true
+}
+
+// rememberReply keeps pcSnd as the last reply of the session pcRcv ran in,
+// errors included. Unlike the response cache it is kept whatever the
+// request ID, so clients sending none can recover a lost reply too.
+func rememberReply(pcRcv localnet.IPacketCmd, remoteAddr net.Addr, pcSnd localnet.IPacketCmd) {
+	if pcSnd == nil || !repeatable(pcRcv.GetCmd()) {
+		return
+	}
+
+	sessionsMu.RLock()
+	session, err := lookupSession(pcRcv, remoteAddr)
+	sessionsMu.RUnlock()
+	if err != nil {
+		return
+	}
+
+	// the copy stays as it was whatever happens to the reply on its way out
+	session.lastReply.Store(&cachedResponse{pcRcv.GetRequestID(), copyReply(pcSnd)})
+}
+
+// handleRepeatLast answers with the last reply of the session again, without
+// running anything on the card, for a client whose reply got lost. It does
+// not wait for the device either: a command still running has no reply to
+// repeat yet.
+func handleRepeatLast(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, err := peekSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	last := session.lastReply.Load()
+	if last == nil {
+		return localnet.NewPacketErr(localnet.ErrCodeNotFound, "nothing to repeat")
+	}
+	session.touch()
+
+	return copyReply(last.response)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/damonto/euicc-go/driver/qmi/core"
+)
+
+// QMI UIM messages the driver does not wrap.
+const (
+	qmiUIMPowerOffSIM core.MessageID = 0x0030
+	qmiUIMPowerOnSIM  core.MessageID = 0x0031
+)
+
+type qmiEmptyResponse struct{}
+
+func (r *qmiEmptyResponse) UnmarshalResponse(TLVs *core.TLVs) error { return nil }
+
+// resetSession recovers a card that stopped answering. QMI and QRTR power
+// cycle the SIM (cold reset); every driver then reopens its session with the
+// modem (warm reset), which is all AT and MBIM get. Logical channels do not
+// survive either, so the session's channels are closed first.
+func resetSession(session *Session) error {
+	session.closeLogicalChannels()
+
+	kind := "warm"
+	if client, ok := qmiClient(session.Channel); ok {
+		if err := powerCycleSIM(client); err != nil {
+			slog.Warn("sim power cycle failed, falling back to warm reset", "device", session.Device, "error", err)
+		} else {
+			kind = "cold"
+		}
+	}
+
+	if err := reopenChannel(session, "reset"); err != nil {
+		return err
+	}
+
+	slog.Info("card reset", "device", session.Device, "kind", kind)
+	return nil
+}
+
+// reopenChannel disconnects the session's driver and opens it again on the
+// same slot, or only opens it when the session has no channel; why names
+// the occasion in logs. The session has no channel when it fails.
+func reopenChannel(session *Session, why string) error {
+	if session.Channel != nil {
+		if err := session.Channel.Disconnect(); err != nil {
+			slog.Debug("failed to disconnect before "+why, "error", err)
+		}
+		session.Channel = nil
+	}
+
+	channel, err := driver.Open(session.Proto, session.Device, session.Slot)
+	if err != nil {
+		return err
+	}
+	if err = channel.Connect(); err != nil {
+		channel.Disconnect()
+		return err
+	}
+	session.Channel = channel
+	return nil
+}
+
+func powerCycleSIM(client *core.QMIClient) error {
+	for _, message := range []core.MessageID{qmiUIMPowerOffSIM, qmiUIMPowerOnSIM} {
+		if err := client.Transport.Transmit(qmiSlotRequest(client, message)); err != nil {
+			return fmt.Errorf("message 0x%04X: %w", uint16(message), err)
+		}
+	}
+	return waitForCard(client)
+}
+
+func qmiSlotRequest(client *core.QMIClient, message core.MessageID) *core.Request {
+	return &core.Request{
+		ClientID:      client.ClientID,
+		TransactionID: uint16(atomic.AddUint32(&client.TxnID, 1)),
+		MessageID:     message,
+		ServiceType:   core.QMIServiceUIM,
+		Value:         core.TLVs{{Type: 0x01, Len: 1, Value: []byte{client.Slot}}},
+		Response:      new(qmiEmptyResponse),
+	}
+}
+
+// waitForCard polls until the powered-on card is reported present again.
+func waitForCard(client *core.QMIClient) error {
+	for range 10 {
+		request := core.GetCardStatusRequest{
+			ClientID:      client.ClientID,
+			TransactionID: uint16(atomic.AddUint32(&client.TxnID, 1)),
+		}
+		if err := client.Transport.Transmit(request.Request()); err == nil {
+			for _, card := range request.Response.Cards {
+				if card.State == core.UIMCardStatusPresent {
+					return nil
+				}
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return errors.New("card not present after power on")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// sessionFile, when set, receives the sessions still open at a graceful
+// shutdown, and the next start lets their clients take them back for
+// restoreGrace.
+var (
+	sessionFile  string
+	restoreGrace = 60 * time.Second
+)
+
+// restorable holds the sessions loaded from sessionFile that no client has
+// taken back yet, keyed by session token, until restoreDeadline. Guarded by
+// sessionsMu.
+var (
+	restorable      = make(map[string]*savedSession)
+	restoreDeadline time.Time
+)
+
+// savedState is the content of sessionFile.
+type savedState struct {
+	SavedAt  time.Time       `json:"savedAt"`
+	Sessions []*savedSession `json:"sessions"`
+}
+
+// savedSession is what survives of a session across a restart: who held
+// which device, and the channels open on it. The card itself is not saved.
+type savedSession struct {
+	ID         string         `json:"id"`
+	RemoteAddr string         `json:"remoteAddr"`
+	Device     string         `json:"device"`
+	Proto      string         `json:"proto"`
+	Slot       uint16         `json:"slot"`
+	StartedAt  time.Time      `json:"startedAt"`
+	Channels   []savedChannel `json:"channels,omitempty"`
+}
+
+type savedChannel struct {
+	Channel byte   `json:"channel"`
+	AID     string `json:"aid"`
+}
+
+// saveSessions writes the open sessions to sessionFile before shutdown
+// closes them. Legacy sessions are left out: their clients present no
+// token to claim them with.
+func saveSessions() {
+	if sessionFile == "" {
+		return
+	}
+
+	state := savedState{SavedAt: time.Now()}
+	sessionsMu.RLock()
+	for _, session := range sessions {
+		if !session.tokenBound() {
+			continue
+		}
+		saved := &savedSession{
+			ID:         session.ID,
+			RemoteAddr: session.RemoteAddr.String(),
+			Device:     session.Device,
+			Proto:      session.Proto,
+			Slot:       session.Slot,
+			StartedAt:  session.StartedAt,
+		}
+		for _, channel := range session.LogicalChannels {
+			saved.Channels = append(saved.Channels, savedChannel{Channel: channel, AID: hex.EncodeToString(session.channelAIDs[channel])})
+		}
+		state.Sessions = append(state.Sessions, saved)
+	}
+	sessionsMu.RUnlock()
+	if len(state.Sessions) == 0 {
+		return
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		// the file holds session tokens
+		err = os.WriteFile(sessionFile, data, 0600)
+	}
+	if err != nil {
+		slog.Error("failed to save sessions", "file", sessionFile, "error", err)
+		return
+	}
+	slog.Info("saved sessions for restore", "file", sessionFile, "sessions", len(state.Sessions))
+}
+
+// loadSessions reads the sessions saved at the last shutdown and deletes
+// the file, so that a crash later on does not restore them a second time.
+// A file saved longer than restoreGrace ago is ignored: its clients have
+// long given up.
+func loadSessions() error {
+	if sessionFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(sessionFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading session file %s %w", sessionFile, err)
+	}
+	if err = os.Remove(sessionFile); err != nil {
+		return fmt.Errorf("error removing session file %s %w", sessionFile, err)
+	}
+
+	var state savedState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("error parsing session file %s %w", sessionFile, err)
+	}
+	if age := time.Since(state.SavedAt); age > restoreGrace {
+		slog.Warn("ignoring saved sessions older than the grace window", "file", sessionFile, "age", age)
+		return nil
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	restoreDeadline = time.Now().Add(restoreGrace)
+	for _, saved := range state.Sessions {
+		restorable[saved.ID] = saved
+	}
+	slog.Info("sessions restorable", "file", sessionFile, "sessions", len(restorable), "grace", restoreGrace)
+	return nil
+}
+
+// claimRestorable checks whether device is reserved by a saved session;
+// callers hold its device lock. The session's client gets it back when it
+// reconnects on the same protocol and slot, anyone else is told the device
+// is busy until restoreDeadline, unless -allowConcurrent lets them share it.
+func claimRestorable(device string, pcConn localnet.IPacketConnect, slot uint16) (*savedSession, error) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	if len(restorable) == 0 {
+		return nil, nil
+	}
+	if time.Now().After(restoreDeadline) {
+		slog.Info("grace window over, dropping unclaimed saved sessions", "sessions", len(restorable))
+		clear(restorable)
+		return nil, nil
+	}
+
+	for id, saved := range restorable {
+		if saved.Device != device {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(pcConn.GetSessionToken()), []byte(id)) != 1 {
+			if allowConcurrent {
+				continue
+			}
+			return nil, withCode(localnet.ErrCodeBusy, fmt.Errorf("device busy, reserved for %s until it reconnects after the restart", saved.RemoteAddr))
+		}
+		delete(restorable, id)
+		if saved.Proto != pcConn.GetProto() || saved.Slot != slot {
+			return nil, nil
+		}
+		return saved, nil
+	}
+	return nil, nil
+}
+
+// reopenChannels opens the saved channels again on a freshly connected
+// card, oldest first; callers hold the device lock. It reports whether
+// every channel came back on its old number, which lets the client carry
+// on as if the session had never ended. Otherwise the channels opened are
+// closed again and the client, told its session was not resumed, reopens
+// them itself.
+func reopenChannels(channel apdu.SmartCardChannel, saved *savedSession) bool {
+	var opened []byte
+	for _, sc := range saved.Channels {
+		got := localnet.InvalidChannel
+		aid, err := hex.DecodeString(sc.AID)
+		if err == nil {
+			got, err = channel.OpenLogicalChannel(aid)
+		}
+		if err == nil {
+			opened = append(opened, got)
+		}
+		if err != nil || got != sc.Channel {
+			slog.Warn("could not restore logical channel", "device", saved.Device, "channel", sc.Channel, "aid", sc.AID, "opened", got, "error", err)
+			for _, ch := range slices.Backward(opened) {
+				channel.CloseLogicalChannel(ch)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// restoredChannels lists the channels and AIDs of saved for a Session.
+func restoredChannels(saved *savedSession) ([]byte, map[byte][]byte) {
+	var channels []byte
+	aids := make(map[byte][]byte)
+	for _, sc := range saved.Channels {
+		channels = append(channels, sc.Channel)
+		aids[sc.Channel], _ = hex.DecodeString(sc.AID)
+	}
+	return channels, aids
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// handleSelect selects an application by AID on a channel the session has
+// open and answers with its FCI, following any 61xx with GET RESPONSE.
+func handleSelect(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
+	}
+	defer func() { unlock() }()
+
+	pktChannel, ok := pcRcv.(localnet.IPacketChannelBody)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type for select")
+	}
+
+	aid := pktChannel.GetBody()
+	if err = localnet.CheckAID(aid); err != nil {
+		return errorReply(err)
+	}
+	command := selectAPDU(pktChannel.GetChannel(), aid)
+	if err = checkTransmitChannel(session, pktChannel.GetChannel(), command); err != nil {
+		slog.Warn("rejecting select on wrong channel", "device", session.Device, "error", err)
+		return errorReply(err)
+	}
+
+	var fci []byte
+	started := time.Now()
+	if terr := callCard(session, timeoutFor(pcRcv), &unlock, func() {
+		session.transmits.Add(1)
+		fci, err = transmitCollect(session, command)
+	}); terr != nil {
+		return errorReply(terr)
+	}
+	transmitSeconds.Observe(time.Since(started).Seconds())
+	if err != nil {
+		slog.Warn("select failed", "device", session.Device, "aid", aid, "error", err)
+		return errorReply(endIfGone(session, err))
+	}
+
+	session.touch()
+
+	slog.Debug("select completed", "channel", pktChannel.GetChannel(), "fciLen", len(fci))
+
+	return localnet.NewPacketBody(localnet.CmdResponse, fci)
+}
+
+// selectAPDU builds SELECT by DF name, first or only occurrence, asking for
+// the FCI: 00 A4 04 00 Lc AID 00 with the channel in the class byte.
+func selectAPDU(channel byte, aid []byte) []byte {
+	command := append([]byte{channelCLA(0x00, channel), 0xA4, 0x04, 0x00, byte(len(aid))}, aid...)
+	return append(command, 0x00)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// handleSelfTest runs runSelfTest on the session's card and answers with
+// the result, failed steps included. Only a device gone or a command
+// timeout fail the command itself.
+func handleSelfTest(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
+	}
+	defer func() { unlock() }()
+
+	var result localnet.SelfTestResult
+	if terr := callCard(session, timeoutFor(pcRcv), &unlock, func() {
+		result, err = runSelfTest(session)
+	}); terr != nil {
+		return errorReply(terr)
+	}
+	if err != nil {
+		return errorReply(endIfGone(session, err))
+	}
+
+	session.touch()
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if result.OK {
+		slog.Info("self test passed", "client", remoteAddr, "device", session.Device, "duration", result.Duration)
+	} else {
+		slog.Warn("self test failed", "client", remoteAddr, "device", session.Device, "error", result.Err())
+	}
+	return localnet.NewPacketBody(localnet.CmdResponse, body)
+}
+
+// runSelfTest opens a channel on the ISD-R, reads the EID over it with
+// GetEUICCData and closes the channel again, recording each step; callers
+// hold the device lock. The channel is the test's own and never joins the
+// session's. A failed open skips the rest, a failed read still closes. The
+// error is set only when the device is gone.
+func runSelfTest(session *Session) (localnet.SelfTestResult, error) {
+	started := time.Now()
+	result := localnet.SelfTestResult{Steps: []localnet.SelfTestStep{
+		{Name: localnet.SelfTestOpenISDR},
+		{Name: localnet.SelfTestGetEID},
+		{Name: localnet.SelfTestCloseISDR},
+	}}
+	openStep, readStep, closeStep := &result.Steps[0], &result.Steps[1], &result.Steps[2]
+
+	var channel byte
+	err := selfTestStep(openStep, func() (string, error) {
+		var err error
+		if channel, err = session.Channel.OpenLogicalChannel(localnet.ISDRAID); err != nil {
+			return "", err
+		}
+		session.transcript.note("opened logical channel %d aid=%X for self test", channel, localnet.ISDRAID)
+		return fmt.Sprintf("channel %d", channel), localnet.CheckChannel(channel)
+	})
+	if err != nil {
+		readStep.Skipped, closeStep.Skipped = true, true
+		result.Duration = time.Since(started)
+		return result, goneError(err)
+	}
+
+	readErr := selfTestStep(readStep, func() (string, error) {
+		command := append([]byte{channelCLA(0x80, channel), 0xE2, 0x91, 0x00, byte(len(getEIDData))}, getEIDData...)
+		data, err := transmitCollect(session, command)
+		if err != nil {
+			return "", err
+		}
+		eid, err := parseEID(data)
+		if err != nil {
+			return "", err
+		}
+		result.EID = fmt.Sprintf("%X", eid)
+		return result.EID, nil
+	})
+	closeErr := selfTestStep(closeStep, func() (string, error) {
+		if err := session.Channel.CloseLogicalChannel(channel); err != nil {
+			return "", err
+		}
+		session.transcript.note("closed logical channel %d", channel)
+		return fmt.Sprintf("channel %d", channel), nil
+	})
+
+	result.OK = readErr == nil && closeErr == nil
+	result.Duration = time.Since(started)
+	if err = goneError(readErr); err == nil {
+		err = goneError(closeErr)
+	}
+	return result, err
+}
+
+// selfTestStep runs fn and records its detail, outcome and duration in step.
+func selfTestStep(step *localnet.SelfTestStep, fn func() (string, error)) error {
+	started := time.Now()
+	detail, err := fn()
+	step.Duration = time.Since(started)
+	step.Detail = detail
+	if err != nil {
+		step.Error = err.Error()
+		return err
+	}
+	step.OK = true
+	return nil
+}
+
+// goneError returns err when it means the device went away, nil otherwise.
+func goneError(err error) error {
+	if driver.IsDeviceGone(err) {
+		return err
+	}
+	return nil
+}
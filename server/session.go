@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// Session fields are guarded by two locks. Channel and transcript belong to
+// the device lock alone. The other fields change while holding both the device lock and
+// sessionsMu, so either one is enough to read them, except LastActivity:
+// commands that leave the card alone touch it under sessionsMu only.
+type Session struct {
+	ID              string
+	RemoteAddr      net.Addr
+	Device          string
+	Proto           string
+	Slot            uint16
+	Channel         apdu.SmartCardChannel
+	ProtocolVersion uint16
+	StartedAt       time.Time
+	LastActivity    time.Time
+	Timeout         time.Duration
+
+	// LogicalChannels lists the channels the client opened and has not
+	// closed, oldest first, so none outlives the session.
+	LogicalChannels []byte
+	// channelAIDs holds the AID each of LogicalChannels was opened on, for
+	// saveSessions.
+	channelAIDs map[byte][]byte
+	// card counts the sessions sharing Channel under -allowConcurrent.
+	card *sharedCard
+
+	// transmits, opens and closes count the card operations the session
+	// issued, so a client can compare them with its own view in CmdStatus.
+	transmits atomic.Uint64
+	opens     atomic.Uint64
+	closes    atomic.Uint64
+
+	// cardActivity is when the card last finished a command, in Unix
+	// nanoseconds, 0 before the first; keepingAlive is set while the
+	// -cardKeepAlive task pings the card.
+	cardActivity atomic.Int64
+	keepingAlive atomic.Bool
+
+	// lastError is the most recent command of the session that failed, nil
+	// if none has.
+	lastError atomic.Pointer[localnet.SessionError]
+
+	// lastReply is the most recent reply CmdRepeatLast can repeat, nil
+	// before the first.
+	lastReply atomic.Pointer[cachedResponse]
+
+	// abort interrupts the card I/O in progress, nil while there is none.
+	abortMu sync.Mutex
+	abort   func()
+
+	responses  *responseCache
+	transcript *transcript
+}
+
+// sessions holds every open session keyed by its ID, which clients speaking
+// ProtocolVersion2 or later echo as their session token.
+var (
+	sessionsMu sync.RWMutex
+	sessions   = make(map[string]*Session)
+)
+
+// minSessionTimeout and maxSessionTimeout bound the session timeout a
+// client may ask for on connect.
+var (
+	minSessionTimeout = 5 * time.Second
+	maxSessionTimeout = 10 * time.Minute
+)
+
+// requestedSessionTimeout returns the timeout of a session whose client
+// asked for requested, or sessionTimeout when it asked for none.
+func requestedSessionTimeout(requested time.Duration) (time.Duration, error) {
+	if requested == 0 {
+		return sessionTimeout, nil
+	}
+	if requested < minSessionTimeout || requested > maxSessionTimeout {
+		return 0, withCode(localnet.ErrCodeInvalidRequest, fmt.Errorf("requested session timeout %s out of range, allowed %s to %s", requested, minSessionTimeout, maxSessionTimeout))
+	}
+	return requested, nil
+}
+
+// deviceLocks serializes card operations per physical device, keyed by
+// deviceKey: sessions on different modems run concurrently while commands
+// for the same one queue up. Holding a device lock while taking sessionsMu
+// is fine, the other way round deadlocks.
+var deviceLocks keyedMutex
+
+// tokenBound reports whether the client identifies itself with the session
+// token rather than its address.
+func (s *Session) tokenBound() bool {
+	return s.ProtocolVersion >= localnet.ProtocolVersion2
+}
+
+func (s *Session) expired() bool {
+	return time.Since(s.LastActivity) > s.Timeout
+}
+
+// cardIdle is how long the session's card has gone without a command.
+func (s *Session) cardIdle() time.Duration {
+	if last := s.cardActivity.Load(); last != 0 {
+		return time.Since(time.Unix(0, last))
+	}
+	return time.Since(s.StartedAt)
+}
+
+// touch records activity on the session, unless it has ended meanwhile.
+func (s *Session) touch() {
+	sessionsMu.Lock()
+	if sessions[s.ID] == s {
+		s.LastActivity = time.Now()
+	}
+	sessionsMu.Unlock()
+}
+
+// addLogicalChannel records a logical channel opened on aid; callers hold
+// the device lock.
+func (s *Session) addLogicalChannel(channel byte, aid []byte) {
+	sessionsMu.Lock()
+	s.LogicalChannels = append(s.LogicalChannels, channel)
+	if s.channelAIDs == nil {
+		s.channelAIDs = make(map[byte][]byte)
+	}
+	s.channelAIDs[channel] = bytes.Clone(aid)
+	s.LastActivity = time.Now()
+	sessionsMu.Unlock()
+}
+
+// removeLogicalChannel forgets a closed logical channel; callers hold the
+// device lock.
+func (s *Session) removeLogicalChannel(channel byte) {
+	sessionsMu.Lock()
+	s.LogicalChannels = slices.DeleteFunc(s.LogicalChannels, func(c byte) bool { return c == channel })
+	delete(s.channelAIDs, channel)
+	s.LastActivity = time.Now()
+	sessionsMu.Unlock()
+}
+
+// lastLogicalChannel returns the most recently opened channel still open,
+// or InvalidChannel; callers hold the device lock.
+func (s *Session) lastLogicalChannel() byte {
+	if len(s.LogicalChannels) == 0 {
+		return localnet.InvalidChannel
+	}
+	return s.LogicalChannels[len(s.LogicalChannels)-1]
+}
+
+// closeLogicalChannels closes every channel the client left open, newest
+// first; callers hold the device lock. Failures are logged only, the
+// channels are forgotten either way.
+func (s *Session) closeLogicalChannels() {
+	failed := 0
+	for _, channel := range slices.Backward(s.LogicalChannels) {
+		if err := s.Channel.CloseLogicalChannel(channel); err != nil {
+			slog.Warn("failed to close logical channel", "device", s.Device, "channel", channel, "error", err)
+			failed++
+			continue
+		}
+		s.transcript.note("closed logical channel %d", channel)
+	}
+	if len(s.LogicalChannels) > 0 {
+		slog.Debug("closed logical channels", "device", s.Device, "closed", len(s.LogicalChannels)-failed, "failed", failed)
+	}
+	sessionsMu.Lock()
+	s.LogicalChannels = nil
+	s.channelAIDs = nil
+	sessionsMu.Unlock()
+}
+
+// deviceKey names the physical device a connect targets; sessions sharing a
+// key would talk to the same modem. QRTR has no device path, so all its
+// slots share one key.
+func deviceKey(proto string, device string) string {
+	if proto == "qrtr" {
+		return "qrtr"
+	}
+	return device
+}
+
+func sessionForDevice(device string) *Session {
+	for _, session := range sessions {
+		if session.Device == device {
+			return session
+		}
+	}
+	return nil
+}
+
+func legacySessionFor(remoteAddr net.Addr) *Session {
+	for _, session := range sessions {
+		if !session.tokenBound() && addressesEqual(session.RemoteAddr, remoteAddr) {
+			return session
+		}
+	}
+	return nil
+}
+
+// lookupSession finds the session a packet belongs to, by the session token
+// it carries or, for legacy clients, by their address.
+func lookupSession(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) (*Session, error) {
+	if token := pcRcv.GetSessionToken(); token != "" {
+		session, ok := sessions[token]
+		if !ok && wasOverdue(pcRcv, remoteAddr) {
+			return nil, errSessionOverdue
+		}
+		if !ok {
+			return nil, withCode(localnet.ErrCodeExpired, errors.New("invalid session token"))
+		}
+		return session, nil
+	}
+
+	if session := legacySessionFor(remoteAddr); session != nil {
+		return session, nil
+	}
+	if wasOverdue(pcRcv, remoteAddr) {
+		return nil, errSessionOverdue
+	}
+	return nil, withCode(localnet.ErrCodeExpired, errors.New("no active session, connect first"))
+}
+
+// acquireSession finds the session a packet belongs to and takes its device
+// lock, which the caller releases with unlock. The session may end while
+// waiting for the lock, so it is checked again once the lock is held.
+func acquireSession(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) (session *Session, unlock func(), err error) {
+	sessionsMu.RLock()
+	session, err = lookupSession(pcRcv, remoteAddr)
+	sessionsMu.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unlock = deviceLocks.lock(session.Device)
+
+	sessionsMu.Lock()
+	expired := false
+	switch {
+	case sessions[session.ID] != session:
+		err = withCode(localnet.ErrCodeExpired, errors.New("session closed"))
+	case session.expired():
+		slog.Warn("session expired during operation", "client", session.RemoteAddr)
+		detachSession(session)
+		expired = true
+		err = withCode(localnet.ErrCodeExpired, errors.New("session expired"))
+	case session.overdue():
+		slog.Warn("session reached maximum duration", "client", session.RemoteAddr, "device", session.Device)
+		detachSession(session)
+		markOverdue(session)
+		expired = true
+		err = errSessionOverdue
+	}
+	sessionsMu.Unlock()
+
+	if expired {
+		releaseChannel(session)
+	}
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return session, unlock, nil
+}
+
+// peekSession finds the session a packet belongs to without taking its
+// device lock, for commands that leave the card alone and so need not queue
+// behind one using it. A session due to end is ended through acquireSession.
+func peekSession(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) (*Session, error) {
+	sessionsMu.RLock()
+	session, err := lookupSession(pcRcv, remoteAddr)
+	due := err == nil && (session.expired() || session.overdue())
+	sessionsMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if !due {
+		return session, nil
+	}
+
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	unlock()
+	return session, nil
+}
+
+func sessionCleanup(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, session := range dropSessions((*Session).expired) {
+				slog.Info("cleaned up expired session",
+					"client", session.RemoteAddr,
+					"device", session.Device,
+					"idleTime", time.Since(session.LastActivity))
+			}
+			dropOverdue()
+		}
+	}
+}
+
+// detachSession removes session from the table; callers hold sessionsMu.
+// The card stays connected until releaseChannel.
+func detachSession(session *Session) {
+	delete(sessions, session.ID)
+	sessionsChanged()
+	sessionEnded(session)
+}
+
+// releaseChannel closes the card connection of a detached session, or only
+// its logical channels while other sessions share the card; callers hold
+// its device lock.
+func releaseChannel(session *Session) error {
+	defer session.transcript.close()
+	session.transmits.Store(0)
+	session.opens.Store(0)
+	session.closes.Store(0)
+	session.lastError.Store(nil)
+	session.lastReply.Store(nil)
+	if session.Channel == nil {
+		return nil
+	}
+	session.closeLogicalChannels()
+	shared := session.shared()
+	if session.card != nil {
+		session.card.users--
+	}
+	var err error
+	if !shared {
+		err = session.Channel.Disconnect()
+	}
+	session.Channel = nil
+	return err
+}
+
+// sessionsForDevice returns every session on device; callers hold
+// sessionsMu.
+func sessionsForDevice(device string) []*Session {
+	var found []*Session
+	for _, session := range sessions {
+		if session.Device == device {
+			found = append(found, session)
+		}
+	}
+	return found
+}
+
+// dropSessions ends every session matching match, one device at a time, and
+// returns the ones it ended.
+func dropSessions(match func(*Session) bool) []*Session {
+	sessionsMu.RLock()
+	var candidates []*Session
+	for _, session := range sessions {
+		if match(session) {
+			candidates = append(candidates, session)
+		}
+	}
+	sessionsMu.RUnlock()
+
+	var dropped []*Session
+	for _, session := range candidates {
+		unlock := deviceLocks.lock(session.Device)
+
+		sessionsMu.Lock()
+		live := sessions[session.ID] == session && match(session)
+		if live {
+			detachSession(session)
+		}
+		sessionsMu.Unlock()
+
+		if live {
+			releaseChannel(session)
+			dropped = append(dropped, session)
+		}
+		unlock()
+	}
+	return dropped
+}
+
+func cleanupAllSessions() {
+	dropSessions(func(*Session) bool { return true })
+}
+
+func addressesEqual(a1, a2 net.Addr) bool {
+	if a1 == nil || a2 == nil {
+		return false
+	}
+	switch t1 := a1.(type) {
+	case *net.UDPAddr:
+		t2, ok := a2.(*net.UDPAddr)
+		return ok && t1.IP.Equal(t2.IP) && t1.Zone == t2.Zone && t1.Port == t2.Port
+	case *net.TCPAddr:
+		t2, ok := a2.(*net.TCPAddr)
+		return ok && t1.IP.Equal(t2.IP) && t1.Zone == t2.Zone && t1.Port == t2.Port
+	}
+	return a1.Network() == a2.Network() && a1.String() == a2.String()
+}
+
+// addrKey identifies a client address in maps shared by all listeners. The
+// network is part of it: a UDP and a TCP client may have the same address.
+func addrKey(addr net.Addr) string {
+	return addr.Network() + "/" + addr.String()
+}
+
+// releaseSession drops the sessions opened over a stream connection when it
+// goes away.
+func releaseSession(remoteAddr net.Addr) {
+	dropped := dropSessions(func(session *Session) bool {
+		return addressesEqual(session.RemoteAddr, remoteAddr)
+	})
+	for _, session := range dropped {
+		slog.Info("connection closed, released session", "client", remoteAddr, "device", session.Device)
+	}
+}
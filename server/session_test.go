@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/avwarez/euicc-go/driver/localnet"
+	"github.com/avwarez/euicc-go/driver/mock"
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// recordingCard is a mock card remembering the logical channels closed on
+// it, which Disconnect would otherwise hide by resetting the card.
+type recordingCard struct {
+	*mock.Card
+
+	mu     sync.Mutex
+	closed []byte
+}
+
+func (c *recordingCard) CloseLogicalChannel(channel byte) error {
+	err := c.Card.CloseLogicalChannel(channel)
+	if err == nil {
+		c.mu.Lock()
+		c.closed = append(c.closed, channel)
+		c.mu.Unlock()
+	}
+	return err
+}
+
+func (c *recordingCard) closedChannels() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.closed)
+}
+
+// recordingCards maps each device to the card last opened on it under the
+// "mockrec" protocol.
+var (
+	recordingCardsMu sync.Mutex
+	recordingCards   = map[string]*recordingCard{}
+)
+
+func init() {
+	driver.RegisterDriver("mockrec", func(device string, slot uint16) (apdu.SmartCardChannel, error) {
+		card := &recordingCard{Card: mock.New()}
+		recordingCardsMu.Lock()
+		recordingCards[device] = card
+		recordingCardsMu.Unlock()
+		return card, nil
+	})
+}
+
+// connectMock starts a session on a recording mock card for device and
+// returns its token and card; the sessions are cleaned up when the test ends.
+func connectMock(t *testing.T, device string, remoteAddr net.Addr) (string, *recordingCard) {
+	t.Helper()
+	t.Cleanup(cleanupAllSessions)
+
+	pcSnd := handleCommand(localnet.NewPacketConnect(device, "mockrec", 0, ""), remoteAddr, nil)
+	if pcSnd.GetErr() != "" {
+		t.Fatalf("connect: %s", pcSnd.GetErr())
+	}
+	recordingCardsMu.Lock()
+	defer recordingCardsMu.Unlock()
+	return pcSnd.GetSessionToken(), recordingCards[device]
+}
+
+// openChannels opens n logical channels in the session and returns them in
+// the order they were opened.
+func openChannels(t *testing.T, token string, remoteAddr net.Addr, n int) []byte {
+	t.Helper()
+	var channels []byte
+	for range n {
+		pcRcv := localnet.NewPacketBody(localnet.CmdOpenLogical, []byte{0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10})
+		pcRcv.SetSessionToken(token)
+		pcSnd := handleCommand(pcRcv, remoteAddr, nil)
+		if pcSnd.GetErr() != "" {
+			t.Fatalf("open logical channel: %s", pcSnd.GetErr())
+		}
+		channels = append(channels, pcSnd.(localnet.IPacketBody).GetBody()[0])
+	}
+	return channels
+}
+
+func TestCleanupClosesEveryLogicalChannel(t *testing.T) {
+	remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}
+	token, card := connectMock(t, "/dev/mock-cleanup", remoteAddr)
+	channels := openChannels(t, token, remoteAddr, 2)
+
+	cleanupAllSessions()
+
+	// newest first, as the card nests them
+	if closed := card.closedChannels(); !slices.Equal(closed, []byte{channels[1], channels[0]}) {
+		t.Fatalf("closed channels %v, want %v", closed, []byte{channels[1], channels[0]})
+	}
+}
+
+func TestDisconnectClosesEveryLogicalChannel(t *testing.T) {
+	remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4001}
+	token, card := connectMock(t, "/dev/mock-disconnect", remoteAddr)
+	channels := openChannels(t, token, remoteAddr, 3)
+
+	pcRcv := localnet.NewPacketCmd(localnet.CmdDisconnect)
+	pcRcv.SetSessionToken(token)
+	if pcSnd := handleCommand(pcRcv, remoteAddr, nil); pcSnd.GetErr() != "" {
+		t.Fatalf("disconnect: %s", pcSnd.GetErr())
+	}
+
+	closed := card.closedChannels()
+	slices.Reverse(closed)
+	if !slices.Equal(closed, channels) {
+		t.Fatalf("closed channels %v, want all of %v", card.closedChannels(), channels)
+	}
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	if _, ok := sessions[token]; ok {
+		t.Fatal("session still open after disconnect")
+	}
+}
+
+func TestAddressesEqual(t *testing.T) {
+	udp := func(addr string) net.Addr {
+		a, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	tests := []struct {
+		name  string
+		a1    net.Addr
+		a2    net.Addr
+		equal bool
+	}{
+		{"same IPv4", udp("192.0.2.1:4000"), udp("192.0.2.1:4000"), true},
+		{"other port", udp("192.0.2.1:4000"), udp("192.0.2.1:4001"), false},
+		{"same IPv6", udp("[2001:db8::1]:4000"), udp("[2001:db8::1]:4000"), true},
+		{"IPv6 written differently", udp("[2001:db8:0:0::1]:4000"), udp("[2001:db8::1]:4000"), true},
+		{"other IPv6", udp("[2001:db8::1]:4000"), udp("[2001:db8::2]:4000"), false},
+		// a dual-stack socket reports IPv4 clients as mapped addresses
+		{"IPv4-mapped", udp("[::ffff:192.0.2.1]:4000"), udp("192.0.2.1:4000"), true},
+		{"same zone", udp("[fe80::1%eth0]:4000"), udp("[fe80::1%eth0]:4000"), true},
+		// link-local addresses on different links are different hosts
+		{"other zone", udp("[fe80::1%eth0]:4000"), udp("[fe80::1%eth1]:4000"), false},
+		{"zone and none", udp("[fe80::1%eth0]:4000"), udp("[fe80::1]:4000"), false},
+		{"UDP and TCP", udp("[2001:db8::1]:4000"), &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4000}, false},
+		{"same unix", &net.UnixAddr{Name: "@", Net: "unix"}, &net.UnixAddr{Name: "@", Net: "unix"}, true},
+		{"nil", udp("192.0.2.1:4000"), nil, false},
+	}
+	for _, tt := range tests {
+		if got := addressesEqual(tt.a1, tt.a2); got != tt.equal {
+			t.Errorf("%s: addressesEqual(%v, %v) = %v, want %v", tt.name, tt.a1, tt.a2, got, tt.equal)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/avwarez/euicc-go/driver/localnet"
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver/qmi"
+	"github.com/damonto/euicc-go/driver/qmi/core"
+)
+
+// connectSlot checks the slot a connect asks for against its protocol and
+// returns the slot the session records: 0 for drivers that ignore it, so a
+// client passing one anyway still resumes its session.
+func connectSlot(proto string, slot uint16) (uint16, error) {
+	if !driver.UsesSlot(proto) {
+		return 0, nil
+	}
+	if slot == 0 {
+		return 0, withCode(localnet.ErrCodeInvalidRequest,
+			fmt.Errorf("invalid slot 0 for %s, slots are numbered from 1, list them with slot", proto))
+	}
+	return slot, nil
+}
+
+// listSlots queries the UIM service for its physical slots. Only the QMI
+// based drivers expose slot status; MBIM and AT report a single slot through
+// the connect path and are not enumerated.
+func listSlots(proto string, device string) ([]localnet.SlotInfo, error) {
+	var channel apdu.SmartCardChannel
+	var err error
+	switch proto {
+	case "qmi":
+		channel, err = qmi.New(device, 1)
+	case "qrtr":
+		channel, err = qmi.NewQRTR(1)
+	default:
+		return nil, withCode(localnet.ErrCodeUnsupportedProto, fmt.Errorf("slot listing not supported for protocol: %s", proto))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Disconnect()
+
+	client, ok := qmiClient(channel)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %s channel %T", proto, channel)
+	}
+
+	request := core.GetSlotStatusRequest{
+		ClientID:      client.ClientID,
+		TransactionID: uint16(atomic.AddUint32(&client.TxnID, 1)),
+	}
+	if err = client.Transport.Transmit(request.Request()); err != nil {
+		return nil, err
+	}
+
+	slots := make([]localnet.SlotInfo, 0, len(request.Response.Slots))
+	for i, slot := range request.Response.Slots {
+		slots = append(slots, localnet.SlotInfo{
+			Slot:        uint16(i + 1),
+			CardPresent: slot.CardState == core.UIMPhysicalCardStatePresent,
+			Active:      slot.SlotState == core.UIMSlotStateActive,
+		})
+	}
+	return slots, nil
+}
+
+// qmiClient exposes the UIM client behind the QMI and QRTR drivers so
+// requests the drivers do not wrap can be sent directly.
+func qmiClient(channel apdu.SmartCardChannel) (*core.QMIClient, bool) {
+	switch c := channel.(type) {
+	case *qmi.QMI:
+		return &c.QMIClient, true
+	case *qmi.QRTR:
+		return &c.QMIClient, true
+	}
+	return nil, false
+}
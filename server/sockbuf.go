@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+)
+
+// setSocketBuffers sets the kernel buffers of the UDP socket, leaving the OS
+// default where a size is 0, and logs the sizes the kernel settled on: it
+// may clamp them to its limits, net.core.rmem_max and wmem_max on Linux.
+// Too small a receive buffer drops bursts of datagrams without a trace.
+func setSocketBuffers(conn *net.UDPConn, read, write int) error {
+	if read > 0 {
+		if err := conn.SetReadBuffer(read); err != nil {
+			return fmt.Errorf("error setting udp read buffer %w", err)
+		}
+	}
+	if write > 0 {
+		if err := conn.SetWriteBuffer(write); err != nil {
+			return fmt.Errorf("error setting udp write buffer %w", err)
+		}
+	}
+
+	effectiveRead, effectiveWrite, err := socketBuffers(conn)
+	if err != nil {
+		slog.Warn("cannot read udp socket buffer sizes", "error", err)
+		return nil
+	}
+	slog.Info("udp socket buffers", "read", effectiveRead, "write", effectiveWrite, "requestedRead", read, "requestedWrite", write)
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// socketBuffers returns the receive and send buffer sizes of conn. Linux
+// reports twice what was asked for, the extra half being its bookkeeping.
+func socketBuffers(conn syscall.Conn) (read, write int, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if read, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF); sockErr != nil {
+			return
+		}
+		write, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return read, write, sockErr
+}
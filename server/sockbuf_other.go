@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// socketBuffers would return the buffer sizes of conn; reading them back is
+// only implemented on linux.
+func socketBuffers(conn syscall.Conn) (read, write int, err error) {
+	return 0, 0, errors.New("socket buffer sizes are only reported on linux")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+var (
+	serverStartedAt = time.Now()
+	requestsServed  atomic.Uint64
+)
+
+// handleStatus reports the server state. It is read-only and open to any
+// client that passes the auth token check; session tokens are never listed.
+func handleStatus(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	pcConn, ok := pcRcv.(localnet.IPacketConnect)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type for status")
+	}
+
+	if !authTokenAllowed(pcConn.GetAuthToken()) {
+		slog.Warn("rejecting status with invalid auth token", "client", remoteAddr)
+		return localnet.NewPacketErr(localnet.ErrCodeUnauthorized, "invalid auth token")
+	}
+
+	status := localnet.ServerStatus{
+		StartedAt:      serverStartedAt,
+		Uptime:         time.Since(serverStartedAt),
+		RequestsServed: requestsServed.Load(),
+		Sessions:       []localnet.SessionStatus{},
+	}
+
+	sessionsMu.RLock()
+	for _, session := range sessions {
+		s := localnet.SessionStatus{
+			Client:          session.RemoteAddr.String(),
+			Device:          session.Device,
+			Proto:           session.Proto,
+			Slot:            session.Slot,
+			ProtocolVersion: session.ProtocolVersion,
+			StartedAt:       session.StartedAt,
+			Idle:            time.Since(session.LastActivity),
+			Timeout:         session.Timeout,
+			Transmits:       session.transmits.Load(),
+			Opens:           session.opens.Load(),
+			Closes:          session.closes.Load(),
+			LastError:       session.lastError.Load(),
+		}
+		for _, channel := range session.LogicalChannels {
+			s.LogicalChannels = append(s.LogicalChannels, int(channel))
+		}
+		if len(session.LogicalChannels) > 0 {
+			channel := session.lastLogicalChannel()
+			s.LogicalChannel = &channel
+		}
+		status.Sessions = append(status.Sessions, s)
+	}
+	sessionsMu.RUnlock()
+
+	body, err := json.Marshal(status)
+	if err != nil {
+		return errorReply(err)
+	}
+	return localnet.NewPacketBody(localnet.CmdResponse, body)
+}
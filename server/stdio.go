@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// serveStdio serves the parent process of a server started with -transport
+// stdio: length-prefixed packets arrive on stdin and answers leave on
+// stdout, framed like TCP. Logs go to stderr and keep out of the stream. It
+// returns when stdin closes, which shuts the server down with its parent,
+// or when ctx ends.
+func serveStdio(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveMessages(ctx, os.Stdin, connAddr{network: "stdio", id: 1},
+			func() ([]byte, error) { return localnet.ReadFrame(os.Stdin) },
+			func(data []byte) error { return localnet.WriteFrame(os.Stdout, data) })
+	}()
+
+	// closing a blocking pipe does not interrupt a pending read, so shutdown
+	// does not wait for it
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
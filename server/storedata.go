@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+// handleStoreData sends a blob to the ISD-R as a chain of STORE DATA
+// commands on a channel the session has open, so clients installing a
+// profile do not number and flag the blocks themselves. The answer is the
+// response data of every block, concatenated.
+func handleStoreData(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
+	}
+	defer func() { unlock() }()
+
+	pktChannel, ok := pcRcv.(localnet.IPacketChannelBody)
+	if !ok {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type for store data")
+	}
+
+	commands, err := storeDataAPDUs(pktChannel.GetChannel(), pktChannel.GetBody())
+	if err != nil {
+		return errorReply(err)
+	}
+	if err = checkTransmitChannel(session, pktChannel.GetChannel(), commands[0]); err != nil {
+		slog.Warn("rejecting store data on wrong channel", "device", session.Device, "error", err)
+		return errorReply(err)
+	}
+
+	var response []byte
+	started := time.Now()
+	if terr := callCard(session, timeoutFor(pcRcv), &unlock, func() {
+		response, err = storeData(session, commands)
+	}); terr != nil {
+		return errorReply(terr)
+	}
+	transmitSeconds.Observe(time.Since(started).Seconds())
+	if err != nil {
+		slog.Warn("store data failed", "device", session.Device, "error", err)
+		return errorReply(endIfGone(session, err))
+	}
+
+	session.touch()
+
+	slog.Debug("store data completed", "channel", pktChannel.GetChannel(), "blocks", len(commands), "responseLen", len(response))
+
+	return localnet.NewPacketBody(localnet.CmdResponse, response)
+}
+
+// storeData sends the blocks in order, stopping at the first the card
+// refuses, and gathers their response data.
+func storeData(session *Session, commands [][]byte) ([]byte, error) {
+	var response []byte
+	for i, command := range commands {
+		session.transmits.Add(1)
+		data, err := transmitCollect(session, command)
+		if err != nil {
+			return nil, fmt.Errorf("store data block %d of %d: %w", i+1, len(commands), err)
+		}
+		response = append(response, data...)
+	}
+	return response, nil
+}
+
+// storeDataAPDUs splits data into STORE DATA commands as SGP.22 chains them:
+// 80 E2 P1 P2 Lc block, with P1 11 on every block but the last, which has
+// 91, and P2 numbering the blocks from 0.
+func storeDataAPDUs(channel byte, data []byte) ([][]byte, error) {
+	if len(data) == 0 || len(data) > localnet.MaxStoreDataSize {
+		return nil, withCode(localnet.ErrCodeInvalidRequest,
+			fmt.Errorf("invalid store data size: %d, allowed 1 to %d", len(data), localnet.MaxStoreDataSize))
+	}
+
+	var commands [][]byte
+	for block := 0; len(data) > 0; block++ {
+		n := min(len(data), localnet.StoreDataBlockSize)
+		p1 := byte(0x11)
+		if n == len(data) {
+			p1 = 0x91
+		}
+		command := append([]byte{channelCLA(0x80, channel), 0xE2, p1, byte(block), byte(n)}, data[:n]...)
+		commands = append(commands, command)
+		data = data[n:]
+	}
+	return commands, nil
+}
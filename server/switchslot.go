@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+func handleSwitchSlot(pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	session, unlock, err := acquireSession(pcRcv, remoteAddr)
+	if err != nil {
+		return errorReply(err)
+	}
+	defer unlock()
+
+	// slots above 255 come as two bytes, big-endian
+	pktBody, ok := pcRcv.(localnet.IPacketBody)
+	if !ok || len(pktBody.GetBody()) < 1 || len(pktBody.GetBody()) > 2 {
+		return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "invalid packet type")
+	}
+	var slot uint16
+	for _, b := range pktBody.GetBody() {
+		slot = slot<<8 | uint16(b)
+	}
+
+	if !driver.UsesSlot(session.Proto) {
+		return localnet.NewPacketErr(localnet.ErrCodeUnsupportedProto, fmt.Sprintf("protocol %s cannot switch slots", session.Proto))
+	}
+	if _, err = connectSlot(session.Proto, slot); err != nil {
+		return errorReply(err)
+	}
+	if slot == session.Slot {
+		session.touch()
+		return localnet.NewPacketCmd(localnet.CmdResponse)
+	}
+	if session.shared() {
+		return errorReply(errSharedCard)
+	}
+
+	previous := session.Slot
+	if err = switchSlot(session, slot); err != nil {
+		if driver.IsDeviceGone(err) {
+			return errorReply(endIfGone(session, err))
+		}
+		if errors.Is(err, driver.ErrSlotOutOfRange) && session.Channel != nil {
+			// refused before touching the card, the session goes on
+			return errorReply(err)
+		}
+		slog.Error("slot switch failed, closing session", "client", remoteAddr, "device", session.Device, "slot", slot, "error", err)
+		sessionsMu.Lock()
+		detachSession(session)
+		sessionsMu.Unlock()
+		releaseChannel(session)
+		return localnet.NewPacketErr(localnet.ErrCodeInternal, fmt.Sprintf("slot switch failed, session closed: %s", err))
+	}
+
+	sessionsMu.Lock()
+	session.Slot = slot
+	session.LastActivity = time.Now()
+	sessionsMu.Unlock()
+	session.transcript.note("switched from slot %d to slot %d", previous, slot)
+
+	slog.Info("slot switched", "client", remoteAddr, "device", session.Device, "from", previous, "to", slot)
+	return localnet.NewPacketCmd(localnet.CmdResponse)
+}
+
+// switchSlot makes slot the active slot of the session's modem; callers hold
+// the device lock. Logical channels belong to the old card and are closed
+// first. QMI and QRTR activate the slot over the UIM client they already
+// hold; other drivers, MBIM among them, only activate a slot when they
+// connect, so their channel is reopened on the new slot.
+func switchSlot(session *Session, slot uint16) error {
+	if client, ok := qmiClient(session.Channel); ok {
+		s, err := driver.ByteSlot(slot)
+		if err != nil {
+			return err
+		}
+		session.closeLogicalChannels()
+		// Connect activates client.Slot and then sets it back to 1, the
+		// logical slot the activated one is mapped to
+		client.Slot = s
+		return client.Connect()
+	}
+
+	session.closeLogicalChannels()
+	if err := session.Channel.Disconnect(); err != nil {
+		slog.Debug("failed to disconnect before slot switch", "error", err)
+	}
+	session.Channel = nil
+
+	channel, err := driver.Open(session.Proto, session.Device, slot)
+	if err != nil {
+		return err
+	}
+	if err = channel.Connect(); err != nil {
+		channel.Disconnect()
+		return err
+	}
+	session.Channel = channel
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver"
+	"github.com/avwarez/euicc-go/driver/localnet"
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// commandTimeout bounds the card I/O of a single command, 0 for no limit.
+var commandTimeout time.Duration
+
+// connectTimeout bounds opening and connecting the driver in conn, 0 for no
+// limit.
+var connectTimeout = 30 * time.Second
+
+var (
+	// errCommandTimedOut answers a command whose card I/O outlasted its
+	// limit.
+	errCommandTimedOut = errors.New("command timed out")
+	// errConnectTimedOut answers a conn whose driver did not connect in
+	// time.
+	errConnectTimedOut = errors.New("connect timed out")
+)
+
+// timeoutFor returns the limit for a command: the shorter of -commandTimeout
+// and the time the client said it will wait, whichever are set.
+func timeoutFor(pcRcv localnet.IPacketCmd) time.Duration {
+	return shorterTimeout(commandTimeout, pcRcv)
+}
+
+// shorterTimeout returns the shorter of limit and the time the client of
+// pcRcv said it will wait, whichever are set.
+func shorterTimeout(limit time.Duration, pcRcv localnet.IPacketCmd) time.Duration {
+	if client := pcRcv.GetTimeout(); client > 0 && (limit == 0 || client < limit) {
+		return client
+	}
+	return limit
+}
+
+// openDriver opens and connects the driver of a new session for at most
+// timeout; callers hold the device lock. An unresponsive modem can block
+// both for a long time, and neither can be interrupted, so on timeout the
+// attempt is left running with the device lock, as in callCard, and the
+// channel it opens in the end is disconnected again.
+func openDriver(proto, device string, slot uint16, timeout time.Duration, unlock *func()) (apdu.SmartCardChannel, error) {
+	type opened struct {
+		channel apdu.SmartCardChannel
+		err     error
+	}
+	started := time.Now()
+	done := make(chan opened, 1)
+	go func() {
+		channel, err := driver.Open(proto, device, slot)
+		if err == nil {
+			if err = channel.Connect(); err != nil {
+				channel = nil
+			}
+		}
+		done <- opened{channel, err}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case result := <-done:
+		return result.channel, result.err
+	case <-expired:
+	}
+
+	slog.Warn("connect timed out", "device", device, "protocol", proto, "slot", slot, "timeout", timeout)
+	release := *unlock
+	*unlock = func() {}
+	inFlight.hold()
+	go func() {
+		result := <-done
+		if result.channel != nil {
+			result.channel.Disconnect()
+		}
+		release()
+		inFlight.leave()
+		slog.Info("abandoned connect finished", "device", device, "duration", time.Since(started), "error", result.err)
+	}()
+	return nil, fmt.Errorf("%w after %s", errConnectTimedOut, timeout)
+}
+
+// callCard runs fn, the card I/O of a command, for at most timeout, or
+// until the client aborts it. Driver calls cannot always be interrupted, so
+// on timeout or abort fn may keep running and takes over the device lock:
+// *unlock becomes a no-op and the lock is released once fn returns. Later
+// commands for the device queue behind it instead of talking over it, and
+// find the channel usable again once the card has answered. Shutdown drains
+// fn like a running command.
+func callCard(session *Session, timeout time.Duration, unlock *func(), fn func()) error {
+	aborted := session.startCommand()
+	started := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer session.endCommand()
+		fn()
+		session.cardActivity.Store(time.Now().UnixNano())
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	select {
+	case <-done:
+		return nil
+	case <-expired:
+		slog.Warn("command timed out", "client", session.RemoteAddr, "device", session.Device, "timeout", timeout)
+		err = fmt.Errorf("%w after %s", errCommandTimedOut, timeout)
+	case <-aborted:
+		slog.Warn("command aborted", "client", session.RemoteAddr, "device", session.Device)
+		err = errCommandAborted
+	}
+
+	release := *unlock
+	*unlock = func() {}
+	inFlight.hold()
+	go func() {
+		<-done
+		release()
+		inFlight.leave()
+		slog.Info("abandoned command finished", "device", session.Device, "duration", time.Since(started))
+	}()
+	return err
+}
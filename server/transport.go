@@ -0,0 +1,529 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+const (
+	// maxPendingPackets bounds the fragmented packets reassembled at once,
+	// one per client address; the oldest is dropped to make room.
+	maxPendingPackets = 256
+	// maxPendingFragmentBytes bounds the chunks they buffer together.
+	maxPendingFragmentBytes = 16 << 20
+)
+
+type pendingPacket struct {
+	key         string
+	reassembler localnet.Reassembler
+	startedAt   time.Time
+}
+
+// pendingPackets holds the packets being reassembled, oldest first, and
+// fragments indexes it by client address. pendingFragmentBytes is the sum of
+// their buffered chunks.
+var (
+	fragmentsMu          sync.Mutex
+	pendingPackets       = list.New()
+	fragments            = map[string]*list.Element{}
+	pendingFragmentBytes int
+)
+
+const (
+	// maxUDPWorkers bounds the clients served at once, one worker each. A
+	// flood beyond it is dropped as it is read rather than queued, so memory
+	// and goroutines stay bounded; clients retransmit what was lost.
+	maxUDPWorkers = 1024
+	// maxQueuedDatagrams bounds the datagrams waiting for a client's worker.
+	maxQueuedDatagrams = 64
+)
+
+var udpWorkers = make(chan struct{}, maxUDPWorkers)
+
+type udpDatagram struct {
+	data       []byte
+	remoteAddr *net.UDPAddr
+}
+
+// udpQueues holds the datagrams waiting for each client's worker in arrival
+// order, keyed by client address. A client has a queue only while its
+// worker runs.
+var (
+	udpQueuesMu sync.Mutex
+	udpQueues   = map[string][]udpDatagram{}
+)
+
+// readDeadline, when positive, wakes the UDP read loop at this interval
+// even without traffic. Shutdown does not need it: closing the socket ends
+// a blocked read at once.
+var readDeadline time.Duration
+
+func serveUDP(ctx context.Context, conn *net.UDPConn) {
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	for {
+		if readDeadline > 0 {
+			conn.SetReadDeadline(time.Now().Add(readDeadline))
+		}
+
+		buffer := make([]byte, bufferSize)
+
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case ctx.Err() != nil || errors.Is(err, net.ErrClosed):
+				return
+			case errors.As(err, &netErr) && netErr.Timeout():
+				continue
+			default:
+				slog.Error("error reading from socket", "error", err)
+				continue
+			}
+		}
+		if !addrAllowed(remoteAddr) {
+			dropBlocked(remoteAddr)
+			continue
+		}
+
+		queueDatagram(conn, udpDatagram{buffer[:n], remoteAddr})
+	}
+}
+
+// queueDatagram hands a datagram to its client's worker, starting one when
+// the client has none. Clients are served concurrently, each one's datagrams
+// in arrival order, so a retransmission waits for the original and is
+// answered from the response cache.
+func queueDatagram(conn *net.UDPConn, datagram udpDatagram) {
+	key := datagram.remoteAddr.String()
+
+	udpQueuesMu.Lock()
+	defer udpQueuesMu.Unlock()
+
+	if queue, running := udpQueues[key]; running {
+		if len(queue) >= maxQueuedDatagrams {
+			slog.Debug("dropping datagram, client queue full", "client", datagram.remoteAddr)
+			overloaded.Inc()
+			return
+		}
+		udpQueues[key] = append(queue, datagram)
+		return
+	}
+
+	select {
+	case udpWorkers <- struct{}{}:
+	default:
+		slog.Debug("dropping datagram, all workers busy", "client", datagram.remoteAddr)
+		overloaded.Inc()
+		return
+	}
+	udpQueues[key] = []udpDatagram{}
+	go serveClient(conn, key, datagram)
+}
+
+// serveClient serves datagram and then the client's queue until it is empty.
+func serveClient(conn *net.UDPConn, key string, datagram udpDatagram) {
+	defer func() { <-udpWorkers }()
+
+	for {
+		serveDatagram(conn, datagram.data, datagram.remoteAddr)
+
+		udpQueuesMu.Lock()
+		queue := udpQueues[key]
+		if len(queue) == 0 {
+			delete(udpQueues, key)
+			udpQueuesMu.Unlock()
+			return
+		}
+		datagram, udpQueues[key] = queue[0], queue[1:]
+		udpQueuesMu.Unlock()
+	}
+}
+
+// serveDatagram handles one datagram and sends the reply.
+func serveDatagram(conn *net.UDPConn, data []byte, remoteAddr *net.UDPAddr) {
+	send := func(datagram []byte) error {
+		_, err := conn.WriteToUDP(datagram, remoteAddr)
+		return err
+	}
+	for _, datagram := range handlePacket(data, remoteAddr, datagramPusher(send)) {
+		if err := send(datagram); err != nil {
+			slog.Error("error sending response", "error", err)
+			break
+		}
+	}
+}
+
+// pushFunc sends a packet the client did not ask for, such as an event, in
+// the wire its request came in.
+type pushFunc func(localnet.IPacketCmd, localnet.Wire) error
+
+func datagramPusher(send func([]byte) error) pushFunc {
+	return func(pcSnd localnet.IPacketCmd, wire localnet.Wire) error {
+		datagrams, err := localnet.EncodeFragments(pcSnd, bufferSize, wire)
+		if err != nil {
+			return err
+		}
+		for _, datagram := range datagrams {
+			if err = send(datagram); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func serveDTLS(ctx context.Context, listener net.Listener) {
+	defer listener.Close()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				slog.Error("error accepting dtls connection", "error", err)
+				continue
+			}
+		}
+		go serveDTLSConn(ctx, conn)
+	}
+}
+
+// serveDTLSConn handles one DTLS association. Handshake retransmits are
+// absorbed by the DTLS layer and never reach handleCommand, so they do not
+// refresh the session; an association idle for longer than any session may
+// be is dropped and its session is left to the regular expiry path.
+func serveDTLSConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	remoteAddr, ok := conn.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		slog.Error("unexpected dtls remote address", "address", conn.RemoteAddr())
+		return
+	}
+
+	defer dropSubscriber(remoteAddr)
+
+	// events are pushed from another goroutine
+	var writeMu sync.Mutex
+	send := func(datagram []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err := conn.Write(datagram)
+		return err
+	}
+
+	buffer := make([]byte, bufferSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(maxSessionTimeout))
+
+		n, err := conn.Read(buffer)
+		if err != nil {
+			slog.Debug("dtls connection closed", "client", remoteAddr, "error", err)
+			return
+		}
+
+		for _, datagram := range handlePacket(buffer[:n], remoteAddr, datagramPusher(send)) {
+			if err = send(datagram); err != nil {
+				slog.Error("error sending response", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// serveStream accepts length-prefixed packet streams over TCP or a unix
+// socket.
+func serveStream(ctx context.Context, listener net.Listener) {
+	defer listener.Close()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var accepted uint64
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				slog.Error("error accepting connection", "network", listener.Addr().Network(), "error", err)
+				continue
+			}
+		}
+
+		remoteAddr := conn.RemoteAddr()
+		if _, ok := listener.(*net.UnixListener); ok {
+			accepted++
+			remoteAddr = connAddr{network: "unix", id: accepted}
+		}
+		go serveStreamConn(ctx, conn, remoteAddr)
+	}
+}
+
+// connAddr names an accepted unix socket connection. Unix peers are usually
+// unnamed, so their socket address cannot tell clients apart.
+type connAddr struct {
+	network string
+	id      uint64
+}
+
+func (a connAddr) Network() string {
+	return a.network
+}
+
+func (a connAddr) String() string {
+	return fmt.Sprintf("%s#%d", a.network, a.id)
+}
+
+func serveStreamConn(ctx context.Context, conn net.Conn, remoteAddr net.Addr) {
+	serveMessages(ctx, conn, remoteAddr,
+		func() ([]byte, error) { return localnet.ReadFrame(conn) },
+		func(data []byte) error { return localnet.WriteFrame(conn, data) })
+}
+
+// serveMessages handles a connection carrying whole packets, read and
+// written by read and write, until it closes. The session and subscription
+// of the client end with it.
+func serveMessages(ctx context.Context, conn io.Closer, remoteAddr net.Addr, read func() ([]byte, error), write func([]byte) error) {
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	defer releaseSession(remoteAddr)
+	defer dropSubscriber(remoteAddr)
+
+	// events are pushed from another goroutine
+	var writeMu sync.Mutex
+	push := func(pcSnd localnet.IPacketCmd, wire localnet.Wire) error {
+		byteArray, err := localnet.EncodeWire(pcSnd, wire)
+		if err != nil {
+			packetErrors.WithLabelValues("encode").Inc()
+			return err
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		err = write(byteArray)
+		if errors.Is(err, localnet.ErrPartialFrame) {
+			// the client can no longer find the next frame
+			conn.Close()
+		}
+		return err
+	}
+
+	for {
+		data, err := read()
+		if err != nil {
+			slog.Debug("stream connection closed", "client", remoteAddr, "error", err)
+			return
+		}
+
+		pcSnd, wire := dispatch(data, remoteAddr, push)
+		if pcSnd == nil {
+			continue
+		}
+
+		if err = push(pcSnd, wire); err != nil {
+			slog.Error("error sending response", "error", err)
+			return
+		}
+	}
+}
+
+// listenUnix binds socketPath, replacing a stale socket left by an earlier
+// run, and applies mode to the socket file.
+func listenUnix(socketPath string, mode os.FileMode) (*net.UnixListener, error) {
+	if fi, err := os.Lstat(socketPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(socketPath)
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(socketPath, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error setting socket permissions %w", err)
+	}
+	return listener, nil
+}
+
+func handlePacket(data []byte, remoteAddr net.Addr, push pushFunc) [][]byte {
+	pcSnd, wire := dispatch(data, remoteAddr, push)
+	if pcSnd == nil {
+		return nil
+	}
+
+	datagrams, err := localnet.EncodeFragments(pcSnd, bufferSize, wire)
+	if err != nil {
+		slog.Error("error encoding response", "error", err)
+		packetErrors.WithLabelValues("encode").Inc()
+		return encodeError("error encoding response", wire)
+	}
+
+	slog.Debug("response ready", "to", remoteAddr, "datagrams", len(datagrams))
+	return datagrams
+}
+
+// dispatch decodes one packet and runs it through handleCommand. It returns
+// nil while a fragmented packet is still incomplete, along with the wire the
+// reply must be encoded with so the client can read it.
+func dispatch(data []byte, remoteAddr net.Addr, push pushFunc) (localnet.IPacketCmd, localnet.Wire) {
+	pcRcv, wire, err := localnet.DecodeWire(data)
+	if err != nil {
+		slog.Error("error decoding packet", "error", err, "from", remoteAddr)
+		packetErrors.WithLabelValues("decode").Inc()
+		if errors.Is(err, localnet.ErrChecksumMismatch) {
+			return localnet.NewPacketCmdErr(localnet.CmdResponse, "corrupt packet"), wire
+		}
+		if errors.Is(err, localnet.ErrPayloadTooLarge) {
+			return localnet.NewPacketErr(localnet.ErrCodeInvalidRequest, "payload too large"), wire
+		}
+		return localnet.NewPacketCmdErr(localnet.CmdResponse, "invalid packet format"), wire
+	}
+
+	if frag, ok := pcRcv.(localnet.IPacketFragment); ok {
+		pcRcv, err = reassemble(frag, remoteAddr)
+		if err != nil {
+			slog.Error("error reassembling packet", "error", err, "from", remoteAddr)
+			packetErrors.WithLabelValues("decode").Inc()
+			return errorReply(err), wire
+		}
+		if pcRcv == nil {
+			return nil, wire
+		}
+	}
+
+	slog.Debug("packet received", "packet", loggedPacket{pcRcv}, "from", remoteAddr)
+
+	// a disconnect ends the session, so its version is looked up first
+	version := peerVersion(pcRcv, remoteAddr)
+	pcSnd := handleCommand(pcRcv, remoteAddr, func(pcSnd localnet.IPacketCmd) error {
+		return push(pcSnd, wire)
+	})
+
+	if pcSnd == nil {
+		pcSnd = localnet.NewPacketCmd(localnet.CmdResponse)
+	}
+	// cached and repeated replies are shared with other requests, so the
+	// ID the client matches its request by goes on a copy
+	pcSnd = copyReply(downgradeError(pcSnd, version))
+	pcSnd.SetRequestID(pcRcv.GetRequestID())
+	return pcSnd, wire
+}
+
+// copyReply returns a shallow copy of pcSnd, whose header fields can be set
+// without touching the original. Bodies are shared, and never written.
+func copyReply(pcSnd localnet.IPacketCmd) localnet.IPacketCmd {
+	v := reflect.ValueOf(pcSnd)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return pcSnd
+	}
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	return c.Interface().(localnet.IPacketCmd)
+}
+
+// reassemble buffers a fragment and returns the complete packet once every
+// fragment from remoteAddr has arrived, or nil while some are still missing.
+// Expired packets are dropped oldest first, and so are the oldest ones when
+// the pending packets or their bytes exceed the limits.
+func reassemble(frag localnet.IPacketFragment, remoteAddr net.Addr) (localnet.IPacketCmd, error) {
+	fragmentsMu.Lock()
+	defer fragmentsMu.Unlock()
+
+	now := time.Now()
+	for front := pendingPackets.Front(); front != nil; front = pendingPackets.Front() {
+		pending := front.Value.(*pendingPacket)
+		if now.Sub(pending.startedAt) <= localnet.DefaultFragmentTimeout {
+			break
+		}
+		slog.Warn("dropping incomplete fragmented packet", "from", pending.key)
+		dropPending(front)
+	}
+
+	key := addrKey(remoteAddr)
+	elem, ok := fragments[key]
+	if !ok {
+		if pendingPackets.Len() >= maxPendingPackets {
+			front := pendingPackets.Front()
+			slog.Warn("dropping incomplete fragmented packet, too many pending", "from", front.Value.(*pendingPacket).key)
+			dropPending(front)
+		}
+		elem = pendingPackets.PushBack(&pendingPacket{key: key, startedAt: now})
+		fragments[key] = elem
+	}
+	pending := elem.Value.(*pendingPacket)
+
+	before := pending.reassembler.Size()
+	byteArray, complete, err := pending.reassembler.Add(frag)
+	pendingFragmentBytes += pending.reassembler.Size() - before
+	if err != nil {
+		dropPending(elem)
+		return nil, err
+	}
+	if complete {
+		dropPending(elem)
+		return localnet.Decode(byteArray)
+	}
+
+	for pendingFragmentBytes > maxPendingFragmentBytes {
+		front := pendingPackets.Front()
+		slog.Warn("dropping incomplete fragmented packet, too many bytes pending", "from", front.Value.(*pendingPacket).key)
+		dropPending(front)
+		if front == elem {
+			return nil, fmt.Errorf("fragmented packet dropped, over %d bytes pending: %w", maxPendingFragmentBytes, localnet.ErrPayloadTooLarge)
+		}
+	}
+	return nil, nil
+}
+
+// dropPending removes elem and its buffered bytes; callers hold fragmentsMu.
+func dropPending(elem *list.Element) {
+	pending := pendingPackets.Remove(elem).(*pendingPacket)
+	delete(fragments, pending.key)
+	pendingFragmentBytes -= pending.reassembler.Size()
+}
+
+func encodeError(errMsg string, wire localnet.Wire) [][]byte {
+	pcErr := localnet.NewPacketCmdErr(localnet.CmdResponse, errMsg)
+	data, err := localnet.EncodeWire(pcErr, wire)
+	if err != nil {
+		return nil
+	}
+	return [][]byte{data}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+)
+
+func resetFragments(t *testing.T) {
+	t.Cleanup(func() {
+		fragmentsMu.Lock()
+		defer fragmentsMu.Unlock()
+		for front := pendingPackets.Front(); front != nil; front = pendingPackets.Front() {
+			dropPending(front)
+		}
+	})
+}
+
+func fragment(index, total uint16, chunk []byte) localnet.IPacketFragment {
+	return localnet.NewPacketFragment(index, total, chunk).(localnet.IPacketFragment)
+}
+
+func udpAddr(port int) net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: port}
+}
+
+func TestReassembleEvictsOldestPacket(t *testing.T) {
+	resetFragments(t)
+
+	for port := 1; port <= maxPendingPackets+1; port++ {
+		if _, err := reassemble(fragment(0, 2, []byte{1}), udpAddr(port)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if pendingPackets.Len() != maxPendingPackets {
+		t.Fatalf("%d packets pending, want %d", pendingPackets.Len(), maxPendingPackets)
+	}
+	if _, ok := fragments[addrKey(udpAddr(1))]; ok {
+		t.Fatal("oldest packet still pending")
+	}
+	if pendingFragmentBytes != maxPendingPackets {
+		t.Fatalf("%d bytes pending, want %d", pendingFragmentBytes, maxPendingPackets)
+	}
+}
+
+func TestReassembleBoundsPendingBytes(t *testing.T) {
+	resetFragments(t)
+
+	chunk := make([]byte, maxPendingFragmentBytes/4)
+	for port := 1; port <= 4; port++ {
+		if _, err := reassemble(fragment(0, 2, chunk), udpAddr(port)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// one more chunk pushes the oldest packet out
+	if _, err := reassemble(fragment(0, 2, []byte{1}), udpAddr(5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fragments[addrKey(udpAddr(1))]; ok {
+		t.Fatal("oldest packet still pending")
+	}
+	if pendingFragmentBytes > maxPendingFragmentBytes {
+		t.Fatalf("%d bytes pending, over %d", pendingFragmentBytes, maxPendingFragmentBytes)
+	}
+
+	// a packet too large on its own is dropped with the others
+	big := make([]byte, maxPendingFragmentBytes+1)
+	if _, err := reassemble(fragment(0, 2, big), udpAddr(6)); !errors.Is(err, localnet.ErrPayloadTooLarge) {
+		t.Fatalf("got %v, want ErrPayloadTooLarge", err)
+	}
+	if pendingPackets.Len() != 0 || pendingFragmentBytes != 0 {
+		t.Fatalf("%d packets and %d bytes still pending", pendingPackets.Len(), pendingFragmentBytes)
+	}
+}
+
+func TestReassembleCompletesPacket(t *testing.T) {
+	resetFragments(t)
+
+	data, err := localnet.Encode(localnet.NewPacketCmd(localnet.CmdPing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := len(data) / 2
+	if pc, err := reassemble(fragment(1, 2, data[half:]), udpAddr(1)); pc != nil || err != nil {
+		t.Fatalf("got %v, %v before the last fragment", pc, err)
+	}
+	pc, err := reassemble(fragment(0, 2, data[:half]), udpAddr(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc.GetCmd() != localnet.CmdPing {
+		t.Fatalf("got %s, want %s", pc.GetCmd(), localnet.CmdPing)
+	}
+	if pendingPackets.Len() != 0 || pendingFragmentBytes != 0 {
+		t.Fatalf("%d packets and %d bytes still pending", pendingPackets.Len(), pendingFragmentBytes)
+	}
+}
+
+func TestUDPOverIPv6(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.BindAddr, cfg.IPVersion = "::1", "6"
+	ip, zone, err := cfg.bindIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP(cfg.network("udp"), &net.UDPAddr{IP: ip, Zone: zone})
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		serveUDP(ctx, conn)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		cleanupAllSessions()
+	})
+
+	ch, err := localnet.NewUDP(conn.LocalAddr().String(), "/dev/mock-ipv6", "mockrec", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := ch.(*localnet.NetContext)
+	if err := nc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	sessionsMu.RLock()
+	session := sessionForDevice("/dev/mock-ipv6")
+	sessionsMu.RUnlock()
+	if session == nil {
+		t.Fatal("no session for the IPv6 client")
+	}
+	if addr, ok := session.RemoteAddr.(*net.UDPAddr); !ok || addr.IP.To4() != nil || !addr.IP.IsLoopback() {
+		t.Fatalf("session client %v, want the IPv6 loopback", session.RemoteAddr)
+	}
+
+	if response, err := nc.Transmit([]byte{0x80, 0xCA, 0x00, 0x5A, 0x00}); err != nil || len(response) < 2 {
+		t.Fatalf("transmit: %X, %v", response, err)
+	}
+	if err := nc.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sendPacket runs pcRcv through the server as if it came in a datagram and
+// decodes the reply.
+func sendPacket(t *testing.T, pcRcv localnet.IPacketCmd, remoteAddr net.Addr) localnet.IPacketCmd {
+	data, err := localnet.Encode(pcRcv)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	datagrams := handlePacket(data, remoteAddr, nil)
+	if len(datagrams) != 1 {
+		t.Errorf("%d datagrams, want 1", len(datagrams))
+		return nil
+	}
+	pcSnd, err := localnet.Decode(datagrams[0])
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	return pcSnd
+}
+
+func TestRepeatAlongsideCachedReply(t *testing.T) {
+	token, _ := connectMock(t, "/dev/mock-repeat", udpAddr(1))
+	request := func(cmd localnet.IPacketCmd, id uint64) localnet.IPacketCmd {
+		cmd.SetSessionToken(token)
+		cmd.SetRequestID(id)
+		return cmd
+	}
+	transmit := func() localnet.IPacketCmd {
+		return request(localnet.NewPacketBody(localnet.CmdTransmit, []byte{0x80, 0xCA, 0x00, 0x5A, 0x00}), 5)
+	}
+	if pcSnd := sendPacket(t, transmit(), udpAddr(1)); pcSnd == nil || pcSnd.GetErr() != "" {
+		t.Fatalf("transmit: %v", pcSnd)
+	}
+
+	// the retransmit is answered from the cache and rept from the last
+	// reply, both the same reply, each to be stamped with its own ID
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if pcSnd := sendPacket(t, transmit(), udpAddr(1)); pcSnd != nil && pcSnd.GetRequestID() != 5 {
+					t.Errorf("retransmit answered with ID %d, want 5", pcSnd.GetRequestID())
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if pcSnd := sendPacket(t, request(localnet.NewPacketCmd(localnet.CmdRepeatLast), 6), udpAddr(3)); pcSnd != nil && (pcSnd.GetRequestID() != 6 || pcSnd.GetErr() != "") {
+					t.Errorf("rept answered with ID %d, error %q, want ID 6", pcSnd.GetRequestID(), pcSnd.GetErr())
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestUDPServesClientInOrder(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		serveUDP(ctx, conn)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	client, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// a burst within the client's queue, answered in the order it was sent
+	const burst = maxQueuedDatagrams / 2
+	for id := uint64(1); id <= burst; id++ {
+		pcRcv := localnet.NewPacketStatus("")
+		pcRcv.SetRequestID(id)
+		data, err := localnet.Encode(pcRcv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 64<<10)
+	for want := uint64(1); want <= burst; want++ {
+		n, err := client.Read(buffer)
+		if err != nil {
+			t.Fatalf("reply %d: %v", want, err)
+		}
+		pcSnd, err := localnet.Decode(buffer[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pcSnd.GetRequestID() != want {
+			t.Fatalf("reply to request %d, want %d", pcSnd.GetRequestID(), want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/avwarez/euicc-go/driver/localnet"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// wsPath is where the WebSocket endpoint is served.
+	wsPath = "/ws"
+	// wsPingInterval keeps proxies and NAT from dropping idle connections.
+	wsPingInterval = 30 * time.Second
+)
+
+// wsOrigins lists the Origin headers, or globs, browsers may connect from;
+// empty allows any.
+var wsOrigins []string
+
+// serveWebSocket accepts WebSocket connections on addr. Every binary message
+// carries one packet as a stream frame would, without the length prefix.
+func serveWebSocket(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle(wsPath, websocket.Server{Handshake: checkWSOrigin, Handler: func(ws *websocket.Conn) {
+		serveWSConn(ctx, ws)
+	}})
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	// hijacked connections outlive Close; serveMessages closes them on ctx
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("websocket endpoint failed", "error", err)
+		return
+	}
+	slog.Info("websocket endpoint started", "address", addr, "path", wsPath)
+	if err := server.Serve(allowedOnly(listener)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("websocket endpoint failed", "error", err)
+	}
+}
+
+// checkWSOrigin lets browsers connect from the allowed origins only.
+// Clients without an Origin header are not browsers, and pages served by
+// this host are same-origin; both are let through.
+func checkWSOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" || matchesAny(wsOrigins, origin) {
+		return nil
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == req.Host {
+		return nil
+	}
+	slog.Warn("rejecting websocket origin", "origin", origin, "client", req.RemoteAddr)
+	return errors.New("origin not allowed")
+}
+
+func serveWSConn(ctx context.Context, ws *websocket.Conn) {
+	addrPort, err := netip.ParseAddrPort(ws.Request().RemoteAddr)
+	if err != nil {
+		slog.Error("unexpected websocket remote address", "address", ws.Request().RemoteAddr)
+		return
+	}
+	remoteAddr := net.TCPAddrFromAddrPort(addrPort)
+	ws.MaxPayloadBytes = localnet.MaxFrameSize
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ws.PayloadType = websocket.PingFrame
+	go pingWS(ctx, ws)
+
+	slog.Debug("websocket connection opened", "client", remoteAddr)
+	serveMessages(ctx, ws, remoteAddr,
+		func() ([]byte, error) {
+			// a connection idle for longer than any session is dropped
+			ws.SetReadDeadline(time.Now().Add(maxSessionTimeout))
+			var data []byte
+			err := websocket.Message.Receive(ws, &data)
+			return data, err
+		},
+		func(data []byte) error { return websocket.Message.Send(ws, data) })
+}
+
+// pingWS sends a WebSocket ping every wsPingInterval until ctx is done.
+// Messages go out through websocket.Message, which sets its own frame type,
+// so the PingFrame PayloadType only ever applies to the pings written here.
+// Clients answer with a pong on their next read.
+func pingWS(ctx context.Context, ws *websocket.Conn) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := ws.Write(nil); err != nil {
+				slog.Debug("websocket ping failed", "error", err)
+				return
+			}
+		}
+	}
+}